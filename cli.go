@@ -0,0 +1,1059 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mrchip53/gta-tools/rage"
+	"github.com/mrchip53/gta-tools/rage/img"
+	"github.com/mrchip53/gta-tools/rage/rpf"
+	"github.com/mrchip53/gta-tools/rage/script"
+	"github.com/mrchip53/gta-tools/rage/script/asm"
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+	"github.com/mrchip53/gta-tools/rage/util"
+)
+
+type command struct {
+	usage string
+	run   func(args []string, out io.Writer) error
+}
+
+// commands are the headless subcommands. Anything else on the command line
+// starts the TUI.
+var commands = map[string]command{
+	"list":        {"list -img <archive> [-json]", runList},
+	"info":        {"info -img <archive> [-json]", runInfo},
+	"extract":     {"extract -img <archive> [-out dir] [name...]", runExtract},
+	"add":         {"add -img <archive> [-out output] <file...>", runAdd},
+	"remove":      {"remove -img <archive> [-out output] <name...>", runRemove},
+	"replace":     {"replace -img <archive> [-out output] <name> <file>", runReplace},
+	"pack":        {"pack -out <archive> <dir|file...>", runPack},
+	"unpack":      {"unpack -img <archive.img> -out <dir>", runUnpack},
+	"repack":      {"repack -dir <dir> -out <archive.img>", runRepack},
+	"analyze":     {"analyze -img <archive.img> [-json]", runAnalyze},
+	"defrag":      {"defrag -img <archive.img> [-out output]", runDefrag},
+	"verify":      {"verify -img <archive.img> [-json]", runVerify},
+	"diff":        {"diff [-json] <old.img> <new.img>", runDiff},
+	"script-diff": {"script-diff [-json] <old.sco> <new.sco>", runScriptDiff},
+	"disasm":      {"disasm [-out source] <script.sco>", runDisasm},
+	"asm":         {"asm -script <script.sco> [-out output] <source>", runAsm},
+	"natives":     {"natives [-json] [-natives file] [name|hash...]", runNatives},
+	"convert":     {"convert -img <archive> -out <archive> -encryption on|off", runConvert},
+}
+
+func printUsage(w io.Writer) {
+	var names []string
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "Usage: gta-tools -exe <GTAIV.exe> -img <archive>")
+	fmt.Fprintln(w, "       gta-tools <command> [-exe <GTAIV.exe>] ...")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, n := range names {
+		fmt.Fprintf(w, "  %s\n", commands[n].usage)
+	}
+	fmt.Fprintln(w, "\nCommands that write an archive accept -encryption keep|on|off.")
+	fmt.Fprintln(w, "Every command and the TUI accept -natives <file> to override native names and signatures.")
+}
+
+// runCommand runs the named subcommand with the remaining arguments.
+func runCommand(name string, args []string, out io.Writer) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return cmd.run(args, out)
+}
+
+type commonFlags struct {
+	exe        string
+	archive    string
+	output     string
+	json       bool
+	encryption string
+	natives    string
+}
+
+// newFlagSet returns a flag set holding the flags shared by every command.
+// Encrypted archives need -exe to locate the AES key.
+func newFlagSet(name string, c *commonFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&c.exe, "exe", "", "Path to the exe file holding the AES key")
+	fs.BoolVar(&c.json, "json", false, "Write JSON instead of a table")
+	fs.StringVar(&c.natives, "natives", "", "Path to a native database overriding the built-in one")
+	return fs
+}
+
+// addEncryptionFlag registers -encryption on commands that write an
+// archive.
+func addEncryptionFlag(fs *flag.FlagSet, c *commonFlags) {
+	fs.StringVar(&c.encryption, "encryption", "keep", "Encrypt the written archive: keep, on or off")
+}
+
+func (c *commonFlags) parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if c.natives != "" {
+		if err := opcode.LoadNatives(c.natives); err != nil {
+			return err
+		}
+	}
+	if c.exe == "" {
+		return nil
+	}
+	exeBytes, err := readFileToBytes(c.exe)
+	if err != nil {
+		return err
+	}
+	util.FindAesKey(exeBytes)
+	return nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// openArchive loads an archive. IMG archives are opened lazily and read
+// entry data from the file until the returned closer is closed.
+func openArchive(path string) (rage.Archive, io.Closer, error) {
+	if path == "" {
+		return nil, nil, errors.New("missing -img")
+	}
+	switch rage.GetFileType(path) {
+	case rage.FileTypeRpf:
+		data, err := readFileToBytes(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		a, err := rpf.LoadRpfFile(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		return a, nopCloser{}, nil
+	case rage.FileTypeImg:
+		return openImgFile(path)
+	}
+	return nil, nil, fmt.Errorf("%s: not an img or rpf archive", path)
+}
+
+func newArchive(path string) (rage.Archive, error) {
+	switch rage.GetFileType(path) {
+	case rage.FileTypeRpf:
+		return rpf.NewRpfFile(rpf.HEADER_MAGIC_RPF2), nil
+	case rage.FileTypeImg:
+		return img.NewImgFile(), nil
+	}
+	return nil, fmt.Errorf("%s: output must end in .img or .rpf", path)
+}
+
+type encryptable interface {
+	Encrypted() bool
+	SetEncrypted(encrypted bool)
+}
+
+// setEncryption applies an -encryption value to an archive.
+func setEncryption(a rage.Archive, mode string) error {
+	e, ok := a.(encryptable)
+	if !ok {
+		return fmt.Errorf("archive does not support encryption")
+	}
+	switch mode {
+	case "", "keep":
+	case "on":
+		e.SetEncrypted(true)
+	case "off":
+		e.SetEncrypted(false)
+	default:
+		return fmt.Errorf("invalid -encryption %q, expected keep, on or off", mode)
+	}
+	return nil
+}
+
+func saveArchive(a rage.Archive, path, source, encryption string) error {
+	if err := setEncryption(a, encryption); err != nil {
+		return err
+	}
+	return writeArchiveFile(a, path, source)
+}
+
+func findEntry(a rage.Archive, name string) rage.Entry {
+	for _, e := range a.Files() {
+		if strings.EqualFold(e.Name(), name) {
+			return e
+		}
+	}
+	return nil
+}
+
+// extractPath is where an entry is extracted to under dir. Names that would
+// land outside dir, such as absolute paths or ones climbing out with "..",
+// are rejected.
+func extractPath(dir, name string) (string, error) {
+	p := filepath.FromSlash(name)
+	if !filepath.IsLocal(p) {
+		return "", fmt.Errorf("entry name %q escapes the output directory", name)
+	}
+	return filepath.Join(dir, p), nil
+}
+
+// entryName is the name a file on disk is stored under. IMG archives are
+// flat, RPF archives keep the path relative to the packed directory.
+func entryName(a rage.Archive, root, path string) string {
+	if _, ok := a.(*rpf.RpfFile); ok && root != "" {
+		if rel, err := filepath.Rel(root, path); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.Base(path)
+}
+
+type entryRow struct {
+	Index      int    `json:"index"`
+	Name       string `json:"name"`
+	Size       int    `json:"size"`
+	Offset     int    `json:"offset"`
+	Resource   bool   `json:"resource"`
+	Compressed bool   `json:"compressed"`
+}
+
+func newEntryRow(e rage.Entry) entryRow {
+	row := entryRow{Index: e.Index(), Name: e.Name()}
+	switch e := e.(type) {
+	case *img.ImgEntry:
+		toc := e.Toc()
+		row.Size = toc.Size
+		row.Offset = toc.OffsetBlock * img.BLOCK_SIZE
+		row.Resource = toc.IsResourceFile
+	case *rpf.RpfEntry:
+		row.Size = e.Size()
+		row.Resource = e.IsResourceFile()
+		row.Compressed = e.IsCompressed()
+	default:
+		if d, err := e.ReadData(); err == nil {
+			row.Size = len(d)
+		}
+	}
+	return row
+}
+
+func writeJSON(out io.Writer, v any) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func runList(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("list", &c)
+	fs.StringVar(&c.archive, "img", "", "Path to the img or rpf file")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	a, closer, err := openArchive(c.archive)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	rows := make([]entryRow, 0, len(a.Files()))
+	for _, e := range a.Files() {
+		rows = append(rows, newEntryRow(e))
+	}
+	if c.json {
+		return writeJSON(out, rows)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "INDEX\tNAME\tSIZE\tOFFSET\tFLAGS")
+	for _, r := range rows {
+		var flags []string
+		if r.Resource {
+			flags = append(flags, "resource")
+		}
+		if r.Compressed {
+			flags = append(flags, "compressed")
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t0x%X\t%s\n", r.Index, r.Name, r.Size, r.Offset, strings.Join(flags, ","))
+	}
+	return tw.Flush()
+}
+
+type archiveInfo struct {
+	Path      string `json:"path"`
+	Format    string `json:"format"`
+	Version   int    `json:"version,omitempty"`
+	Encrypted bool   `json:"encrypted"`
+	Entries   int    `json:"entries"`
+	TocSize   int    `json:"tocSize"`
+	DataSize  int    `json:"dataSize"`
+	FileSize  int64  `json:"fileSize"`
+}
+
+func runInfo(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("info", &c)
+	fs.StringVar(&c.archive, "img", "", "Path to the img or rpf file")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	a, closer, err := openArchive(c.archive)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	st, err := os.Stat(c.archive)
+	if err != nil {
+		return err
+	}
+
+	info := archiveInfo{Path: c.archive, Entries: len(a.Files()), FileSize: st.Size()}
+	for _, e := range a.Files() {
+		info.DataSize += newEntryRow(e).Size
+	}
+	switch a := a.(type) {
+	case *img.ImgFile:
+		h := a.Header()
+		info.Format = "IMG"
+		info.Version = int(h.Version)
+		info.Encrypted = a.Encrypted()
+		info.TocSize = int(h.TocSize)
+	case *rpf.RpfFile:
+		h := a.Header()
+		info.Format = "RPF2"
+		if h.Identifier == rpf.HEADER_MAGIC_RPF3 {
+			info.Format = "RPF3"
+		}
+		info.Encrypted = h.Encrypted != 0
+		info.TocSize = int(h.TocSize)
+	}
+	if c.json {
+		return writeJSON(out, info)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Path\t%s\n", info.Path)
+	fmt.Fprintf(tw, "Format\t%s\n", info.Format)
+	if info.Version != 0 {
+		fmt.Fprintf(tw, "Version\t%d\n", info.Version)
+	}
+	fmt.Fprintf(tw, "Encrypted\t%t\n", info.Encrypted)
+	fmt.Fprintf(tw, "Entries\t%d\n", info.Entries)
+	fmt.Fprintf(tw, "TOC size\t%d\n", info.TocSize)
+	fmt.Fprintf(tw, "Data size\t%d\n", info.DataSize)
+	fmt.Fprintf(tw, "File size\t%d\n", info.FileSize)
+	return tw.Flush()
+}
+
+type changeResult struct {
+	Archive string   `json:"archive"`
+	Action  string   `json:"action"`
+	Files   []string `json:"files"`
+}
+
+func writeResult(out io.Writer, c commonFlags, r changeResult) error {
+	if c.json {
+		return writeJSON(out, r)
+	}
+	for _, f := range r.Files {
+		fmt.Fprintf(out, "%s %s\n", r.Action, f)
+	}
+	fmt.Fprintf(out, "%d file(s), archive %s\n", len(r.Files), r.Archive)
+	return nil
+}
+
+func runExtract(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("extract", &c)
+	fs.StringVar(&c.archive, "img", "", "Path to the img or rpf file")
+	fs.StringVar(&c.output, "out", ".", "Directory to extract into")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	a, closer, err := openArchive(c.archive)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	entries := a.Files()
+	if fs.NArg() > 0 {
+		entries = entries[:0:0]
+		for _, n := range fs.Args() {
+			e := findEntry(a, n)
+			if e == nil {
+				return fmt.Errorf("%s: no entry named %q", c.archive, n)
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	res := changeResult{Archive: c.archive, Action: "extracted"}
+	for _, e := range entries {
+		dst, err := extractPath(c.output, e.Name())
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.archive, err)
+		}
+		data, err := e.ReadData()
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, data, 0644); err != nil {
+			return err
+		}
+		res.Files = append(res.Files, e.Name())
+	}
+	return writeResult(out, c, res)
+}
+
+func runAdd(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("add", &c)
+	addEncryptionFlag(fs, &c)
+	fs.StringVar(&c.archive, "img", "", "Path to the img or rpf file")
+	fs.StringVar(&c.output, "out", "", "Write the archive here instead of overwriting -img")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("add: no files given")
+	}
+	a, closer, err := openArchive(c.archive)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	res := changeResult{Archive: outputPath(c), Action: "added"}
+	for _, p := range fs.Args() {
+		name := entryName(a, "", p)
+		if findEntry(a, name) != nil {
+			return fmt.Errorf("%s: entry %q already exists, use replace", c.archive, name)
+		}
+		data, err := readFileToBytes(p)
+		if err != nil {
+			return err
+		}
+		a.AddEntry(name, data)
+		res.Files = append(res.Files, name)
+	}
+	if err := saveArchive(a, res.Archive, c.archive, c.encryption); err != nil {
+		return err
+	}
+	return writeResult(out, c, res)
+}
+
+func runRemove(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("remove", &c)
+	addEncryptionFlag(fs, &c)
+	fs.StringVar(&c.archive, "img", "", "Path to the img or rpf file")
+	fs.StringVar(&c.output, "out", "", "Write the archive here instead of overwriting -img")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("remove: no names given")
+	}
+	a, closer, err := openArchive(c.archive)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	res := changeResult{Archive: outputPath(c), Action: "removed"}
+	for _, n := range fs.Args() {
+		e := findEntry(a, n)
+		if e == nil {
+			return fmt.Errorf("%s: no entry named %q", c.archive, n)
+		}
+		a.RemoveEntry(e.Index())
+		res.Files = append(res.Files, e.Name())
+	}
+	if err := saveArchive(a, res.Archive, c.archive, c.encryption); err != nil {
+		return err
+	}
+	return writeResult(out, c, res)
+}
+
+func runReplace(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("replace", &c)
+	addEncryptionFlag(fs, &c)
+	fs.StringVar(&c.archive, "img", "", "Path to the img or rpf file")
+	fs.StringVar(&c.output, "out", "", "Write the archive here instead of overwriting -img")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("replace: expected <name> <file>")
+	}
+	a, closer, err := openArchive(c.archive)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	e := findEntry(a, fs.Arg(0))
+	if e == nil {
+		return fmt.Errorf("%s: no entry named %q", c.archive, fs.Arg(0))
+	}
+	data, err := readFileToBytes(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	e.SetData(data)
+
+	res := changeResult{Archive: outputPath(c), Action: "replaced", Files: []string{e.Name()}}
+	if err := saveArchive(a, res.Archive, c.archive, c.encryption); err != nil {
+		return err
+	}
+	return writeResult(out, c, res)
+}
+
+func runPack(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("pack", &c)
+	addEncryptionFlag(fs, &c)
+	fs.StringVar(&c.output, "out", "", "Path of the img or rpf file to create")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if c.output == "" || fs.NArg() == 0 {
+		return errors.New("pack: expected -out and at least one input")
+	}
+	a, err := newArchive(c.output)
+	if err != nil {
+		return err
+	}
+
+	res := changeResult{Archive: c.output, Action: "packed"}
+	add := func(root, p string) error {
+		name := entryName(a, root, p)
+		if findEntry(a, name) != nil {
+			return fmt.Errorf("duplicate entry %q from %s", name, p)
+		}
+		data, err := readFileToBytes(p)
+		if err != nil {
+			return err
+		}
+		a.AddEntry(name, data)
+		res.Files = append(res.Files, name)
+		return nil
+	}
+	for _, in := range fs.Args() {
+		st, err := os.Stat(in)
+		if err != nil {
+			return err
+		}
+		if !st.IsDir() {
+			if err := add("", in); err != nil {
+				return err
+			}
+			continue
+		}
+		err = filepath.WalkDir(in, func(p string, d os.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			return add(in, p)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := saveArchive(a, c.output, c.archive, c.encryption); err != nil {
+		return err
+	}
+	return writeResult(out, c, res)
+}
+
+// runUnpack dumps an IMG archive with its manifest so repack can rebuild it.
+func runUnpack(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("unpack", &c)
+	fs.StringVar(&c.archive, "img", "", "Path to the img file")
+	fs.StringVar(&c.output, "out", "", "Directory to unpack into")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if c.output == "" {
+		return errors.New("unpack: missing -out")
+	}
+	a, closer, err := openArchive(c.archive)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	f, ok := a.(*img.ImgFile)
+	if !ok {
+		return fmt.Errorf("%s: unpack only supports img archives", c.archive)
+	}
+	if err := f.ExtractToDir(c.output); err != nil {
+		return err
+	}
+
+	res := changeResult{Archive: c.archive, Action: "unpacked"}
+	for _, e := range f.Entries() {
+		res.Files = append(res.Files, e.Name())
+	}
+	return writeResult(out, c, res)
+}
+
+func runRepack(args []string, out io.Writer) error {
+	var c commonFlags
+	var dir string
+	fs := newFlagSet("repack", &c)
+	addEncryptionFlag(fs, &c)
+	fs.StringVar(&dir, "dir", "", "Directory written by unpack")
+	fs.StringVar(&c.output, "out", "", "Path of the img file to create")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if dir == "" || c.output == "" {
+		return errors.New("repack: expected -dir and -out")
+	}
+	f, err := img.LoadFromDir(dir)
+	if err != nil {
+		return err
+	}
+	if err := saveArchive(f, c.output, c.archive, c.encryption); err != nil {
+		return err
+	}
+
+	res := changeResult{Archive: c.output, Action: "repacked"}
+	for _, e := range f.Entries() {
+		res.Files = append(res.Files, e.Name())
+	}
+	return writeResult(out, c, res)
+}
+
+// runConvert rewrites an archive with or without encryption.
+func runConvert(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("convert", &c)
+	addEncryptionFlag(fs, &c)
+	fs.StringVar(&c.archive, "img", "", "Path to the img or rpf file")
+	fs.StringVar(&c.output, "out", "", "Path of the converted archive")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if c.output == "" {
+		return errors.New("convert: missing -out")
+	}
+	if c.encryption != "on" && c.encryption != "off" {
+		return errors.New("convert: -encryption must be on or off")
+	}
+	a, closer, err := openArchive(c.archive)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	if err := saveArchive(a, c.output, c.archive, c.encryption); err != nil {
+		return err
+	}
+
+	res := changeResult{Archive: c.output, Action: "converted"}
+	for _, e := range a.Files() {
+		res.Files = append(res.Files, e.Name())
+	}
+	return writeResult(out, c, res)
+}
+
+func openImgArchive(path string) (*img.ImgFile, io.Closer, error) {
+	a, closer, err := openArchive(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, ok := a.(*img.ImgFile)
+	if !ok {
+		closer.Close()
+		return nil, nil, fmt.Errorf("%s: not an img archive", path)
+	}
+	return f, closer, nil
+}
+
+// runAnalyze reports free space and layout problems in an IMG archive.
+func runAnalyze(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("analyze", &c)
+	fs.StringVar(&c.archive, "img", "", "Path to the img file")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	f, closer, err := openImgArchive(c.archive)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	r := f.AnalyzeLayout()
+	if c.json {
+		return writeJSON(out, r)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Total blocks\t%d\n", r.TotalBlocks)
+	fmt.Fprintf(tw, "TOC blocks\t%d\n", r.TocBlocks)
+	fmt.Fprintf(tw, "Used blocks\t%d\n", r.UsedBlocks)
+	fmt.Fprintf(tw, "Free blocks\t%d (%d bytes)\n", r.FreeBlocks, r.FreeBlocks*img.BLOCK_SIZE)
+	for _, g := range r.Gaps {
+		fmt.Fprintf(tw, "Gap\tblocks %d-%d (%d)\n", g.Start, g.Start+g.Count-1, g.Count)
+	}
+	for _, n := range r.Unsorted {
+		fmt.Fprintf(tw, "Out of name order\t%s\n", n)
+	}
+	for _, o := range r.Overlaps {
+		fmt.Fprintf(tw, "Overlap\t%s and %s\n", o.First, o.Second)
+	}
+	for _, n := range r.OutOfBounds {
+		fmt.Fprintf(tw, "Out of bounds\t%s\n", n)
+	}
+	for _, n := range r.Unwritten {
+		fmt.Fprintf(tw, "Unwritten\t%s\n", n)
+	}
+	return tw.Flush()
+}
+
+// verifyChecks are the content checks verify runs by file extension.
+var verifyChecks = map[string]img.EntryCheck{
+	".sco": func(e *img.ImgEntry) error { return script.Check(e) },
+}
+
+// runVerify checks an IMG archive for structural problems and entries that
+// do not parse. It fails if any error was found, so it can gate a release.
+func runVerify(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("verify", &c)
+	fs.StringVar(&c.archive, "img", "", "Path to the img file")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if c.archive == "" {
+		return errors.New("missing -img")
+	}
+	f, err := os.Open(c.archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	r := img.Verify(f, st.Size(), verifyChecks)
+	if c.json {
+		if err := writeJSON(out, r); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		for _, i := range r.Issues {
+			name := i.Name
+			if i.Entry < 0 {
+				name = "(archive)"
+			} else if name == "" {
+				name = fmt.Sprintf("#%d", i.Entry)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", i.Severity, i.Check, name, i.Message)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d entries, %d checked, %d errors, %d warnings\n", r.Entries, r.Checked, r.Errors(), len(r.Issues)-r.Errors())
+	}
+	if !r.OK() {
+		return fmt.Errorf("%s: %d errors", c.archive, r.Errors())
+	}
+	return nil
+}
+
+// diffDetails drill into changed entries by file extension.
+var diffDetails = map[string]img.DiffDetail{
+	".sco": diffScripts,
+}
+
+func diffScripts(old, new *img.ImgEntry) (any, error) {
+	for _, e := range []*img.ImgEntry{old, new} {
+		if err := script.Check(e); err != nil {
+			return nil, err
+		}
+	}
+	o, n := script.NewRageScript(old), script.NewRageScript(new)
+	return script.Diff(&o, &n), nil
+}
+
+// runDiff reports the entries added, removed and changed between two IMG
+// archives, down to the subroutines of changed scripts.
+func runDiff(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("diff", &c)
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("expected an old and a new archive")
+	}
+	old, oldCloser, err := openImgArchive(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer oldCloser.Close()
+	new, newCloser, err := openImgArchive(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer newCloser.Close()
+
+	d, err := img.Diff(old, new, diffDetails)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return writeJSON(out, d)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, h := range d.Header {
+		fmt.Fprintf(tw, "header\t%s\t%d -> %d\n", h.Field, h.Old, h.New)
+	}
+	for _, e := range d.Entries {
+		fmt.Fprintf(tw, "%s\t%s\t%+d bytes\n", e.Status, e.Name, e.SizeDelta)
+		for _, t := range e.Toc {
+			fmt.Fprintf(tw, "\t  toc %s\t%d -> %d\n", t.Field, t.Old, t.New)
+		}
+		if e.DetailsError != "" {
+			fmt.Fprintf(tw, "\t  cannot compare\t%s\n", e.DetailsError)
+		}
+		if sd, ok := e.Details.(script.ScriptDiff); ok {
+			writeScriptDiffSummary(tw, sd)
+		}
+	}
+	fmt.Fprintf(tw, "\n%d changed, %d unchanged, %+d bytes\n", len(d.Entries), d.Unchanged, d.SizeDelta)
+	return tw.Flush()
+}
+
+func writeScriptDiffSummary(w io.Writer, d script.ScriptDiff) {
+	for _, h := range d.Header {
+		fmt.Fprintf(w, "\t  script %s\t%d -> %d\n", h.Field, h.Old, h.New)
+	}
+	for _, s := range d.Statics {
+		kind := "local"
+		if s.Global {
+			kind = "global"
+		}
+		fmt.Fprintf(w, "\t  %s %d\t%s -> %s\n", kind, s.Index, script.FormatStatic(s.Old), script.FormatStatic(s.New))
+	}
+	for _, f := range d.Functions {
+		name := f.NewName
+		if name == "" {
+			name = f.OldName
+		}
+		fmt.Fprintf(w, "\t  %s sub %d (%s)\t%d inserted, %d removed, %d modified\n", f.Status, f.Index, name, f.Inserted(), f.Removed(), f.Modified())
+	}
+}
+
+// loadScriptFile reads a compiled script from its own file.
+func loadScriptFile(path string) (*script.RageScript, error) {
+	data, err := readFileToBytes(path)
+	if err != nil {
+		return nil, err
+	}
+	e := rage.NewFileEntry(filepath.Base(path), data)
+	if err := script.Check(e); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	s := script.NewRageScript(e)
+	return &s, nil
+}
+
+// runScriptDiff compares two versions of a script instruction by
+// instruction, ignoring offsets that only moved.
+func runScriptDiff(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("script-diff", &c)
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("expected an old and a new script")
+	}
+	old, err := loadScriptFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	new, err := loadScriptFile(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	d := script.Diff(old, new)
+	if c.json {
+		return writeJSON(out, d)
+	}
+	if d.Empty() {
+		_, err := fmt.Fprintln(out, "Scripts are identical")
+		return err
+	}
+	_, err = io.WriteString(out, script.DiffText(old, new, d))
+	return err
+}
+
+// runDisasm writes the instructions of a script as source accepted by asm.
+func runDisasm(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("disasm", &c)
+	fs.StringVar(&c.output, "out", "", "Write the source here instead of to stdout")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("expected a script")
+	}
+	s, err := loadScriptFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	src := asm.Disassemble(s.Opcodes)
+	if c.output == "" {
+		_, err := io.WriteString(out, src)
+		return err
+	}
+	return os.WriteFile(c.output, []byte(src), 0644)
+}
+
+// runAsm assembles a source file into the code of a script. The header,
+// locals and globals are kept from -script.
+func runAsm(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("asm", &c)
+	fs.StringVar(&c.archive, "script", "", "Path to the script whose code is replaced")
+	fs.StringVar(&c.output, "out", "", "Write the script here instead of overwriting -script")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if c.archive == "" || fs.NArg() != 1 {
+		return errors.New("expected -script and a source file")
+	}
+	src, err := readFileToBytes(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	ins, err := asm.Assemble(string(src))
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	s, err := loadScriptFile(c.archive)
+	if err != nil {
+		return err
+	}
+	if err := s.ReplaceOpcodes(ins); err != nil {
+		return err
+	}
+	data, err := s.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputPath(c), data, 0644); err != nil {
+		return err
+	}
+
+	if c.json {
+		return writeJSON(out, map[string]any{"script": outputPath(c), "instructions": len(ins)})
+	}
+	fmt.Fprintf(out, "assembled %d instructions into %s\n", len(ins), outputPath(c))
+	return nil
+}
+
+// runDefrag rewrites an IMG archive with its entries sorted and packed.
+func runDefrag(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("defrag", &c)
+	addEncryptionFlag(fs, &c)
+	fs.StringVar(&c.archive, "img", "", "Path to the img file")
+	fs.StringVar(&c.output, "out", "", "Write the archive here instead of overwriting -img")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	f, closer, err := openImgArchive(c.archive)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	before := f.AnalyzeLayout()
+	f.Defrag()
+	if err := saveArchive(f, outputPath(c), c.archive, c.encryption); err != nil {
+		return err
+	}
+
+	st, err := os.Stat(outputPath(c))
+	if err != nil {
+		return err
+	}
+	freed := int64(before.TotalBlocks)*img.BLOCK_SIZE - st.Size()
+	if c.json {
+		return writeJSON(out, map[string]any{"archive": outputPath(c), "freedBytes": freed})
+	}
+	fmt.Fprintf(out, "defragmented %s, freed %d bytes\n", outputPath(c), freed)
+	return nil
+}
+
+func outputPath(c commonFlags) string {
+	if c.output != "" {
+		return c.output
+	}
+	return c.archive
+}
+
+// runNatives prints the native database, or the natives named or hashed on
+// the command line, after any -natives overrides.
+func runNatives(args []string, out io.Writer) error {
+	var c commonFlags
+	fs := newFlagSet("natives", &c)
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+
+	natives := opcode.Natives.All()
+	if fs.NArg() > 0 {
+		natives = natives[:0]
+		for _, arg := range fs.Args() {
+			n, ok := opcode.Natives.LookupName(arg)
+			if !ok {
+				if h, err := strconv.ParseUint(arg, 0, 32); err == nil {
+					n, ok = opcode.Natives.Lookup(uint32(h))
+				}
+			}
+			if !ok {
+				return fmt.Errorf("unknown native %s", arg)
+			}
+			natives = append(natives, n)
+		}
+	}
+	if c.json {
+		return writeJSON(out, natives)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HASH\tCATEGORY\tSIGNATURE\tNOTES")
+	for _, n := range natives {
+		fmt.Fprintf(tw, "0x%08X\t%s\t%s\t%s\n", n.Hash, n.Category, n.Signature(), n.Notes)
+	}
+	return tw.Flush()
+}
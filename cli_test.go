@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mrchip53/gta-tools/rage/img"
+	"github.com/mrchip53/gta-tools/rage/script"
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+func TestCommandsPackListAndExtractRpf(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "common", "data"), 0755); err != nil {
+		t.Fatalf("Failed to create input dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "common", "data", "handling.dat"), []byte("handling"), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "readme.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+
+	archive := filepath.Join(dir, "out.rpf")
+	var out bytes.Buffer
+	if err := runCommand("pack", []string{"-out", archive, src}, &out); err != nil {
+		t.Fatalf("Failed to pack: %v", err)
+	}
+
+	out.Reset()
+	if err := runCommand("remove", []string{"-img", archive, "readme.txt"}, &out); err != nil {
+		t.Fatalf("Failed to remove: %v", err)
+	}
+
+	out.Reset()
+	if err := runCommand("list", []string{"-img", archive, "-json"}, &out); err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+	var rows []entryRow
+	if err := json.Unmarshal(out.Bytes(), &rows); err != nil {
+		t.Fatalf("Failed to decode list output: %v", err)
+	}
+	if len(rows) != 1 || rows[0].Name != "common/data/handling.dat" || rows[0].Size != 8 {
+		t.Fatalf("Unexpected list output %+v", rows)
+	}
+
+	dst := filepath.Join(dir, "dst")
+	if err := runCommand("extract", []string{"-img", archive, "-out", dst}, &out); err != nil {
+		t.Fatalf("Failed to extract: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(dst, "common", "data", "handling.dat"))
+	if err != nil || string(b) != "handling" {
+		t.Errorf("Expected extracted handling.dat, got %q (%v)", b, err)
+	}
+
+	if err := runCommand("replace", []string{"-img", archive, "missing.dat", archive}, &out); err == nil {
+		t.Errorf("Expected an error replacing a missing entry")
+	}
+}
+
+func TestCommandsExtractRejectsEscapingNames(t *testing.T) {
+	dir := t.TempDir()
+	f := img.NewImgFile()
+	f.SetEncrypted(false)
+	f.AddEntry("../evil.dat", []byte("evil"))
+	b, err := f.Bytes()
+	if err != nil {
+		t.Fatalf("Failed to build archive: %v", err)
+	}
+	archive := filepath.Join(dir, "bad.img")
+	if err := os.WriteFile(archive, b, 0644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	dst := filepath.Join(dir, "dst")
+	var out bytes.Buffer
+	if err := runCommand("extract", []string{"-img", archive, "-out", dst}, &out); err == nil {
+		t.Errorf("Expected an error for an entry named ../evil.dat")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil.dat")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing written outside -out, got %v", err)
+	}
+}
+
+func TestCommandsPackAndConvertPlainImg(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.sco")
+	if err := os.WriteFile(in, []byte("script"), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+
+	archive := filepath.Join(dir, "script.img")
+	var out bytes.Buffer
+	if err := runCommand("pack", []string{"-out", archive, "-encryption", "off", in}, &out); err != nil {
+		t.Fatalf("Failed to pack: %v", err)
+	}
+
+	converted := filepath.Join(dir, "converted.img")
+	if err := runCommand("convert", []string{"-img", archive, "-out", converted, "-encryption", "off"}, &out); err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+
+	out.Reset()
+	if err := runCommand("info", []string{"-img", converted, "-json"}, &out); err != nil {
+		t.Fatalf("Failed to read info: %v", err)
+	}
+	var info archiveInfo
+	if err := json.Unmarshal(out.Bytes(), &info); err != nil {
+		t.Fatalf("Failed to decode info output: %v", err)
+	}
+	if info.Format != "IMG" || info.Encrypted || info.Entries != 1 {
+		t.Errorf("Unexpected info %+v", info)
+	}
+
+	if err := runCommand("convert", []string{"-img", archive, "-out", converted, "-encryption", "maybe"}, &out); err == nil {
+		t.Errorf("Expected an error for an invalid -encryption value")
+	}
+}
+
+func TestCommandsRewriteLazyImgInPlace(t *testing.T) {
+	dir := t.TempDir()
+	var inputs []string
+	for _, n := range []string{"a.sco", "b.sco"} {
+		p := filepath.Join(dir, n)
+		if err := os.WriteFile(p, bytes.Repeat([]byte(n), 1000), 0644); err != nil {
+			t.Fatalf("Failed to write input: %v", err)
+		}
+		inputs = append(inputs, p)
+	}
+
+	archive := filepath.Join(dir, "script.img")
+	var out bytes.Buffer
+	if err := runCommand("pack", append([]string{"-out", archive, "-encryption", "off"}, inputs[1]), &out); err != nil {
+		t.Fatalf("Failed to pack: %v", err)
+	}
+	if err := runCommand("add", []string{"-img", archive, inputs[0]}, &out); err != nil {
+		t.Fatalf("Failed to add in place: %v", err)
+	}
+
+	dst := filepath.Join(dir, "out")
+	if err := runCommand("extract", []string{"-img", archive, "-out", dst}, &out); err != nil {
+		t.Fatalf("Failed to extract: %v", err)
+	}
+	for _, p := range inputs {
+		want, _ := os.ReadFile(p)
+		got, err := os.ReadFile(filepath.Join(dst, filepath.Base(p)))
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("Expected %s to survive the in-place rewrite (%v)", filepath.Base(p), err)
+		}
+	}
+}
+
+func TestCommandsVerifyFailsOnBrokenScript(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "a.sco")
+	if err := os.WriteFile(in, []byte("not a script"), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	archive := filepath.Join(dir, "script.img")
+	var out bytes.Buffer
+	if err := runCommand("pack", []string{"-out", archive, "-encryption", "off", in}, &out); err != nil {
+		t.Fatalf("Failed to pack: %v", err)
+	}
+
+	out.Reset()
+	if err := runCommand("verify", []string{"-img", archive, "-json"}, &out); err == nil {
+		t.Errorf("Expected verify to fail on a broken script")
+	}
+	var r img.VerifyReport
+	if err := json.Unmarshal(out.Bytes(), &r); err != nil {
+		t.Fatalf("Failed to decode verify output: %v", err)
+	}
+	if r.Checked != 1 || len(r.Issues) != 1 || r.Issues[0].Check != img.CHECK_CONTENT || r.Issues[0].Name != "a.sco" {
+		t.Errorf("Unexpected report %+v", r)
+	}
+}
+
+func TestCommandsDiffArchives(t *testing.T) {
+	dir := t.TempDir()
+	var archives []string
+	for i, content := range []string{"old", "new"} {
+		in := filepath.Join(dir, content, "a.sco")
+		os.MkdirAll(filepath.Dir(in), 0755)
+		if err := os.WriteFile(in, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write input: %v", err)
+		}
+		archives = append(archives, filepath.Join(dir, fmt.Sprintf("%d.img", i)))
+		if err := runCommand("pack", []string{"-out", archives[i], "-encryption", "off", in}, io.Discard); err != nil {
+			t.Fatalf("Failed to pack: %v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := runCommand("diff", append([]string{"-json"}, archives...), &out); err != nil {
+		t.Fatalf("Failed to diff: %v", err)
+	}
+	var d img.ArchiveDiff
+	if err := json.Unmarshal(out.Bytes(), &d); err != nil {
+		t.Fatalf("Failed to decode diff output: %v", err)
+	}
+	if len(d.Entries) != 1 || d.Entries[0].Status != img.DIFF_CHANGED || d.Entries[0].DetailsError == "" {
+		t.Errorf("Expected a.sco to change and fail to parse as a script, got %+v", d.Entries)
+	}
+}
+
+func TestCommandsScriptDiff(t *testing.T) {
+	dir := t.TempDir()
+	fn := []byte{opcode.OP_FN_BEGIN, 0, 0, 0, opcode.OP_FN_END, 0, 0}
+	oldPath, newPath := filepath.Join(dir, "old.sco"), filepath.Join(dir, "new.sco")
+	if err := os.WriteFile(oldPath, script.PlainBytes(fn, nil, nil), 0644); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	edited := append([]byte{opcode.OP_FN_BEGIN, 0, 0, 0, opcode.OP_DUP}, fn[4:]...)
+	if err := os.WriteFile(newPath, script.PlainBytes(edited, nil, nil), 0644); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := runCommand("script-diff", []string{oldPath, newPath}, &out); err != nil {
+		t.Fatalf("Failed to diff scripts: %v", err)
+	}
+	if !strings.Contains(out.String(), "1 inserted, 0 removed, 0 modified") || !strings.Contains(out.String(), "+ 0x0004") {
+		t.Errorf("Unexpected script diff output:\n%s", out.String())
+	}
+}
+
+func TestCommandsDisasmAndAsmRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	code := []byte{opcode.OP_FN_BEGIN, 0, 0, 0, opcode.OP_JUMP, 9, 0, 0, 0, opcode.OP_FN_END, 0, 0}
+	sco, src := filepath.Join(dir, "main.sco"), filepath.Join(dir, "main.sca")
+	if err := os.WriteFile(sco, script.PlainBytes(code, nil, nil), 0644); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := runCommand("disasm", []string{"-out", src, sco}, &out); err != nil {
+		t.Fatalf("Failed to disassemble: %v", err)
+	}
+	rebuilt := filepath.Join(dir, "rebuilt.sco")
+	if err := runCommand("asm", []string{"-script", sco, "-out", rebuilt, src}, &out); err != nil {
+		t.Fatalf("Failed to assemble: %v", err)
+	}
+	if a, b := readFile(t, sco), readFile(t, rebuilt); !bytes.Equal(a, b) {
+		t.Errorf("Expected an unchanged round trip, got %X from %X", b, a)
+	}
+
+	source := string(readFile(t, src))
+	if err := os.WriteFile(src, []byte(strings.Replace(source, "\tJump", "\tDup\n\tJump", 1)), 0644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+	if err := runCommand("asm", []string{"-script", sco, src}, &out); err != nil {
+		t.Fatalf("Failed to assemble edited source: %v", err)
+	}
+	want := script.PlainBytes([]byte{opcode.OP_FN_BEGIN, 0, 0, 0, opcode.OP_DUP, opcode.OP_JUMP, 10, 0, 0, 0, opcode.OP_FN_END, 0, 0}, nil, nil)
+	if b := readFile(t, sco); !bytes.Equal(b, want) {
+		t.Errorf("Expected the edited script %X, got %X", want, b)
+	}
+}
+
+func readFile(t *testing.T, path string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return b
+}
+
+func TestCommandsNativesLoadsOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "natives.dat")
+	db := "[Custom]\n305419896=int MY_TEST_NATIVE(int a, float *b) ; added by a user\n"
+	if err := os.WriteFile(path, []byte(db), 0644); err != nil {
+		t.Fatalf("Failed to write natives: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := runCommand("natives", []string{"-natives", path, "MY_TEST_NATIVE", "WAIT"}, &out); err != nil {
+		t.Fatalf("Failed to list natives: %v", err)
+	}
+	for _, want := range []string{"0x12345678  Custom", "int MY_TEST_NATIVE(int a, float* b)", "void WAIT(int ms)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in:\n%s", want, out.String())
+		}
+	}
+	if err := runCommand("natives", []string{"NOT_A_NATIVE"}, &out); err == nil {
+		t.Errorf("Expected an error for an unknown native")
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -8,16 +10,23 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"path/filepath"
+	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
 
+	"github.com/mrchip53/gta-tools/rage"
+	"github.com/mrchip53/gta-tools/rage/img"
+	"github.com/mrchip53/gta-tools/rage/rpf"
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
 	"github.com/mrchip53/gta-tools/rage/util"
 )
 
 var (
-	imgPath  string
-	exePath  string
-	imgBytes []byte
+	imgPath     string
+	exePath     string
+	nativesPath string
+	imgBytes    []byte
 )
 
 func readFileToBytes(path string) ([]byte, error) {
@@ -33,12 +42,118 @@ func readFileToBytes(path string) ([]byte, error) {
 	return b, nil
 }
 
+// openImgFile opens an IMG archive without reading its entries. The
+// returned closer releases the file once the archive is no longer used.
+func openImgFile(path string) (*img.ImgFile, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	archive, err := img.OpenImgFile(f, st.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return archive, f, nil
+}
+
+// writeArchiveFile saves an archive to path, streaming it through a
+// temporary file in the same directory when the archive supports it. An
+// IMG archive saved over the file it came from is patched in place, or read
+// into memory and rewritten if its TOC no longer fits.
+func writeArchiveFile(a rage.Archive, path, source string) error {
+	if f, ok := a.(*img.ImgFile); ok && filepath.Clean(path) == filepath.Clean(source) {
+		err := patchImgFile(f, path)
+		if !errors.Is(err, img.ErrTocTooLarge) && !errors.Is(err, img.ErrNoSourceFile) {
+			return err
+		}
+		if err := f.Detach(); err != nil {
+			return err
+		}
+	}
+
+	wt, ok := a.(io.WriterTo)
+	if !ok {
+		b, err := a.Bytes()
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, b, 0644)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	bw := bufio.NewWriterSize(tmp, 1<<20)
+	if _, err := wt.WriteTo(bw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func patchImgFile(f *img.ImgFile, path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Patch(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if _, ok := commands[os.Args[1]]; ok {
+			err := runCommand(os.Args[1], os.Args[2:], os.Stdout)
+			if err != nil && !errors.Is(err, flag.ErrHelp) {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+		if !strings.HasPrefix(os.Args[1], "-") {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+			printUsage(os.Stderr)
+			os.Exit(2)
+		}
+	}
+
 	var err error
-	flag.StringVar(&imgPath, "img", imgPath, "Path to the img file")
+	flag.Usage = func() { printUsage(flag.CommandLine.Output()) }
+	flag.StringVar(&imgPath, "img", imgPath, "Path to the img or rpf file")
 	flag.StringVar(&exePath, "exe", exePath, "Path to the exe file")
+	flag.StringVar(&nativesPath, "natives", "", "Path to a native database overriding the built-in one")
 	flag.Parse()
 
+	if nativesPath != "" {
+		if err := opcode.LoadNatives(nativesPath); err != nil {
+			fmt.Printf("Error loading natives: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	exeBytes, err := readFileToBytes(exePath)
 	if err != nil {
 		panic(err)
@@ -46,15 +161,32 @@ func main() {
 
 	util.FindAesKey(exeBytes)
 
-	imgBytes, err = readFileToBytes(imgPath)
+	var archive rage.Archive
+	if rage.GetFileType(imgPath) == rage.FileTypeRpf {
+		imgBytes, err = readFileToBytes(imgPath)
+		if err != nil {
+			panic(err)
+		}
+		archive, err = rpf.LoadRpfFile(imgBytes)
+	} else {
+		var f *img.ImgFile
+		var closer io.Closer
+		f, closer, err = openImgFile(imgPath)
+		if err == nil {
+			defer closer.Close()
+			f.SetCache(true)
+			archive = f
+		}
+	}
 	if err != nil {
-		panic(err)
+		fmt.Printf("Error loading archive: %v\n", err)
+		os.Exit(1)
 	}
 
 	go func() {
 		log.Println(http.ListenAndServe("localhost:6060", nil))
 	}()
-	p := tea.NewProgram(initialModel(), tea.WithAltScreen())
+	p := tea.NewProgram(initialModel(archive), tea.WithAltScreen())
 
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Error running program: %v\n", err)
@@ -12,7 +12,7 @@ import (
 	"github.com/mrchip53/gta-tools/models"
 	"github.com/mrchip53/gta-tools/models/statusbar"
 	"github.com/mrchip53/gta-tools/rage"
-	"github.com/mrchip53/gta-tools/rage/img"
+	"github.com/mrchip53/gta-tools/rage/rsc"
 	"github.com/mrchip53/gta-tools/rage/script"
 )
 
@@ -48,6 +48,8 @@ type mainContentModel int
 
 const (
 	mainContentModelNone mainContentModel = iota
+	mainContentModelScript
+	mainContentModelResource
 )
 
 type window int
@@ -72,18 +74,19 @@ type model struct {
 
 	imgFileList      models.FileList
 	mainContentModel models.ScriptView
+	resourceView     models.ResourceView
+	mainContentKind  mainContentModel
 
-	imgFile img.ImgFile
+	archive rage.Archive
 
 	statusBar statusbar.Model
 }
 
-func initialModel() model {
-	img := img.LoadImgFile(imgBytes)
+func initialModel(archive rage.Archive) model {
 	sb := statusbar.New()
 	return model{
-		imgFile:          img,
-		imgFileList:      models.NewFileList(img),
+		archive:          archive,
+		imgFileList:      models.NewFileList(archive),
 		mainContentModel: models.NewScriptView(nil, 0, 0),
 		statusBar:        sb,
 	}
@@ -116,6 +119,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.statusWidth = m.winWidth - docStyle.GetHorizontalMargins()/2
 
 		m.mainContentModel = models.NewScriptView(nil, m.mainWidth, m.mainHeight)
+		m.mainContentKind = mainContentModelNone
 		m.imgFileList.SetSize(m.sideWidth, m.sideHeight-sidebarStyle.GetVerticalFrameSize())
 		m.ready = true
 	case tea.KeyMsg:
@@ -156,19 +160,41 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			m.imgFileList.SetActive(m.focusedWindow == sidebar)
 			m.mainContentModel.SetActive(m.focusedWindow == mainContent)
+			m.resourceView.SetActive(m.focusedWindow == mainContent)
+		case "ctrl+e":
+			if e, ok := m.archive.(interface {
+				Encrypted() bool
+				SetEncrypted(bool)
+			}); ok && !m.statusBar.HasAction() {
+				e.SetEncrypted(!e.Encrypted())
+				text := "Archive will be saved unencrypted"
+				if e.Encrypted() {
+					text = "Archive will be saved encrypted"
+				}
+				cmds = append(cmds, func() tea.Msg {
+					return statusbar.AddStatusBarMessageMsg{Text: text, Duration: 3 * time.Second}
+				})
+			}
 		case "]":
 			if !m.statusBar.HasAction() {
-				b := m.imgFile.Bytes()
-				imgPathFolder := filepath.Dir(imgPath)
-				filePath := filepath.Join(imgPathFolder, "script.img")
-				err := os.WriteFile(filePath, b, 0644)
+				fileName := "script.img"
+				if rage.GetFileType(imgPath) == rage.FileTypeRpf {
+					fileName = filepath.Base(imgPath)
+				}
+				err := writeArchiveFile(m.archive, filepath.Join(filepath.Dir(imgPath), fileName), imgPath)
 				if err != nil {
-					panic(err)
+					cmds = append(cmds, func() tea.Msg {
+						return statusbar.AddStatusBarMessageMsg{
+							Text:     "Error saving archive: " + err.Error(),
+							Duration: 5 * time.Second,
+						}
+					})
+					break
 				}
 
 				cmds = append(cmds, tea.Cmd(func() tea.Msg {
 					return statusbar.AddStatusBarMessageMsg{
-						Text:     "Saved img file to disk",
+						Text:     "Saved archive to disk",
 						Duration: 5 * time.Second,
 					}
 				}))
@@ -177,13 +203,27 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case models.FileSelectedMsg:
 		if msg.Item().FileType() == rage.FileTypeScript {
 			m.mainContentModel = models.NewScriptView(msg.Item().Entry(), m.mainWidth, m.mainHeight)
+			m.mainContentKind = mainContentModelScript
 			m.focusedWindow = mainContent
 			m.mainContentModel.SetActive(true)
 			m.imgFileList.SetActive(false)
+		} else if data, err := msg.Item().Entry().ReadData(); err != nil {
+			cmds = append(cmds, func() tea.Msg {
+				return statusbar.AddStatusBarMessageMsg{
+					Text:     "Cannot read entry: " + err.Error(),
+					Duration: 5 * time.Second,
+				}
+			})
+		} else if rsc.IsResource(data) {
+			m.resourceView = models.NewResourceView(msg.Item().Entry(), m.mainWidth, m.mainHeight)
+			m.mainContentKind = mainContentModelResource
+			m.focusedWindow = mainContent
+			m.resourceView.SetActive(true)
+			m.imgFileList.SetActive(false)
 		}
 	case models.FileDeletedMsg:
-		m.imgFile.RemoveEntry(msg.Index)
-		m.imgFileList = models.NewFileList(m.imgFile)
+		m.archive.RemoveEntry(msg.Index)
+		m.imgFileList = models.NewFileList(m.archive)
 		m.imgFileList.SetSize(m.sideWidth, m.sideHeight-sidebarStyle.GetVerticalFrameSize())
 	case statusbar.SubmitScriptFlagsMsg:
 		if m.focusedWindow == sidebar {
@@ -194,7 +234,12 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					rs := script.NewRageScript(entry)
 					if !rs.Unsupported {
 						rs.Header.ScriptFlags = msg.Flags
-						rs.Rebuild()
+						if err := rs.Rebuild(); err != nil {
+							cmds = append(cmds, func() tea.Msg {
+								return statusbar.AddStatusBarMessageMsg{Text: err.Error(), Duration: 3 * time.Second}
+							})
+							break
+						}
 
 						cmds = append(cmds, func() tea.Msg {
 							return statusbar.AddStatusBarMessageMsg{
@@ -205,7 +250,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					} else {
 						cmds = append(cmds, func() tea.Msg {
 							return statusbar.AddStatusBarMessageMsg{
-								Text:     "Cannot set flags for unsupported/compressed script.",
+								Text:     fmt.Sprintf("Cannot set flags for unsupported script: %v", rs.Err),
 								Duration: 3 * time.Second,
 							}
 						})
@@ -223,8 +268,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 			})
 		} else {
-			m.imgFile.AddEntry(msg.ArchivePath, content)
-			m.imgFileList = models.NewFileList(m.imgFile)
+			m.archive.AddEntry(msg.ArchivePath, content)
+			m.imgFileList = models.NewFileList(m.archive)
 			m.imgFileList.SetSize(m.sideWidth, m.sideHeight-sidebarStyle.GetVerticalFrameSize())
 			cmds = append(cmds, func() tea.Msg {
 				return statusbar.AddStatusBarMessageMsg{
@@ -242,6 +287,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if m.focusedWindow == sidebar {
 			m.imgFileList, cmd = m.imgFileList.Update(msg)
 			cmds = append(cmds, cmd)
+		} else if m.mainContentKind == mainContentModelResource {
+			m.resourceView, cmd = m.resourceView.Update(msg)
+			cmds = append(cmds, cmd)
 		} else {
 			m.mainContentModel, cmd = m.mainContentModel.Update(msg)
 			cmds = append(cmds, cmd)
@@ -258,6 +306,9 @@ func (m model) View() string {
 
 	// Build main content view
 	mainContentViewStr := m.mainContentModel.View()
+	if m.mainContentKind == mainContentModelResource {
+		mainContentViewStr = m.resourceView.View()
+	}
 	mStyle := mainContentStyle.Width(m.mainWidth).Height(m.mainHeight)
 	if m.focusedWindow == mainContent {
 		mStyle = mainContentActiveStyle.Width(m.mainWidth).Height(m.mainHeight)
@@ -275,7 +326,7 @@ func (m model) View() string {
 	statusBarText := m.statusBar.View()
 	statusBarView := statusBarInfoStyle.Width(m.statusWidth).Render(statusBarText)
 	if statusBarText == "" {
-		statusBarView = statusBarHelpStyle.Width(m.statusWidth).Render("q: quit | tab: switch focus | s: save")
+		statusBarView = statusBarHelpStyle.Width(m.statusWidth).Render("q: quit | tab: switch focus | ]: save | ctrl+e: toggle encryption")
 	}
 
 	// Combine views
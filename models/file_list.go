@@ -9,7 +9,6 @@ import (
 	"github.com/charmbracelet/lipgloss"
 
 	"github.com/mrchip53/gta-tools/rage"
-	"github.com/mrchip53/gta-tools/rage/img"
 )
 
 type FileDeletedMsg struct{ Index int }
@@ -21,16 +20,16 @@ func (m FileSelectedMsg) Item() listItem { return m.item }
 // TODO move to rage package
 type listItem struct {
 	name     string
-	entry    *img.ImgEntry
+	entry    rage.Entry
 	fileType rage.FileType
 }
 
 func (i listItem) FilterValue() string     { return i.name }
 func (i listItem) Name() string            { return i.name }
-func (i listItem) Entry() *img.ImgEntry    { return i.entry }
+func (i listItem) Entry() rage.Entry       { return i.entry }
 func (i listItem) FileType() rage.FileType { return i.fileType }
 
-func newListItem(f *img.ImgEntry) list.Item {
+func newListItem(f rage.Entry) list.Item {
 	t := rage.GetFileType(f.Name())
 	return listItem{name: f.Name(), fileType: t, entry: f}
 }
@@ -72,10 +71,10 @@ type FileList struct {
 	active bool
 }
 
-func NewFileList(img img.ImgFile) FileList {
+func NewFileList(archive rage.Archive) FileList {
 	var items []list.Item
 
-	for _, v := range img.Entries() {
+	for _, v := range archive.Files() {
 		items = append(items, newListItem(v))
 	}
 
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/mrchip53/gta-tools/rage"
+	"github.com/mrchip53/gta-tools/rage/img"
+	"github.com/mrchip53/gta-tools/rage/rsc"
+)
+
+// resourceDumpSize is how much of a segment the hex dump shows.
+const resourceDumpSize = 0x400
+
+// ResourceView shows the RSC header of a resource entry and a hex dump of
+// the start of its inflated segments.
+type ResourceView struct {
+	entry    rage.Entry
+	resource *rsc.Resource
+	err      error
+	vp       viewport.Model
+	active   bool
+	graphics bool
+}
+
+func NewResourceView(entry rage.Entry, w, h int) ResourceView {
+	m := ResourceView{entry: entry, vp: viewport.New(w, h-1)}
+	data, err := entry.ReadData()
+	if err != nil {
+		m.err = err
+	} else {
+		m.resource, m.err = rsc.Load(data)
+	}
+	m.Refresh()
+	return m
+}
+
+func (m ResourceView) Init() tea.Cmd {
+	return nil
+}
+
+func (m ResourceView) Update(msg tea.Msg) (ResourceView, tea.Cmd) {
+	if !m.active {
+		return m, nil
+	}
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "v":
+			m.graphics = !m.graphics
+			m.Refresh()
+		case "up":
+			m.vp.ScrollUp(1)
+		case "down":
+			m.vp.ScrollDown(1)
+		case "pgup":
+			m.vp.PageUp()
+		case "pgdown":
+			m.vp.PageDown()
+		}
+	}
+	return m, nil
+}
+
+func (m *ResourceView) Refresh() {
+	var sb strings.Builder
+	if m.err != nil {
+		fmt.Fprintf(&sb, "Cannot read resource: %v\n", m.err)
+		m.vp.SetContent(sb.String())
+		return
+	}
+
+	r := m.resource
+	h := r.Header
+	fmt.Fprintf(&sb, "Type:            %s (version %d)\n", h.TypeName(), h.Type)
+	fmt.Fprintf(&sb, "Flags:           0x%08X\n", h.Flags)
+	fmt.Fprintf(&sb, "System size:     0x%X (%d inflated)\n", h.SystemSize(), len(r.System))
+	fmt.Fprintf(&sb, "Graphics size:   0x%X (%d inflated)\n", h.GraphicsSize(), len(r.Graphics))
+	fmt.Fprintf(&sb, "Compressed size: %d\n", r.CompressedSize())
+	if e, ok := m.entry.(*img.ImgEntry); ok {
+		toc := e.Toc()
+		fmt.Fprintf(&sb, "TOC:             type %d, flags 0x%08X, %d blocks at block %d\n", toc.RsourceType, uint32(toc.RscFlags), toc.UsedBlocks, toc.OffsetBlock)
+	}
+
+	seg, name := r.System, "System"
+	if m.graphics {
+		seg, name = r.Graphics, "Graphics"
+	}
+	fmt.Fprintf(&sb, "\n%s segment (v: switch)\n", name)
+	sb.WriteString(hex.Dump(seg[:min(len(seg), resourceDumpSize)]))
+	if len(seg) > resourceDumpSize {
+		fmt.Fprintf(&sb, "... %d more bytes\n", len(seg)-resourceDumpSize)
+	}
+	m.vp.SetContent(lipgloss.NewStyle().Width(m.vp.Width).Render(sb.String()))
+}
+
+func (m ResourceView) View() string {
+	return lipgloss.JoinVertical(lipgloss.Center, m.entry.Name(), m.vp.View())
+}
+
+func (m *ResourceView) SetActive(active bool) {
+	m.active = active
+}
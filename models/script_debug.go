@@ -0,0 +1,195 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/mrchip53/gta-tools/rage/script"
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+	"github.com/mrchip53/gta-tools/rage/script/vm"
+)
+
+// maxNativeLog is how many native calls the debugger keeps.
+const maxNativeLog = 100
+
+var debugErrorStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5555"))
+
+// scriptDebugger runs the script in the emulator, one command at a time,
+// and stops at breakpoints. It shares the instructions and statics of the
+// view's script, so the view refuses edits while it is active.
+type scriptDebugger struct {
+	script      *script.RageScript
+	machine     *vm.Machine
+	entry       int // index of the FnBegin the machine starts at
+	breakpoints map[int]bool
+	natives     []vm.NativeCall
+	err         error
+}
+
+// newScriptDebugger prepares a debugger for s, stopped at the start of the
+// subroutine around the instruction at index.
+func newScriptDebugger(s script.RageScript, index int) (*scriptDebugger, error) {
+	entry := -1
+	for i := min(index, len(s.Opcodes)-1); i >= 0; i-- {
+		if s.Opcodes[i].GetOpcode() == opcode.OP_FN_BEGIN {
+			entry = i
+			break
+		}
+	}
+	if entry == -1 {
+		return nil, fmt.Errorf("no subroutine at the cursor")
+	}
+	d := &scriptDebugger{script: &s, entry: entry, breakpoints: make(map[int]bool)}
+	if err := d.restart(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// restart starts the entry subroutine over on fresh memory with zeroed
+// parameters. Breakpoints are kept.
+func (d *scriptDebugger) restart() error {
+	m := vm.New(d.script)
+	m.OnNative = func(c vm.NativeCall) {
+		d.natives = append(d.natives, c)
+		if len(d.natives) > maxNativeLog {
+			d.natives = d.natives[len(d.natives)-maxNativeLog:]
+		}
+	}
+	fn := d.script.Opcodes[d.entry]
+	params := make([]uint32, fn.GetOperands()[0].(uint8))
+	if err := m.Start(fn.GetOffset(), params...); err != nil {
+		return err
+	}
+	d.machine = m
+	d.natives = nil
+	d.err = nil
+	return nil
+}
+
+func (d *scriptDebugger) toggleBreakpoint(index int) {
+	if d.breakpoints[index] {
+		delete(d.breakpoints, index)
+	} else {
+		d.breakpoints[index] = true
+	}
+}
+
+// runUntil steps at least once and then until stop reports true, a
+// breakpoint is reached or the machine halts.
+func (d *scriptDebugger) runUntil(stop func() bool) error {
+	m := d.machine
+	if m.Halted {
+		return vm.ErrHalted
+	}
+	for n := 0; ; n++ {
+		if n >= vm.DefaultMaxSteps {
+			d.err = vm.ErrStepLimit
+			return d.err
+		}
+		if err := m.Step(); err != nil {
+			d.err = err
+			return err
+		}
+		if m.Halted || d.breakpoints[m.PC] || stop() {
+			return nil
+		}
+	}
+}
+
+func (d *scriptDebugger) stepInto() error {
+	return d.runUntil(func() bool { return true })
+}
+
+// stepOver steps, running a Call through to its return.
+func (d *scriptDebugger) stepOver() error {
+	m := d.machine
+	if m.Halted || m.Script.Opcodes[m.PC].GetOpcode() != opcode.OP_CALL {
+		return d.stepInto()
+	}
+	depth := len(m.Frames)
+	return d.runUntil(func() bool { return len(m.Frames) <= depth })
+}
+
+// stepOut runs until the current subroutine returns.
+func (d *scriptDebugger) stepOut() error {
+	m := d.machine
+	depth := len(m.Frames)
+	return d.runUntil(func() bool { return len(m.Frames) < depth })
+}
+
+func (d *scriptDebugger) cont() error {
+	return d.runUntil(func() bool { return false })
+}
+
+func (d *scriptDebugger) runTo(index int) error {
+	return d.runUntil(func() bool { return d.machine.PC == index })
+}
+
+// pc returns the instruction to highlight, or -1 once the entry subroutine
+// has returned.
+func (d *scriptDebugger) pc() int {
+	if d.machine.Halted && d.err == nil {
+		return -1
+	}
+	return d.machine.PC
+}
+
+// status describes where the machine stopped.
+func (d *scriptDebugger) status() string {
+	m := d.machine
+	switch {
+	case d.err != nil:
+		return d.err.Error()
+	case m.Halted:
+		return fmt.Sprintf("Returned %v after %d steps", m.Stack, m.Steps)
+	case d.breakpoints[m.PC]:
+		return fmt.Sprintf("Breakpoint at 0x%04X", m.Script.Opcodes[m.PC].GetOffset())
+	}
+	return fmt.Sprintf("Stopped at 0x%04X after %d steps", m.Script.Opcodes[m.PC].GetOffset(), m.Steps)
+}
+
+// panel renders the operand stack, the current frame, the statics and the
+// native call log, each cut to fit height lines in total.
+func (d *scriptDebugger) panel(height int, floats map[int]bool) string {
+	m := d.machine
+	section := max((height-5)/4, 1)
+	var sb strings.Builder
+
+	if d.err != nil {
+		sb.WriteString(debugErrorStyle.Render(d.err.Error()) + "\n")
+	}
+
+	fmt.Fprintf(&sb, "%s (%d)\n", diffHeadingStyle.Render("Stack"), len(m.Stack))
+	for i := len(m.Stack) - 1; i >= max(len(m.Stack)-section, 0); i-- {
+		fmt.Fprintf(&sb, "  %d: %s\n", i, script.FormatVar(uint(m.Stack[i]), false))
+	}
+
+	frameTitle := "Frame"
+	if f := m.Frame(); f != nil {
+		frameTitle = fmt.Sprintf("Frame %s (depth %d)", d.script.Subroutines[f.Function], len(m.Frames))
+	}
+	sb.WriteString(diffHeadingStyle.Render(frameTitle) + "\n")
+	for i, v := range m.FrameVars(m.Frame()) {
+		if i >= section {
+			break
+		}
+		fmt.Fprintf(&sb, "  Var %d: %s\n", i, script.FormatVar(uint(v), false))
+	}
+
+	sb.WriteString(diffHeadingStyle.Render("Statics") + "\n")
+	for i, v := range m.Statics {
+		if i >= section {
+			break
+		}
+		fmt.Fprintf(&sb, "  Local %d: %s\n", i, script.FormatVar(uint(v), floats[i]))
+	}
+
+	sb.WriteString(diffHeadingStyle.Render("Natives") + "\n")
+	for _, c := range d.natives[max(len(d.natives)-section, 0):] {
+		fmt.Fprintf(&sb, "  0x%04X %s\n", c.Offset, c)
+	}
+	return sb.String()
+}
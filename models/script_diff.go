@@ -0,0 +1,65 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/mrchip53/gta-tools/rage/script"
+)
+
+var (
+	diffRemovedStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5555"))
+	diffInsertedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#44FF44"))
+	diffModifiedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFF00"))
+	diffHeadingStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("63")).Bold(true)
+)
+
+// renderSideBySide lays out a script diff in two columns, the old script
+// on the left and the new one on the right, one changed subroutine after
+// another.
+func renderSideBySide(old, new *script.RageScript, d script.ScriptDiff, width int) string {
+	var sb strings.Builder
+	if d.Empty() {
+		return "Scripts are identical"
+	}
+	for _, h := range d.Header {
+		fmt.Fprintf(&sb, "%s: %d -> %d\n", h.Field, h.Old, h.New)
+	}
+	for _, s := range d.Statics {
+		kind := "Local"
+		if s.Global {
+			kind = "Global"
+		}
+		fmt.Fprintf(&sb, "%s %d: %s -> %s\n", kind, s.Index, script.FormatStatic(s.Old), script.FormatStatic(s.New))
+	}
+
+	col := max((width-3)/2, 10)
+	cell := lipgloss.NewStyle().Width(col).MaxWidth(col)
+	for _, f := range d.Functions {
+		sb.WriteString(diffHeadingStyle.Render(fmt.Sprintf("\nsub %d (%s): %d inserted, %d removed, %d modified", f.Index, f.Status, f.Inserted(), f.Removed(), f.Modified())))
+		sb.WriteString("\n")
+		for _, l := range f.Lines {
+			left, right := "", ""
+			if l.Old != -1 {
+				left = old.LineText(l.Old)
+			}
+			if l.New != -1 {
+				right = new.LineText(l.New)
+			}
+			sep := " │ "
+			switch l.Op {
+			case script.LINE_REMOVE:
+				left, sep = diffRemovedStyle.Render(left), diffRemovedStyle.Render(" < ")
+			case script.LINE_INSERT:
+				right, sep = diffInsertedStyle.Render(right), diffInsertedStyle.Render(" > ")
+			case script.LINE_MODIFY:
+				left, right = diffModifiedStyle.Render(left), diffModifiedStyle.Render(right)
+				sep = diffModifiedStyle.Render(" ~ ")
+			}
+			sb.WriteString(cell.Render(left) + sep + cell.Render(right) + "\n")
+		}
+	}
+	return sb.String()
+}
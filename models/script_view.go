@@ -2,7 +2,11 @@ package models
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/charmbracelet/bubbles/list"
@@ -11,12 +15,17 @@ import (
 	"github.com/charmbracelet/lipgloss"
 
 	"github.com/mrchip53/gta-tools/models/statusbar"
+	"github.com/mrchip53/gta-tools/rage"
 	"github.com/mrchip53/gta-tools/rage/img"
 	"github.com/mrchip53/gta-tools/rage/script"
+	"github.com/mrchip53/gta-tools/rage/script/decompiler"
 	"github.com/mrchip53/gta-tools/rage/script/opcode"
 )
 
-var highlightedLine = lipgloss.NewStyle().Foreground(lipgloss.Color("63")).Bold(true)
+var (
+	highlightedLine = lipgloss.NewStyle().Foreground(lipgloss.Color("63")).Bold(true)
+	stackIssueStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5555"))
+)
 
 type basicItem struct {
 	name string
@@ -24,6 +33,15 @@ type basicItem struct {
 
 func (i basicItem) FilterValue() string { return i.name }
 
+type scriptPane int
+
+const (
+	paneCode scriptPane = iota
+	paneLocals
+	paneGlobals
+	paneRefs
+)
+
 type ScriptView struct {
 	data            []byte
 	script          script.RageScript
@@ -44,17 +62,43 @@ type ScriptView struct {
 	localsList  list.Model
 	globalsList list.Model
 
-	listStyle lipgloss.Style
+	pane         scriptPane
+	floatLocals  map[int]bool
+	floatGlobals map[int]bool
+
+	decompiled bool
+	diffing    bool
+	debug      *scriptDebugger
+
+	refsList list.Model
+	refs     []script.Ref
+
+	stackIssues map[int][]script.StackIssue
+
+	listStyle       lipgloss.Style
+	activeListStyle lipgloss.Style
+}
+
+func varItems(prefix string, vars []uint, floats map[int]bool) []list.Item {
+	var items []list.Item
+	for i, v := range vars {
+		items = append(items, listItem{name: fmt.Sprintf("%s %d: %s", prefix, i, script.FormatVar(v, floats[i]))})
+	}
+	return items
 }
 
-func NewScriptView(entry *img.ImgEntry, w, h int) ScriptView {
+func NewScriptView(entry rage.Entry, w, h int) ScriptView {
 	vp := viewport.New(w/3*2, h-1)
 
 	if entry == nil {
 		return ScriptView{vp: vp}
 	}
 
-	data := entry.Data()
+	data, err := entry.ReadData()
+	if err != nil {
+		vp.SetContent(fmt.Sprintf("Cannot read script: %v", err))
+		return ScriptView{vp: vp}
+	}
 
 	if data == nil {
 		vp.SetContent("No script selected")
@@ -63,6 +107,9 @@ func NewScriptView(entry *img.ImgEntry, w, h int) ScriptView {
 
 	script := script.NewRageScript(entry)
 	str := lipgloss.NewStyle().Width(w).Render(script.String(0, 0, h, -1, -1))
+	if script.Err != nil {
+		str = fmt.Sprintf("Unsupported script: %v", script.Err)
+	}
 	vp.SetContent(str)
 
 	boxHeight := (h-1)/3 - 2
@@ -85,36 +132,36 @@ func NewScriptView(entry *img.ImgEntry, w, h int) ScriptView {
 	sl.SetShowStatusBar(false)
 	sl.SetShowHelp(false)
 
-	var locals []list.Item
-	for i, v := range script.Locals {
-		locals = append(locals, listItem{name: fmt.Sprintf("Local %d: %v", i, v)})
-	}
-	ll := list.New(locals, d, 30, boxHeight-bs.GetVerticalFrameSize())
+	ll := list.New(varItems("Local", script.Locals, nil), d, 30, boxHeight-bs.GetVerticalFrameSize())
 	ll.Title = "Locals"
 	ll.SetShowStatusBar(false)
 	ll.SetShowHelp(false)
 
-	var globals []list.Item
-	for i, v := range script.Globals {
-		globals = append(globals, listItem{name: fmt.Sprintf("Global %d: %v", i, v)})
-	}
-	gl := list.New(globals, d, 30, boxHeight-bs.GetVerticalFrameSize())
+	gl := list.New(varItems("Global", script.Globals, nil), d, 30, boxHeight-bs.GetVerticalFrameSize())
 	gl.Title = "Globals"
 	gl.SetShowStatusBar(false)
 	gl.SetShowHelp(false)
 
+	rl := list.New(nil, d, 30, boxHeight-bs.GetVerticalFrameSize())
+	rl.SetShowStatusBar(false)
+	rl.SetShowHelp(false)
+
 	return ScriptView{
-		data:        data,
-		script:      script,
-		vp:          vp,
-		subsList:    sl,
-		localsList:  ll,
-		globalsList: gl,
-		height:      h,
-		width:       w,
-		listStyle:   bs,
-		marker1:     -1,
-		marker2:     -1,
+		data:            data,
+		script:          script,
+		vp:              vp,
+		subsList:        sl,
+		localsList:      ll,
+		globalsList:     gl,
+		refsList:        rl,
+		height:          h,
+		width:           w,
+		listStyle:       bs,
+		activeListStyle: bs.BorderForeground(lipgloss.Color("228")),
+		floatLocals:     make(map[int]bool),
+		floatGlobals:    make(map[int]bool),
+		marker1:         -1,
+		marker2:         -1,
 	}
 }
 
@@ -131,6 +178,30 @@ func (m ScriptView) Update(msg tea.Msg) (ScriptView, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if msg.String() == "v" && m.data != nil {
+			m.pane = (m.pane + 1) % 3
+			return m, nil
+		}
+		if m.pane == paneRefs {
+			return m.updateRefs(msg)
+		}
+		if m.pane != paneCode {
+			return m.updateVars(msg)
+		}
+		if m.debug != nil {
+			return m.updateDebug(msg)
+		}
+		if m.decompiled {
+			return m.updateDecompiled(msg)
+		}
+		if m.diffing {
+			return m.updateDiff(msg)
+		}
+		if len(m.script.Opcodes) == 0 && msg.String() != "o" {
+			// A script that did not disassemble has no instructions to
+			// move to, inspect or edit.
+			return m, nil
+		}
 		switch msg.String() {
 		case "up":
 			m.scroll(-1)
@@ -144,18 +215,37 @@ func (m ScriptView) Update(msg tea.Msg) (ScriptView, tea.Cmd) {
 			op := m.script.Opcodes[m.highlightedLine]
 			opc := op.GetOpcode()
 			if opc == opcode.OP_JUMP || opc == opcode.OP_JUMP_FALSE || opc == opcode.OP_JUMP_TRUE || opc == opcode.OP_CALL {
-				offset := op.GetOperands()[0].(uint32)
-				for i, o := range m.script.Opcodes {
-					if o.GetOffset() == int(offset) {
-						m.highlightedLine = i
-						break
-					}
-				}
-				if m.highlightedLine < m.codeOffset || m.highlightedLine > m.codeOffset+m.vp.Height-1 {
-					m.codeOffset = max(m.highlightedLine-5, 0)
+				m.jumpToOffset(int(op.GetOperands()[0].(uint32)))
+			}
+			if sw, ok := op.(*opcode.Switch); ok {
+				if len(sw.Cases) == 1 {
+					m.jumpToOffset(int(sw.Cases[0].Target))
+					break
 				}
-				m.Refresh()
+				cmds = append(cmds, func() tea.Msg {
+					return statusbar.ActivateInputActionMsg{
+						ID:     "switchCase",
+						Prompt: "Jump to case:",
+					}
+				})
+			}
+		case "g":
+			title, refs := m.script.Xrefs().RefsAt(&m.script, m.highlightedLine)
+			if len(refs) == 0 {
+				cmds = append(cmds, func() tea.Msg {
+					return statusbar.AddStatusBarMessageMsg{Text: "No references found", Duration: 3 * time.Second}
+				})
+				break
+			}
+			items := make([]list.Item, len(refs))
+			for i, r := range refs {
+				items[i] = listItem{name: r.String()}
 			}
+			m.refs = refs
+			m.refsList.Title = title
+			m.refsList.SetItems(items)
+			m.refsList.Select(0)
+			m.pane = paneRefs
 		case "/":
 			cmds = append(cmds, func() tea.Msg {
 				return statusbar.ActivateInputActionMsg{
@@ -193,19 +283,53 @@ func (m ScriptView) Update(msg tea.Msg) (ScriptView, tea.Cmd) {
 		case "?":
 			m.script.ToggleByteCode()
 			m.Refresh()
+		case "x":
+			out, err := decompiler.DecompileAt(&m.script, m.highlightedLine)
+			if err != nil {
+				cmds = append(cmds, func() tea.Msg {
+					return statusbar.AddStatusBarMessageMsg{Text: err.Error(), Duration: 3 * time.Second}
+				})
+				break
+			}
+			m.decompiled = true
+			m.vp.SetContent(lipgloss.NewStyle().Width(m.vp.Width).Render(out))
+			m.vp.GotoTop()
+		case "D":
+			cmds = append(cmds, func() tea.Msg {
+				return statusbar.ActivateInputActionMsg{
+					ID:     "diffPath",
+					Prompt: "Compare with .sco file (empty: as opened):",
+				}
+			})
+		case "B":
+			d, err := newScriptDebugger(m.script, m.highlightedLine)
+			if err != nil {
+				cmds = append(cmds, func() tea.Msg {
+					return statusbar.AddStatusBarMessageMsg{Text: err.Error(), Duration: 3 * time.Second}
+				})
+				break
+			}
+			m.debug = d
+			m.jumpTo(d.machine.PC)
 		case "r":
-			m.script.RemoveInstruction(m.highlightedLine)
+			cmds = append(cmds, m.edited(m.script.RemoveInstruction(m.highlightedLine)))
 			m.Refresh()
 		case "d":
-			m.script.DuplicateInstruction(m.highlightedLine)
+			cmds = append(cmds, m.edited(m.script.DuplicateInstruction(m.highlightedLine)))
 			m.Refresh()
 		case "m":
-			m.script.MoveInstruction(m.highlightedLine, m.highlightedLine+1)
-			m.highlightedLine++
+			err := m.script.MoveInstruction(m.highlightedLine, m.highlightedLine+1)
+			if err == nil {
+				m.highlightedLine++
+			}
+			cmds = append(cmds, m.edited(err))
 			m.Refresh()
 		case "M":
-			m.script.MoveInstruction(m.highlightedLine, m.highlightedLine-1)
-			m.highlightedLine--
+			err := m.script.MoveInstruction(m.highlightedLine, m.highlightedLine-1)
+			if err == nil {
+				m.highlightedLine--
+			}
+			cmds = append(cmds, m.edited(err))
 			m.Refresh()
 		case " ":
 			if m.marker1 == -1 {
@@ -221,9 +345,17 @@ func (m ScriptView) Update(msg tea.Msg) (ScriptView, tea.Cmd) {
 			}
 			m.Refresh()
 		case "t":
+			d, err := m.script.Entry.ReadData()
+			info := fmt.Sprintf("%s %d", m.script.Entry.Name(), len(d))
+			if e, ok := m.script.Entry.(*img.ImgEntry); ok {
+				info = fmt.Sprintf("%+v %d", e.Toc(), len(d))
+			}
+			if err != nil {
+				info = fmt.Sprintf("Cannot read %s: %v", m.script.Entry.Name(), err)
+			}
 			cmds = append(cmds, func() tea.Msg {
 				return statusbar.AddStatusBarMessageMsg{
-					Text:     fmt.Sprintf("%+v %d", m.script.Entry.Toc(), len(m.script.Entry.Data())),
+					Text:     info,
 					Duration: 5 * time.Second,
 				}
 			})
@@ -233,14 +365,54 @@ func (m ScriptView) Update(msg tea.Msg) (ScriptView, tea.Cmd) {
 			m.searchText = msg.InputText
 			//m.jumpToNextSearch(false)
 		}
+		if msg.ID == "switchCase" {
+			if err := m.jumpToCase(msg.InputText); err != nil {
+				cmds = append(cmds, func() tea.Msg {
+					return statusbar.AddStatusBarMessageMsg{Text: err.Error(), Duration: 3 * time.Second}
+				})
+			}
+		}
+		if msg.ID == "diffPath" {
+			if err := m.showDiff(msg.InputText); err != nil {
+				cmds = append(cmds, func() tea.Msg {
+					return statusbar.AddStatusBarMessageMsg{Text: err.Error(), Duration: 3 * time.Second}
+				})
+			}
+		}
+		if (msg.ID == "editLocal" || msg.ID == "editGlobal") && m.debug != nil {
+			cmds = append(cmds, debugEditError())
+			break
+		}
+		if msg.ID == "editLocal" || msg.ID == "editGlobal" {
+			v, err := script.ParseVar(msg.InputText)
+			if err != nil {
+				cmds = append(cmds, func() tea.Msg {
+					return statusbar.AddStatusBarMessageMsg{Text: err.Error(), Duration: 3 * time.Second}
+				})
+				break
+			}
+			if msg.ID == "editLocal" {
+				err = m.script.SetLocal(m.localsList.Index(), v)
+			} else {
+				err = m.script.SetGlobal(m.globalsList.Index(), v)
+			}
+			cmds = append(cmds, writeError(err))
+			m.refreshVars()
+		}
 	case statusbar.OpcodeAndArgsInputResultMsg:
+		if m.debug != nil {
+			cmds = append(cmds, debugEditError())
+			break
+		}
 		o := m.script.GetOffset(m.highlightedLine)
 		op := opcode.NewInstruction(o, msg.Opcode, msg.Args)
+		var err error
 		if msg.ID == "insert" {
-			m.script.InsertInstruction(m.highlightedLine, op)
+			err = m.script.InsertInstruction(m.highlightedLine, op)
 		} else if msg.ID == "edit" {
-			m.script.EditInstruction(m.highlightedLine, op)
+			err = m.script.EditInstruction(m.highlightedLine, op)
 		}
+		cmds = append(cmds, m.edited(err))
 		m.Refresh()
 	}
 
@@ -250,18 +422,60 @@ func (m ScriptView) Update(msg tea.Msg) (ScriptView, tea.Cmd) {
 func (m *ScriptView) scroll(offset int) {
 	d := m.highlightedLine - m.codeOffset
 	m.highlightedLine += offset
-	if m.highlightedLine < 0 {
-		m.highlightedLine = 0
-	}
 	if m.highlightedLine > len(m.script.Opcodes)-1 {
 		m.highlightedLine = len(m.script.Opcodes) - 1
 	}
+	if m.highlightedLine < 0 {
+		m.highlightedLine = 0
+	}
 	if m.highlightedLine < m.codeOffset || m.highlightedLine > m.codeOffset+m.vp.Height-1 {
 		m.codeOffset = max(m.highlightedLine-d, 0)
 	}
 	m.Refresh()
 }
 
+// jumpTo highlights the instruction at index, scrolling it into view.
+func (m *ScriptView) jumpTo(index int) {
+	m.highlightedLine = index
+	if m.highlightedLine < m.codeOffset || m.highlightedLine > m.codeOffset+m.vp.Height-1 {
+		m.codeOffset = max(m.highlightedLine-5, 0)
+	}
+	m.Refresh()
+}
+
+// jumpToOffset highlights the instruction starting at a code offset.
+func (m *ScriptView) jumpToOffset(offset int) {
+	for i, o := range m.script.Opcodes {
+		if o.GetOffset() == offset {
+			m.jumpTo(i)
+			return
+		}
+	}
+}
+
+// jumpToCase follows the case of the highlighted switch whose value is
+// given in text.
+func (m *ScriptView) jumpToCase(text string) error {
+	if m.highlightedLine >= len(m.script.Opcodes) {
+		return fmt.Errorf("no switch selected")
+	}
+	sw, ok := m.script.Opcodes[m.highlightedLine].(*opcode.Switch)
+	if !ok {
+		return fmt.Errorf("no switch selected")
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(text), 0, 32)
+	if err != nil {
+		return fmt.Errorf("invalid case value %q", text)
+	}
+	for _, c := range sw.Cases {
+		if c.Value == int32(v) {
+			m.jumpToOffset(int(c.Target))
+			return nil
+		}
+	}
+	return fmt.Errorf("switch has no case %d", v)
+}
+
 func (m *ScriptView) jumpToNextSearch(reverse bool) {
 	nextIdx := m.script.FindNextOpcode(m.searchText, m.highlightedLine, reverse)
 	if nextIdx != -1 {
@@ -271,21 +485,316 @@ func (m *ScriptView) jumpToNextSearch(reverse bool) {
 }
 
 func (m *ScriptView) Refresh() {
+	m.decompiled = false
+	m.diffing = false
 	if m.data == nil {
 		m.vp.SetContent("No script selected")
 		return
 	}
+	if m.script.Err != nil {
+		m.vp.SetContent(fmt.Sprintf("Unsupported script: %v", m.script.Err))
+		return
+	}
 
-	str := lipgloss.NewStyle().Width(m.vp.Width).Render(m.script.String(m.highlightedLine, m.codeOffset, m.vp.Height, m.marker1, m.marker2))
+	code := m.script.String(m.highlightedLine, m.codeOffset, m.vp.Height, m.marker1, m.marker2)
+	if m.debug != nil {
+		code = m.debug.script.DebugString(m.debug.pc(), m.highlightedLine, m.codeOffset, m.vp.Height, m.debug.breakpoints)
+	}
+	if m.debug == nil && len(m.stackIssues) > 0 {
+		code = m.flagStackIssues(code)
+	}
+	str := lipgloss.NewStyle().Width(m.vp.Width).Render(code)
 	m.vp.SetContent(str)
 }
 
+// edited checks the stack after an edit, or reports why the edit could not
+// be written back to the entry.
+func (m *ScriptView) edited(err error) tea.Cmd {
+	if err != nil {
+		return writeError(err)
+	}
+	return m.verifyStack()
+}
+
+// debugEditError refuses an edit while the debugger runs the script.
+func debugEditError() tea.Cmd {
+	return func() tea.Msg {
+		return statusbar.AddStatusBarMessageMsg{Text: "Stop debugging with B before editing", Duration: 3 * time.Second}
+	}
+}
+
+// writeError reports a script that could not be written back.
+func writeError(err error) tea.Cmd {
+	if err == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		return statusbar.AddStatusBarMessageMsg{Text: "Cannot write script: " + err.Error(), Duration: 3 * time.Second}
+	}
+}
+
+func (m *ScriptView) verifyStack() tea.Cmd {
+	had := len(m.stackIssues) > 0
+	issues := m.script.VerifyStack()
+	m.stackIssues = make(map[int][]script.StackIssue)
+	for _, i := range issues {
+		m.stackIssues[i.Index] = append(m.stackIssues[i.Index], i)
+	}
+
+	var text string
+	switch {
+	case len(issues) > 0:
+		text = fmt.Sprintf("Stack check: %d issues, first %s", len(issues), issues[0])
+	case had:
+		text = "Stack check passed"
+	default:
+		return nil
+	}
+	return func() tea.Msg {
+		return statusbar.AddStatusBarMessageMsg{Text: text, Duration: 5 * time.Second}
+	}
+}
+
+// flagStackIssues appends the stack issues of each rendered instruction to
+// its line.
+func (m *ScriptView) flagStackIssues(code string) string {
+	lines := strings.Split(code, "\n")
+	for j := range lines {
+		for _, i := range m.stackIssues[m.codeOffset+j] {
+			lines[j] += stackIssueStyle.Render(fmt.Sprintf(" !! %s: %s", i.Kind, i.Message))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// updateDecompiled scrolls the pseudo-code of the current subroutine until
+// "x" switches back to the disassembly.
+func (m ScriptView) updateDecompiled(msg tea.KeyMsg) (ScriptView, tea.Cmd) {
+	switch msg.String() {
+	case "x":
+		m.decompiled = false
+		m.Refresh()
+	case "up":
+		m.vp.ScrollUp(1)
+	case "down":
+		m.vp.ScrollDown(1)
+	case "pgup":
+		m.vp.PageUp()
+	case "pgdown":
+		m.vp.PageDown()
+	}
+	return m, nil
+}
+
+// showDiff compares the script against the .sco file at path, or against
+// the script as it was opened if path is empty, and shows the two side by
+// side.
+func (m *ScriptView) showDiff(path string) error {
+	e := rage.NewFileEntry(m.script.Name, m.data)
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		e = rage.NewFileEntry(filepath.Base(path), data)
+	}
+	if err := script.Check(e); err != nil {
+		return err
+	}
+	old := script.NewRageScript(e)
+	d := script.Diff(&old, &m.script)
+	m.diffing = true
+	m.vp.SetContent(renderSideBySide(&old, &m.script, d, m.vp.Width))
+	m.vp.GotoTop()
+	return nil
+}
+
+// updateDiff scrolls the side by side diff until "D" switches back to the
+// disassembly.
+func (m ScriptView) updateDiff(msg tea.KeyMsg) (ScriptView, tea.Cmd) {
+	switch msg.String() {
+	case "D":
+		m.Refresh()
+	case "up":
+		m.vp.ScrollUp(1)
+	case "down":
+		m.vp.ScrollDown(1)
+	case "pgup":
+		m.vp.PageUp()
+	case "pgdown":
+		m.vp.PageDown()
+	}
+	return m, nil
+}
+
+// updateDebug handles keys in debug mode. Execution commands move the
+// cursor to where the machine stopped and "B" leaves debug mode.
+func (m ScriptView) updateDebug(msg tea.KeyMsg) (ScriptView, tea.Cmd) {
+	d := m.debug
+	var err error
+	switch msg.String() {
+	case "B":
+		m.debug = nil
+		m.Refresh()
+		return m, nil
+	case "up":
+		m.scroll(-1)
+		return m, nil
+	case "down":
+		m.scroll(1)
+		return m, nil
+	case "pgup":
+		m.scroll(-m.vp.Height)
+		return m, nil
+	case "pgdown":
+		m.scroll(m.vp.Height)
+		return m, nil
+	case "b":
+		d.toggleBreakpoint(m.highlightedLine)
+		m.Refresh()
+		return m, nil
+	case "s":
+		err = d.stepInto()
+	case "o":
+		err = d.stepOver()
+	case "u":
+		err = d.stepOut()
+	case "c":
+		err = d.cont()
+	case "h":
+		err = d.runTo(m.highlightedLine)
+	case "R":
+		err = d.restart()
+	default:
+		return m, nil
+	}
+	if pc := d.pc(); pc != -1 {
+		m.jumpTo(pc)
+	} else {
+		m.Refresh()
+	}
+	text := d.status()
+	if err != nil && d.err == nil {
+		text = err.Error()
+	}
+	return m, func() tea.Msg {
+		return statusbar.AddStatusBarMessageMsg{Text: text, Duration: 3 * time.Second}
+	}
+}
+
+// updateRefs handles keys while the references pane has focus. Enter jumps
+// to the selected reference and "g" closes the pane.
+func (m ScriptView) updateRefs(msg tea.KeyMsg) (ScriptView, tea.Cmd) {
+	var cmd tea.Cmd
+	switch msg.String() {
+	case "enter":
+		if len(m.refs) > 0 {
+			m.jumpTo(m.refs[m.refsList.Index()].Index)
+		}
+	case "g":
+		m.refs = nil
+		m.pane = paneCode
+	default:
+		m.refsList, cmd = m.refsList.Update(msg)
+	}
+	return m, cmd
+}
+
+// updateVars handles keys while the locals or globals pane has focus.
+func (m ScriptView) updateVars(msg tea.KeyMsg) (ScriptView, tea.Cmd) {
+	var cmd tea.Cmd
+	floats, id := m.floatLocals, "editLocal"
+	l := &m.localsList
+	if m.pane == paneGlobals {
+		floats, id = m.floatGlobals, "editGlobal"
+		l = &m.globalsList
+	}
+
+	switch msg.String() {
+	case "e", "a", "r":
+		if m.debug != nil {
+			return m, debugEditError()
+		}
+	}
+
+	switch msg.String() {
+	case "e":
+		if len(l.Items()) > 0 {
+			cmd = func() tea.Msg {
+				return statusbar.ActivateInputActionMsg{ID: id, Prompt: "Value (int, 0x hex or float):"}
+			}
+		}
+	case "f":
+		floats[l.Index()] = !floats[l.Index()]
+	case "a":
+		var err error
+		if m.pane == paneLocals {
+			err = m.script.AddLocal(0)
+		} else {
+			err = m.script.AddGlobal(0)
+		}
+		cmd = writeError(err)
+	case "r":
+		if len(l.Items()) > 0 {
+			idx := l.Index()
+			var err error
+			if m.pane == paneLocals {
+				err = m.script.RemoveLocal(idx)
+			} else {
+				err = m.script.RemoveGlobal(idx)
+			}
+			cmd = writeError(err)
+			removeFloatFlag(floats, idx)
+		}
+	default:
+		*l, cmd = l.Update(msg)
+		return m, cmd
+	}
+	m.refreshVars()
+	return m, cmd
+}
+
+// removeFloatFlag drops the float flag of a removed slot and moves the flags
+// of the slots after it down by one to follow their values.
+func removeFloatFlag(floats map[int]bool, idx int) {
+	moved := make(map[int]bool)
+	for k, v := range floats {
+		if k >= idx {
+			delete(floats, k)
+			if k > idx {
+				moved[k-1] = v
+			}
+		}
+	}
+	for k, v := range moved {
+		floats[k] = v
+	}
+}
+
+func (m *ScriptView) refreshVars() {
+	m.localsList.SetItems(varItems("Local", m.script.Locals, m.floatLocals))
+	m.globalsList.SetItems(varItems("Global", m.script.Globals, m.floatGlobals))
+}
+
 func (m ScriptView) View() string {
-	subsList := m.subsList.View()
-	// localsList := m.localsList.View()
-	// globalsList := ""
-	// rightPane := lipgloss.JoinVertical(lipgloss.Left, m.listStyle.Render(subsList), m.listStyle.Render(localsList), m.listStyle.Render(globalsList))
-	bottomPane := lipgloss.JoinHorizontal(lipgloss.Top, m.vp.View(), m.listStyle.Render(subsList))
+	paneStyle := func(p scriptPane) lipgloss.Style {
+		if m.active && m.pane == p {
+			return m.activeListStyle
+		}
+		return m.listStyle
+	}
+	topPane := m.listStyle.Render(m.subsList.View())
+	if m.refs != nil {
+		topPane = paneStyle(paneRefs).Render(m.refsList.View())
+	}
+	localsList := m.localsList.View()
+	globalsList := m.globalsList.View()
+	rightPane := lipgloss.JoinVertical(lipgloss.Left, topPane, paneStyle(paneLocals).Render(localsList), paneStyle(paneGlobals).Render(globalsList))
+	if m.debug != nil {
+		h := m.vp.Height - m.listStyle.GetVerticalFrameSize()
+		rightPane = m.activeListStyle.Height(h).MaxHeight(m.vp.Height).Render(m.debug.panel(h, m.floatLocals))
+	}
+	bottomPane := lipgloss.JoinHorizontal(lipgloss.Top, m.vp.View(), rightPane)
 	str := lipgloss.JoinVertical(lipgloss.Center, m.script.Name, bottomPane)
 	return str
 }
@@ -1,6 +1,7 @@
 package statusbar
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"strings"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mrchip53/gta-tools/rage/script/asm"
 	"github.com/mrchip53/gta-tools/rage/script/opcode"
 )
 
@@ -93,8 +95,26 @@ func (a *OpcodeAndArgsAction) Update(msg tea.Msg) (Action, tea.Cmd) {
 					a.currentStep = 2
 					a.textInput.SetValue("")
 					a.textInput.Prompt = "Enter Args (hex): "
+					if a.enteredOpcode == opcode.OP_CALL_NATIVE {
+						a.textInput.Prompt = "Enter Native (NAME [in=N out=N] or hex): "
+					}
 					return a, a.textInput.Focus()
 				}
+			} else if a.currentStep == 2 && a.enteredOpcode == opcode.OP_CALL_NATIVE {
+				args, err := nativeArgs(inputText, a.offset)
+				if err != nil {
+					cmds = append(cmds, func() tea.Msg {
+						return AddStatusBarMessageMsg{Text: err.Error(), Duration: 3 * time.Second}
+					})
+					return a, tea.Batch(cmds...)
+				}
+				a.resultMsg = OpcodeAndArgsInputResultMsg{
+					ID:     a.id,
+					Opcode: a.enteredOpcode,
+					Args:   args,
+				}
+				a.done = true
+				return a, nil
 			} else if a.currentStep == 2 { // Processing args
 				var decodedArgs []byte
 				var err error
@@ -171,6 +191,19 @@ func (a *OpcodeAndArgsAction) Description() string {
 			}
 		}
 		return "Input opcode name or hex (e.g., 0A, FF)"
+	} else if a.currentStep == 2 && a.enteredOpcode == opcode.OP_CALL_NATIVE {
+		if v == "" {
+			return "Enter a native name, optionally with in=N out=N, or the args in hex"
+		}
+		args, err := nativeArgs(v, a.offset)
+		if err != nil {
+			return err.Error()
+		}
+		desc := opcode.NewInstruction(a.offset, a.enteredOpcode, args).String("#FFFF00", nil)
+		if n, ok := opcode.Natives.Lookup(binary.LittleEndian.Uint32(args[2:6])); ok && n.Typed {
+			desc += "  " + n.Signature()
+		}
+		return desc
 	} else if a.currentStep == 2 { // Args input
 		if v == "" {
 			if opcode.GetInstructionLength(a.enteredOpcode, 0)-1 == 0 {
@@ -203,6 +236,25 @@ func (a *OpcodeAndArgsAction) Description() string {
 	return ""
 }
 
+// nativeArgs parses the arguments of a CallNative typed as hex or as
+// "NAME [in=N out=N]" and checks them against the native's signature.
+func nativeArgs(text string, offset int) ([]byte, error) {
+	args, err := hex.DecodeString(text)
+	if err != nil || len(args) != 6 {
+		ins, _, err := asm.ParseInstruction("CallNative "+text, offset)
+		if err != nil {
+			return nil, err
+		}
+		args = ins.GetArgs()
+	}
+	if n, ok := opcode.Natives.Lookup(binary.LittleEndian.Uint32(args[2:6])); ok {
+		if err := n.CheckCall(int(args[0]), int(args[1])); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
 func (a *OpcodeAndArgsAction) ID() string {
 	return a.id
 }
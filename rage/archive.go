@@ -0,0 +1,34 @@
+package rage
+
+// Entry is a single file stored in an archive.
+type Entry interface {
+	Name() string
+	// ReadData returns a copy of the entry contents.
+	ReadData() ([]byte, error)
+	SetData(data []byte)
+	Index() int
+}
+
+// Archive is a container of entries that can be edited and written back.
+type Archive interface {
+	Files() []Entry
+	AddEntry(name string, data []byte)
+	RemoveEntry(idx int)
+	Bytes() ([]byte, error)
+}
+
+// FileEntry is an Entry that does not belong to an archive, such as a
+// script loaded from its own file.
+type FileEntry struct {
+	name string
+	data []byte
+}
+
+func NewFileEntry(name string, data []byte) *FileEntry {
+	return &FileEntry{name: name, data: data}
+}
+
+func (e *FileEntry) Name() string              { return e.name }
+func (e *FileEntry) ReadData() ([]byte, error) { return append([]byte(nil), e.data...), nil }
+func (e *FileEntry) SetData(data []byte)       { e.data = data }
+func (e *FileEntry) Index() int                { return 0 }
@@ -0,0 +1,212 @@
+package img
+
+import (
+	"bytes"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Entry statuses reported by Diff.
+const (
+	DIFF_ADDED   = "added"
+	DIFF_REMOVED = "removed"
+	DIFF_CHANGED = "changed"
+	// DIFF_MOVED entries have the same contents and only changed blocks.
+	DIFF_MOVED = "moved"
+)
+
+// FieldChange is a header or TOC field that differs.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   int64  `json:"old"`
+	New   int64  `json:"new"`
+}
+
+// EntryDiff describes an entry that differs between two archives.
+type EntryDiff struct {
+	Name        string        `json:"name"`
+	Status      string        `json:"status"`
+	OldSize     int           `json:"oldSize"`
+	NewSize     int           `json:"newSize"`
+	SizeDelta   int           `json:"sizeDelta"`
+	DataChanged bool          `json:"dataChanged"`
+	Toc         []FieldChange `json:"toc"`
+
+	// Details is what the DiffDetail for the entry's extension returned,
+	// or DetailsError why it failed.
+	Details      any    `json:"details,omitempty"`
+	DetailsError string `json:"detailsError,omitempty"`
+}
+
+// ArchiveDiff lists the differences between two archives. Entries are
+// matched by name, ignoring case, and sorted by name.
+type ArchiveDiff struct {
+	Header    []FieldChange `json:"header"`
+	Entries   []EntryDiff   `json:"entries"`
+	Unchanged int           `json:"unchanged"`
+	SizeDelta int64         `json:"sizeDelta"`
+}
+
+// Empty reports whether the archives hold the same entries.
+func (d ArchiveDiff) Empty() bool {
+	for _, e := range d.Entries {
+		if e.Status != DIFF_MOVED {
+			return false
+		}
+	}
+	return true
+}
+
+// DiffDetail compares the contents of an entry present in both archives
+// whose data changed, for example by parsing both versions.
+type DiffDetail func(old, new *ImgEntry) (any, error)
+
+// Diff compares two archives. details maps lower case file extensions such
+// as ".sco" to a comparison run on changed entries with that extension.
+func Diff(old, new *ImgFile, details map[string]DiffDetail) (ArchiveDiff, error) {
+	d := ArchiveDiff{
+		Header:  diffFields(headerFields(old), headerFields(new)),
+		Entries: []EntryDiff{},
+	}
+
+	newByName := make(map[string]*ImgEntry, len(new.entries))
+	for _, e := range new.entries {
+		newByName[strings.ToLower(e.name)] = e
+	}
+	seen := make(map[string]bool, len(old.entries))
+	for _, o := range old.entries {
+		key := strings.ToLower(o.name)
+		seen[key] = true
+		n, ok := newByName[key]
+		if !ok {
+			d.Entries = append(d.Entries, EntryDiff{Name: o.name, Status: DIFF_REMOVED, OldSize: o.Size(), SizeDelta: -o.Size(), Toc: []FieldChange{}})
+			continue
+		}
+
+		ed, err := diffEntry(o, n, details)
+		if err != nil {
+			return ArchiveDiff{}, err
+		}
+		if ed == nil {
+			d.Unchanged++
+			continue
+		}
+		d.Entries = append(d.Entries, *ed)
+	}
+	for _, n := range new.entries {
+		if !seen[strings.ToLower(n.name)] {
+			d.Entries = append(d.Entries, EntryDiff{Name: n.name, Status: DIFF_ADDED, NewSize: n.Size(), SizeDelta: n.Size(), Toc: []FieldChange{}})
+		}
+	}
+
+	sort.Slice(d.Entries, func(i, j int) bool {
+		return strings.ToLower(d.Entries[i].Name) < strings.ToLower(d.Entries[j].Name)
+	})
+	for _, e := range d.Entries {
+		d.SizeDelta += int64(e.SizeDelta)
+	}
+	return d, nil
+}
+
+// diffEntry compares an entry present in both archives. It returns nil if
+// nothing changed.
+func diffEntry(o, n *ImgEntry, details map[string]DiffDetail) (*EntryDiff, error) {
+	ed := &EntryDiff{
+		Name:      n.name,
+		Status:    DIFF_CHANGED,
+		OldSize:   o.Size(),
+		NewSize:   n.Size(),
+		SizeDelta: n.Size() - o.Size(),
+		Toc:       diffFields(tocFields(o.toc), tocFields(n.toc)),
+	}
+	if o.Size() != n.Size() {
+		ed.DataChanged = true
+	} else {
+		od, err := o.ReadData()
+		if err != nil {
+			return nil, err
+		}
+		nd, err := n.ReadData()
+		if err != nil {
+			return nil, err
+		}
+		ed.DataChanged = !bytes.Equal(od, nd)
+	}
+
+	if !ed.DataChanged {
+		if len(ed.Toc) == 0 {
+			return nil, nil
+		}
+		if onlyLayout(ed.Toc) {
+			ed.Status = DIFF_MOVED
+		}
+		return ed, nil
+	}
+	if detail := details[strings.ToLower(path.Ext(n.name))]; detail != nil {
+		v, err := detail(o, n)
+		if err != nil {
+			ed.DetailsError = err.Error()
+		} else {
+			ed.Details = v
+		}
+	}
+	return ed, nil
+}
+
+// onlyLayout reports whether changes only concern where an entry is stored.
+func onlyLayout(changes []FieldChange) bool {
+	for _, c := range changes {
+		if c.Field != "OffsetBlock" {
+			return false
+		}
+	}
+	return true
+}
+
+type field struct {
+	name  string
+	value int64
+}
+
+func headerFields(f *ImgFile) []field {
+	h := f.header
+	encrypted := int64(0)
+	if f.encrypted {
+		encrypted = 1
+	}
+	return []field{
+		{"Version", int64(h.Version)},
+		{"EntryCount", int64(h.EntryCount)},
+		{"TocSize", int64(h.TocSize)},
+		{"TocEntrySize", int64(h.TocEntrySize)},
+		{"Unknown1", int64(h.Unknown1)},
+		{"Encrypted", encrypted},
+	}
+}
+
+func tocFields(t TocEntry) []field {
+	resource := int64(0)
+	if t.IsResourceFile {
+		resource = 1
+	}
+	return []field{
+		{"IsResourceFile", resource},
+		{"Size", int64(t.Size)},
+		{"RscFlags", int64(uint32(t.RscFlags))},
+		{"ResourceType", int64(t.RsourceType)},
+		{"OffsetBlock", int64(t.OffsetBlock)},
+		{"UsedBlocks", int64(t.UsedBlocks)},
+		{"Flags", int64(t.Flags)},
+	}
+}
+
+func diffFields(old, new []field) []FieldChange {
+	changes := []FieldChange{}
+	for i := range old {
+		if old[i].value != new[i].value {
+			changes = append(changes, FieldChange{Field: old[i].name, Old: old[i].value, New: new[i].value})
+		}
+	}
+	return changes
+}
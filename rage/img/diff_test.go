@@ -0,0 +1,67 @@
+package img
+
+import (
+	"testing"
+)
+
+func TestDiffReportsEntryChanges(t *testing.T) {
+	old := NewImgFile()
+	old.AddEntry("a.sco", []byte("old script"))
+	old.AddEntry("b.dat", []byte("same"))
+	old.AddEntry("c.dat", []byte("gone"))
+
+	new := NewImgFile()
+	new.AddEntry("A.SCO", []byte("new script!"))
+	new.AddEntry("b.dat", []byte("same"))
+	new.AddEntry("d.dat", []byte("added"))
+
+	var compared string
+	d, err := Diff(old, new, map[string]DiffDetail{
+		".sco": func(o, n *ImgEntry) (any, error) {
+			compared = o.Name() + ">" + n.Name()
+			return n.Size() - o.Size(), nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to diff archives: %v", err)
+	}
+	if d.Unchanged != 1 || len(d.Entries) != 3 || len(d.Header) != 0 {
+		t.Fatalf("Unexpected diff %+v", d)
+	}
+
+	a, c, dd := d.Entries[0], d.Entries[1], d.Entries[2]
+	if a.Name != "A.SCO" || a.Status != DIFF_CHANGED || !a.DataChanged || a.SizeDelta != 1 {
+		t.Errorf("Unexpected change %+v", a)
+	}
+	if len(a.Toc) != 1 || a.Toc[0] != (FieldChange{Field: "Size", Old: 10, New: 11}) {
+		t.Errorf("Unexpected toc changes %+v", a.Toc)
+	}
+	if compared != "a.sco>A.SCO" || a.Details != 1 {
+		t.Errorf("Expected script details, got %q and %v", compared, a.Details)
+	}
+	if c.Name != "c.dat" || c.Status != DIFF_REMOVED || dd.Name != "d.dat" || dd.Status != DIFF_ADDED {
+		t.Errorf("Unexpected removed and added entries %+v %+v", c, dd)
+	}
+	if d.SizeDelta != 2 {
+		t.Errorf("Expected a size delta of 2, got %d", d.SizeDelta)
+	}
+}
+
+func TestDiffReportsMovedEntries(t *testing.T) {
+	old := NewImgFile()
+	old.AddEntry("a.dat", []byte("a"))
+	old.AddEntry("b.dat", []byte("b"))
+	new := NewImgFile()
+	new.AddEntry("b.dat", []byte("b"))
+
+	d, err := Diff(old, new, nil)
+	if err != nil {
+		t.Fatalf("Failed to diff archives: %v", err)
+	}
+	if len(d.Entries) != 2 || d.Entries[1].Status != DIFF_MOVED || d.Entries[1].DataChanged {
+		t.Errorf("Expected b.dat to have moved, got %+v", d.Entries)
+	}
+	if d.Empty() {
+		t.Errorf("Expected a removed entry to make the diff non-empty")
+	}
+}
@@ -3,31 +3,98 @@ package img
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
+	"io"
 )
 
+// source is the reader an opened archive reads entry data from.
+type source struct {
+	r     io.ReaderAt
+	cache bool
+}
+
 type ImgEntry struct {
 	idx  int
 	name string
 	toc  TocEntry
 	data []byte
+
+	// Entries of an opened archive read size bytes at offset from src until
+	// data is loaded or replaced.
+	src    *source
+	offset int64
+	size   int
+	cached bool
+
+	// disk is where the entry's blocks are in the file the archive was
+	// loaded from, nil if it has none yet. dirty marks data Patch must write.
+	disk  *diskSpan
+	dirty bool
+}
+
+// diskSpan is a run of blocks allocated to an entry in an archive file, of
+// which the first Used hold the entry as its TOC record describes it.
+type diskSpan struct {
+	Block  int
+	Blocks int
+	Used   int
 }
 
 func (e ImgEntry) Name() string { return e.name }
 
-func (e ImgEntry) Data() []byte {
-	d := make([]byte, len(e.data))
-	copy(d, e.data)
-	return d
+// ReadData returns a copy of the entry contents, reading them from the
+// archive if they are not in memory.
+func (e *ImgEntry) ReadData() ([]byte, error) {
+	if e.src == nil || e.cached {
+		d := make([]byte, len(e.data))
+		copy(d, e.data)
+		return d, nil
+	}
+	d := make([]byte, e.size)
+	if _, err := e.src.r.ReadAt(d, e.offset); err != nil {
+		return nil, fmt.Errorf("read %s: %w", e.name, err)
+	}
+	if e.src.cache {
+		e.data = append([]byte(nil), d...)
+		e.cached = true
+	}
+	return d, nil
+}
+
+// Size is the length of the entry contents without reading them.
+func (e *ImgEntry) Size() int {
+	if e.src == nil || e.cached {
+		return len(e.data)
+	}
+	return e.size
 }
 
 func (e ImgEntry) Toc() TocEntry { return e.toc }
 
-func (e *ImgEntry) SetData(data []byte) { e.data = data }
+// SetData replaces the entry data. A resource entry picks up the type and
+// flags of the new data's RSC header so the TOC matches what is stored.
+func (e *ImgEntry) SetData(data []byte) {
+	e.data = data
+	e.src = nil
+	e.cached = false
+	e.dirty = true
+	if !e.toc.IsResourceFile {
+		return
+	}
+	if t, flags, ok := resourceInfo(data); ok {
+		e.toc.RsourceType = t
+		e.toc.RscFlags = flags
+	}
+}
 
 func (e ImgEntry) Index() int { return e.idx }
 
-func (e ImgEntry) Hash() string {
+func (e *ImgEntry) Hash() (string, error) {
+	d, err := e.ReadData()
+	if err != nil {
+		return "", err
+	}
 	hasher := sha256.New()
-	hasher.Write(e.data)
-	return hex.EncodeToString(hasher.Sum(nil))
+	hasher.Write(d)
+	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
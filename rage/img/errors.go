@@ -0,0 +1,44 @@
+package img
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrEntryTooLarge is returned when an entry needs more blocks than its TOC
+// record can describe.
+var ErrEntryTooLarge = errors.New("img: entry needs more than 0xFFFF blocks")
+
+// HeaderError reports an IMG header field that is missing or out of range.
+type HeaderError struct {
+	Field  string
+	Value  int64
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("img header: %s = %d: %s", e.Field, e.Value, e.Reason)
+}
+
+// TocError reports a TOC record that could not be read.
+type TocError struct {
+	Index  int
+	Reason string
+}
+
+func (e *TocError) Error() string {
+	return fmt.Sprintf("img toc entry %d: %s", e.Index, e.Reason)
+}
+
+// DataRangeError reports an entry whose data lies outside the archive.
+type DataRangeError struct {
+	Index int
+	Name  string
+	Start int
+	End   int
+	Size  int
+}
+
+func (e *DataRangeError) Error() string {
+	return fmt.Sprintf("img entry %d (%s): data range 0x%X-0x%X outside archive of 0x%X bytes", e.Index, e.Name, e.Start, e.End, e.Size)
+}
@@ -2,10 +2,15 @@ package img
 
 import (
 	"encoding/binary"
+	"fmt"
 )
 
 const (
 	HEADER_MAGIC_BYTES = 0xA94E2A52
+
+	// HEADER_UNKNOWN1 is the Unknown1 value of the stock cdimages archives.
+	// What it means is not known, so new archives copy it.
+	HEADER_UNKNOWN1 = 0xE9
 )
 
 type ImgHeader struct {
@@ -18,15 +23,17 @@ type ImgHeader struct {
 	rawData      []byte
 }
 
-func ParseImgHeader(data []byte) *ImgHeader {
+func ParseImgHeader(data []byte) (*ImgHeader, error) {
 	h := &ImgHeader{}
-	h.read(data)
-	return h
+	if err := h.read(data); err != nil {
+		return nil, err
+	}
+	return h, nil
 }
 
-func (h *ImgHeader) read(d []byte) {
-	if len(d) < 20 {
-		panic("Not enough data to read header")
+func (h *ImgHeader) read(d []byte) error {
+	if len(d) < HEADER_SIZE {
+		return &HeaderError{Field: "length", Value: int64(len(d)), Reason: "not enough data to read header"}
 	}
 	h.Identifier = binary.LittleEndian.Uint32(d[0:4])
 	h.Version = int32(binary.LittleEndian.Uint32(d[4:8]))
@@ -34,6 +41,17 @@ func (h *ImgHeader) read(d []byte) {
 	h.TocSize = int32(binary.LittleEndian.Uint32(d[12:16]))
 	h.TocEntrySize = int16(binary.LittleEndian.Uint16(d[16:18]))
 	h.Unknown1 = int16(binary.LittleEndian.Uint16(d[18:20]))
+
+	if h.EntryCount < 0 {
+		return &HeaderError{Field: "EntryCount", Value: int64(h.EntryCount), Reason: "negative"}
+	}
+	if h.TocSize < 0 {
+		return &HeaderError{Field: "TocSize", Value: int64(h.TocSize), Reason: "negative"}
+	}
+	if h.TocEntrySize < TOC_ENTRY_MIN_SIZE {
+		return &HeaderError{Field: "TocEntrySize", Value: int64(h.TocEntrySize), Reason: fmt.Sprintf("smaller than %d", TOC_ENTRY_MIN_SIZE)}
+	}
+	return nil
 }
 
 func (h *ImgHeader) write() []byte {
@@ -3,9 +3,12 @@ package img
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
 	"sort"
 	"strings"
 
+	"github.com/mrchip53/gta-tools/rage"
 	"github.com/mrchip53/gta-tools/rage/util"
 )
 
@@ -18,19 +21,65 @@ type ImgFile struct {
 	header    *ImgHeader
 	entries   []*ImgEntry
 	encrypted bool
+	src       *source
+
+	// size is the length of the file the archive was loaded from, or 0 for
+	// an archive built in memory.
+	size int64
+	// tocEnd is where the header and TOC last read from or written to that
+	// file end.
+	tocEnd int
 }
 
+// NewImgFile returns an empty version 3 archive.
+func NewImgFile() *ImgFile {
+	return &ImgFile{
+		header: &ImgHeader{
+			Identifier:   HEADER_MAGIC_BYTES,
+			Version:      3,
+			TocEntrySize: TOC_ENTRY_MIN_SIZE,
+			Unknown1:     HEADER_UNKNOWN1,
+		},
+		encrypted: true,
+	}
+}
+
+func (f ImgFile) Header() ImgHeader { return *f.header }
+
+func (f ImgFile) Encrypted() bool { return f.encrypted }
+
+// SetEncrypted chooses whether Bytes encrypts the header and TOC. Archives
+// keep the encryption they were loaded with until this is called.
+func (f *ImgFile) SetEncrypted(encrypted bool) { f.encrypted = encrypted }
+
 func (f ImgFile) Entries() []*ImgEntry { return f.entries }
 
+func (f ImgFile) Files() []rage.Entry {
+	files := make([]rage.Entry, len(f.entries))
+	for i, e := range f.entries {
+		files[i] = e
+	}
+	return files
+}
+
+// AddEntry adds a file to the archive. Data starting with an RSC header is
+// added as a resource entry of the type and flags the header names.
 func (f *ImgFile) AddEntry(name string, data []byte) {
 	e := &ImgEntry{
 		name: name,
-		data: data,
 		toc: TocEntry{
 			Flags:     0,
 			entrySize: int(f.header.TocEntrySize),
 		},
 	}
+	if _, _, ok := resourceInfo(data); ok {
+		e.toc.IsResourceFile = true
+	}
+	e.SetData(data)
+	f.addEntry(e)
+}
+
+func (f *ImgFile) addEntry(e *ImgEntry) {
 	f.entries = append(f.entries, e)
 	sort.Slice(f.entries, func(i, j int) bool {
 		return f.entries[i].Name() < f.entries[j].Name()
@@ -38,7 +87,7 @@ func (f *ImgFile) AddEntry(name string, data []byte) {
 	for i, e := range f.entries {
 		e.idx = i
 	}
-	f.rebuild()
+	f.entriesChanged()
 }
 
 func (f *ImgFile) RemoveEntry(idx int) {
@@ -46,184 +95,315 @@ func (f *ImgFile) RemoveEntry(idx int) {
 	for i, e := range f.entries {
 		e.idx = i
 	}
-	f.rebuild()
+	f.entriesChanged()
 }
 
-func (f *ImgFile) rebuild() {
-	entryCount := len(f.entries)
-	f.header.EntryCount = int32(entryCount)
-
-	tocSize := int(f.header.TocEntrySize) * entryCount
-
-	entryNames := make([]string, entryCount)
-	for i, e := range f.entries {
-		entryNames[i] = e.Name()
+// entriesChanged updates the TOC after entries were added or removed. An
+// archive backed by a file keeps its layout so it can still be patched.
+func (f *ImgFile) entriesChanged() {
+	if f.size != 0 {
+		f.updateToc()
+		return
 	}
-	entryNamesStr := strings.Join(entryNames, "\x00") + "\x00"
-	entryNamesBytes := []byte(entryNamesStr)
-	tocSize += len(entryNamesBytes)
+	f.rebuild()
+}
 
-	f.header.TocSize = int32(tocSize)
+// rebuild lays every entry out back to back after the TOC. Entries too
+// large for a TOC record are left as they were and reported by WriteTo.
+func (f *ImgFile) rebuild() error {
+	f.updateToc()
 	curBlock := (f.header.TocSize + HEADER_SIZE) / BLOCK_SIZE
 	if (f.header.TocSize+HEADER_SIZE)%BLOCK_SIZE != 0 {
 		curBlock++
 	}
+	var firstErr error
 	for _, e := range f.entries {
 		e.toc.OffsetBlock = int(curBlock)
-		e.toc.Size = len(e.data)
-		dataBlocks := len(e.data) / BLOCK_SIZE
-		if len(e.data)%BLOCK_SIZE != 0 {
-			dataBlocks++
+		if err := e.toc.setSize(e.Size()); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", e.name, err)
 		}
-		e.toc.UsedBlocks = dataBlocks
-		curBlock += int32(dataBlocks)
+		curBlock += int32(e.toc.UsedBlocks)
 	}
+	return firstErr
 }
 
-func (f ImgFile) Bytes() []byte {
-	f.rebuild()
+// updateToc sets the header's entry count and TOC size.
+func (f *ImgFile) updateToc() {
+	f.header.EntryCount = int32(len(f.entries))
+	f.header.TocSize = f.tocSize()
+}
 
-	var header []byte
-	var entryNames string
-	var tocEntries []byte
-	var data []byte
+// tocSize is the length of the TOC records and name table for the current
+// entries.
+func (f *ImgFile) tocSize() int32 {
+	entryCount := len(f.entries)
+	tocSize := int(f.header.TocEntrySize) * entryCount
 
-	header = f.header.write()
-	err := util.Encrypt(header)
-	if err != nil {
-		panic(err)
+	entryNames := make([]string, entryCount)
+	for i, e := range f.entries {
+		entryNames[i] = e.Name()
+	}
+	entryNamesStr := strings.Join(entryNames, "\x00") + "\x00"
+	entryNamesBytes := []byte(entryNamesStr)
+	tocSize += len(entryNamesBytes)
+
+	return int32(tocSize)
+}
+
+func (f ImgFile) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		return nil, err
 	}
+	return buf.Bytes(), nil
+}
 
-	var names []string
-	for _, e := range f.entries {
-		tmp := make([]byte, e.toc.UsedBlocks*BLOCK_SIZE)
-		copy(tmp, e.Data())
-		names = append(names, e.Name())
-		tocEntries = append(tocEntries, e.toc.Bytes()...)
-		data = append(data, tmp...)
+// metadata returns the header and TOC padded to the first entry's block.
+func (f *ImgFile) metadata() ([]byte, error) {
+	tocs := make([]TocEntry, len(f.entries))
+	for i, e := range f.entries {
+		tocs[i] = e.toc
 	}
-	entryNames = strings.Join(names, "\x00") + "\x00"
-	tocEntries = append(tocEntries, []byte(entryNames)...)
-	err = util.Encrypt(tocEntries)
+	headerAndToc, err := f.headerAndToc(f.header, tocs)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	var metadata []byte
 	if len(f.entries) > 0 {
 		metadata = make([]byte, BLOCK_SIZE*f.entries[0].toc.OffsetBlock)
 	} else {
-		// Handle case with no entries, e.g., create minimal metadata or panic
-		// For now, let's assume a minimal size if no entries, or decide on a specific behavior.
-		// This might need further refinement based on expected IMG structure for empty files.
-		metadata = make([]byte, BLOCK_SIZE) // Default to one block size if no entries
+		metadata = make([]byte, BLOCK_SIZE)
 	}
 
-	if len(metadata) < len(header)+len(tocEntries) {
-		// Ensure metadata is large enough, otherwise, this could panic
-		// This scenario suggests a mismatch in calculated sizes or an issue with OffsetBlock
-		// For now, we'll allocate a larger buffer if needed, but this should be reviewed for correctness
-		tempMetadata := make([]byte, len(header)+len(tocEntries))
-		copy(tempMetadata, metadata)
-		metadata = tempMetadata
+	if len(metadata) < len(headerAndToc) {
+		return nil, &HeaderError{Field: "TocSize", Value: int64(f.header.TocSize), Reason: "toc does not fit before the first entry"}
 	}
+	copy(metadata, headerAndToc)
+	return metadata, nil
+}
 
-	copy(metadata, header)
-	copy(metadata[len(header):], tocEntries)
+// headerAndToc returns header followed by the TOC records for the entries
+// and the name table, encrypted if the archive is. tocs holds one record
+// per entry.
+func (f *ImgFile) headerAndToc(h *ImgHeader, tocs []TocEntry) ([]byte, error) {
+	header := h.write()
+	if f.encrypted {
+		if err := util.Encrypt(header); err != nil {
+			return nil, fmt.Errorf("encrypt img header: %w", err)
+		}
+	}
 
-	final := append(metadata, data...)
-	return final
+	var tocEntries []byte
+	var names []string
+	for i, e := range f.entries {
+		names = append(names, e.Name())
+		tocEntries = append(tocEntries, tocs[i].Bytes()...)
+	}
+	tocEntries = append(tocEntries, []byte(strings.Join(names, "\x00")+"\x00")...)
+	if f.encrypted {
+		if err := util.Encrypt(tocEntries); err != nil {
+			return nil, fmt.Errorf("encrypt img toc: %w", err)
+		}
+	}
+
+	return append(header, tocEntries...), nil
 }
 
-func LoadImgFile(data []byte) ImgFile {
-	var err error
-	encrypted := false
-	magicBytes := binary.BigEndian.Uint32(data[0:4])
-	if magicBytes != HEADER_MAGIC_BYTES {
-		encrypted = true
+// WriteTo streams the archive to w: header and TOC first, then every entry
+// padded to whole blocks. Only one entry is held in memory at a time.
+func (f *ImgFile) WriteTo(w io.Writer) (int64, error) {
+	if err := f.rebuild(); err != nil {
+		return 0, err
 	}
 
-	reader := bytes.NewReader(data)
+	metadata, err := f.metadata()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(metadata)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
 
-	headerBytes := make([]byte, HEADER_SIZE)
-	_, err = reader.Read(headerBytes)
+	padding := make([]byte, BLOCK_SIZE)
+	for _, e := range f.entries {
+		d, err := e.ReadData()
+		if err != nil {
+			return written, err
+		}
+		// The TOC is already written, so the data has to fit the blocks
+		// it gives the entry.
+		if blocks := (len(d) + BLOCK_SIZE - 1) / BLOCK_SIZE; blocks > e.toc.UsedBlocks {
+			return written, fmt.Errorf("write %s: %d bytes need %d blocks, toc has %d", e.name, len(d), blocks, e.toc.UsedBlocks)
+		}
+		n, err = w.Write(d)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		for rest := e.toc.UsedBlocks*BLOCK_SIZE - len(d); rest > 0; rest -= n {
+			n, err = w.Write(padding[:min(rest, len(padding))])
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// LoadImgFile parses an archive held in memory. Entry data is sliced out
+// of data without copying.
+func LoadImgFile(data []byte) (*ImgFile, error) {
+	f, err := OpenImgFile(bytes.NewReader(data), int64(len(data)))
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	for _, e := range f.entries {
+		e.data = data[e.offset : e.offset+int64(e.size)]
+		e.src = nil
+	}
+	f.src = nil
+	f.size = int64(len(data))
+	return f, nil
+}
+
+// OpenImgFile parses the header and TOC of a size byte archive read from r.
+// Entry data is read from r when it is first asked for, so r must stay
+// open for as long as the archive is used or until Detach is called.
+func OpenImgFile(r io.ReaderAt, size int64) (*ImgFile, error) {
+	if size < HEADER_SIZE {
+		return nil, &HeaderError{Field: "length", Value: size, Reason: "not enough data to read header"}
+	}
+
+	headerBytes := make([]byte, HEADER_SIZE)
+	if _, err := r.ReadAt(headerBytes, 0); err != nil {
+		return nil, fmt.Errorf("read img header: %w", err)
 	}
 	rawHeader := make([]byte, HEADER_SIZE)
 	copy(rawHeader, headerBytes)
 
+	encrypted := binary.LittleEndian.Uint32(headerBytes[0:4]) != HEADER_MAGIC_BYTES
 	if encrypted {
-		err = util.Decrypt(headerBytes)
-		if err != nil {
-			panic(err)
+		if err := util.Decrypt(headerBytes); err != nil {
+			return nil, fmt.Errorf("decrypt img header: %w", err)
+		}
+		if binary.LittleEndian.Uint32(headerBytes[0:4]) != HEADER_MAGIC_BYTES {
+			return nil, &HeaderError{Field: "Identifier", Value: int64(binary.LittleEndian.Uint32(headerBytes[0:4])), Reason: "bad magic"}
 		}
 	}
 
-	header := ParseImgHeader(headerBytes)
+	header, err := ParseImgHeader(headerBytes)
+	if err != nil {
+		return nil, err
+	}
 	header.rawData = rawHeader
 
-	if header.TocSize < 0 {
-		panic("Invalid TocSize in header")
+	if int64(header.TocSize) > size-HEADER_SIZE {
+		return nil, &HeaderError{Field: "TocSize", Value: int64(header.TocSize), Reason: "extends past end of file"}
 	}
 	tocBytes := make([]byte, header.TocSize)
-	_, err = reader.Read(tocBytes)
-	if err != nil {
-		panic(err)
+	if _, err := r.ReadAt(tocBytes, HEADER_SIZE); err != nil {
+		return nil, fmt.Errorf("read img toc: %w", err)
 	}
 
 	if encrypted {
-		err = util.Decrypt(tocBytes)
-		if err != nil {
-			panic(err)
+		if err := util.Decrypt(tocBytes); err != nil {
+			return nil, fmt.Errorf("decrypt img toc: %w", err)
 		}
 	}
 
-	// Ensure EntryCount and TocEntrySize are not negative and won't cause overflow or out-of-bounds access
-	if header.EntryCount < 0 || header.TocEntrySize < 0 {
-		panic("Invalid EntryCount or TocEntrySize in header")
-	}
 	entryDataSize := int(header.EntryCount) * int(header.TocEntrySize)
-	if entryDataSize < 0 || entryDataSize > len(tocBytes) { // Check for overflow and bounds
-		panic("Calculated entry data size is invalid or out of bounds")
+	if entryDataSize > len(tocBytes) {
+		return nil, &HeaderError{Field: "EntryCount", Value: int64(header.EntryCount), Reason: fmt.Sprintf("%d records of %d bytes do not fit in TocSize %d", header.EntryCount, header.TocEntrySize, header.TocSize)}
 	}
 
 	stringData := tocBytes[entryDataSize:]
 	entryNames := strings.Split(string(stringData), "\x00")
+	if len(entryNames) < int(header.EntryCount) {
+		return nil, &HeaderError{Field: "EntryCount", Value: int64(header.EntryCount), Reason: fmt.Sprintf("name table holds only %d names", len(entryNames))}
+	}
 
+	src := &source{r: r}
 	var entries []*ImgEntry
 
 	for i := range int(header.EntryCount) {
 		tocEntryStartIndex := i * int(header.TocEntrySize)
 		tocEntryEndIndex := (i + 1) * int(header.TocEntrySize)
-		if tocEntryEndIndex > len(tocBytes) || tocEntryStartIndex < 0 { // Bounds check
-			panic("TocEntry index out of bounds")
-		}
 		eb := tocBytes[tocEntryStartIndex:tocEntryEndIndex]
-		e := NewTocEntry(eb)
+		e, err := NewTocEntry(eb)
+		if err != nil {
+			return nil, &TocError{Index: i, Reason: err.Error()}
+		}
 
-		if e.OffsetBlock < 0 || e.Size < 0 { // Sanity check for offset and size
-			panic("Invalid entry offset or size")
+		if e.OffsetBlock < 0 || e.Size < 0 {
+			return nil, &TocError{Index: i, Reason: fmt.Sprintf("invalid offset block %d or size %d", e.OffsetBlock, e.Size)}
 		}
 		dataStartIndex := e.OffsetBlock * BLOCK_SIZE
 		dataEndIndex := dataStartIndex + e.Size
 
-		if dataStartIndex < 0 || dataEndIndex > len(data) || dataStartIndex > dataEndIndex { // More robust bounds check for data slice
-			panic("Entry data indices out of bounds or invalid")
+		if dataStartIndex < 0 || int64(dataEndIndex) > size || dataStartIndex > dataEndIndex {
+			return nil, &DataRangeError{Index: i, Name: entryNames[i], Start: dataStartIndex, End: dataEndIndex, Size: int(size)}
 		}
 
-		d := data[dataStartIndex:dataEndIndex]
 		entries = append(entries, &ImgEntry{
-			idx:  i,
-			name: entryNames[i],
-			data: d,
-			toc:  e,
+			idx:    i,
+			name:   entryNames[i],
+			toc:    e,
+			src:    src,
+			offset: int64(dataStartIndex),
+			size:   e.Size,
+			disk:   &diskSpan{Block: e.OffsetBlock, Blocks: e.UsedBlocks, Used: e.UsedBlocks},
 		})
 	}
 
-	return ImgFile{
+	return &ImgFile{
 		header:    header,
 		entries:   entries,
 		encrypted: encrypted,
+		src:       src,
+		size:      size,
+		tocEnd:    HEADER_SIZE + int(header.TocSize),
+	}, nil
+}
+
+// SetCache chooses whether entries read from an opened archive keep their
+// data in memory after the first read. Turning it off drops cached data.
+func (f *ImgFile) SetCache(enabled bool) {
+	if f.src == nil {
+		return
+	}
+	f.src.cache = enabled
+	if enabled {
+		return
+	}
+	for _, e := range f.entries {
+		if e.cached {
+			e.data = nil
+			e.cached = false
+		}
+	}
+}
+
+// Detach reads every entry into memory so the archive no longer needs the
+// reader it was opened from, for example before overwriting that file. A
+// detached archive can no longer be patched in place.
+func (f *ImgFile) Detach() error {
+	for _, e := range f.entries {
+		if e.src != nil {
+			d, err := e.ReadData()
+			if err != nil {
+				return err
+			}
+			e.data = d
+			e.src = nil
+			e.cached = false
+		}
+		e.disk = nil
 	}
+	f.src = nil
+	f.size = 0
+	return nil
 }
@@ -1,6 +1,9 @@
 package img
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"os"
 	"testing"
 
@@ -29,11 +32,14 @@ func TestCompareIdenticalImgFilesLoadedFromBytes(t *testing.T) {
 	}
 
 	// 3. Load two ImgFile instances from the same byte slice
-	// Note: The LoadImgFile function in the prompt seems to have a potential issue where it uses a global 'data' variable
-	// for slicing entry data instead of the 'data' parameter passed to it. This might need correction in img_file.go.
-	// Assuming LoadImgFile works correctly with its input 'data' parameter for this test.
-	img1 := LoadImgFile(oivBytes)
-	img2 := LoadImgFile(scriptBytes)
+	img1, err := LoadImgFile(oivBytes)
+	if err != nil {
+		t.Fatalf("Failed to load oivf file: %v", err)
+	}
+	img2, err := LoadImgFile(scriptBytes)
+	if err != nil {
+		t.Fatalf("Failed to load scriptf file: %v", err)
+	}
 
 	// 4. Assert that they have the same number of entries
 	if len(img1.Entries()) != len(img2.Entries()) {
@@ -51,8 +57,10 @@ func TestCompareIdenticalImgFilesLoadedFromBytes(t *testing.T) {
 		if e1.Name() != e2.Name() {
 			t.Errorf("Entry %d: Names do not match. Got '%s' and '%s'", i, e1.Name(), e2.Name())
 		}
-		if e1.Hash() != e2.Hash() {
-			t.Errorf("Entry %d ('%s'): Hashes do not match. Got %s and %s", i, e1.Name(), e1.Hash(), e2.Hash())
+		h1, err1 := e1.Hash()
+		h2, err2 := e2.Hash()
+		if err1 != nil || err2 != nil || h1 != h2 {
+			t.Errorf("Entry %d ('%s'): Hashes do not match. Got %s and %s (%v, %v)", i, e1.Name(), h1, h2, err1, err2)
 		}
 		if e1.Toc().Flags != e2.Toc().Flags {
 			t.Errorf("Entry %d ('%s'): Toc flags do not match. Got %d and %d", i, e1.Name(), e1.Toc().Flags, e2.Toc().Flags)
@@ -71,3 +79,174 @@ func TestCompareIdenticalImgFilesLoadedFromBytes(t *testing.T) {
 		}
 	}
 }
+
+func readData(t *testing.T, e *ImgEntry) []byte {
+	t.Helper()
+	d, err := e.ReadData()
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", e.Name(), err)
+	}
+	return d
+}
+
+func buildPlainImg(t *testing.T, names []string, tocs []TocEntry, dataBlocks int) []byte {
+	t.Helper()
+	var toc []byte
+	for _, e := range tocs {
+		e.entrySize = 16
+		toc = append(toc, e.Bytes()...)
+	}
+	for _, n := range names {
+		toc = append(toc, []byte(n+"\x00")...)
+	}
+	h := ImgHeader{
+		Identifier:   HEADER_MAGIC_BYTES,
+		Version:      3,
+		EntryCount:   int32(len(tocs)),
+		TocSize:      int32(len(toc)),
+		TocEntrySize: 16,
+	}
+	b := make([]byte, BLOCK_SIZE*(1+dataBlocks))
+	copy(b, h.write())
+	copy(b[HEADER_SIZE:], toc)
+	return b
+}
+
+func TestLoadImgFileReportsErrors(t *testing.T) {
+	var headerErr *HeaderError
+	if _, err := LoadImgFile(make([]byte, 8)); !errors.As(err, &headerErr) {
+		t.Fatalf("Expected HeaderError for truncated file, got %v", err)
+	}
+
+	b := buildPlainImg(t, []string{"a.sco"}, []TocEntry{{Size: 4 * BLOCK_SIZE, OffsetBlock: 1, UsedBlocks: 4}}, 1)
+	var rangeErr *DataRangeError
+	if _, err := LoadImgFile(b); !errors.As(err, &rangeErr) {
+		t.Fatalf("Expected DataRangeError, got %v", err)
+	}
+	if rangeErr.Index != 0 || rangeErr.Name != "a.sco" {
+		t.Errorf("Unexpected DataRangeError %+v", rangeErr)
+	}
+
+	b = buildPlainImg(t, []string{"a.sco"}, []TocEntry{{Size: 10, OffsetBlock: 1, UsedBlocks: 1}}, 1)
+	f, err := LoadImgFile(b)
+	if err != nil {
+		t.Fatalf("Failed to load valid archive: %v", err)
+	}
+	if len(f.Entries()) != 1 || f.Entries()[0].Name() != "a.sco" || len(readData(t, f.Entries()[0])) != 10 {
+		t.Errorf("Unexpected entries %+v", f.Entries())
+	}
+}
+
+func TestBytesWritesUnencryptedArchive(t *testing.T) {
+	f := NewImgFile()
+	f.SetEncrypted(false)
+	f.AddEntry("b.dat", []byte("bbbb"))
+	f.AddEntry("a.dat", bytes.Repeat([]byte{1}, BLOCK_SIZE+1))
+
+	b, err := f.Bytes()
+	if err != nil {
+		t.Fatalf("Failed to write unencrypted archive: %v", err)
+	}
+	loaded, err := LoadImgFile(b)
+	if err != nil {
+		t.Fatalf("Failed to load unencrypted archive: %v", err)
+	}
+	if loaded.Encrypted() {
+		t.Errorf("Expected archive to load as unencrypted")
+	}
+	for i, e := range f.Entries() {
+		l := loaded.Entries()[i]
+		if e.Name() != l.Name() || !bytes.Equal(readData(t, e), readData(t, l)) {
+			t.Errorf("Entry %d: expected %s, got %s", i, e.Name(), l.Name())
+		}
+	}
+}
+
+type countingReader struct {
+	r     *bytes.Reader
+	reads int
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	c.reads++
+	return c.r.ReadAt(p, off)
+}
+
+func TestOpenImgFileReadsEntriesOnDemand(t *testing.T) {
+	b := buildPlainImg(t, []string{"a.dat", "b.dat"}, []TocEntry{
+		{Size: 10, OffsetBlock: 1, UsedBlocks: 1},
+		{Size: 20, OffsetBlock: 2, UsedBlocks: 1},
+	}, 2)
+	copy(b[BLOCK_SIZE:], "aaaaaaaaaa")
+	copy(b[2*BLOCK_SIZE:], "bbbbbbbbbbbbbbbbbbbb")
+
+	r := &countingReader{r: bytes.NewReader(b)}
+	f, err := OpenImgFile(r, int64(len(b)))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	opened := r.reads
+	if f.Entries()[1].Size() != 20 || r.reads != opened {
+		t.Errorf("Expected size without reading entry data")
+	}
+
+	e := f.Entries()[0]
+	if string(readData(t, e)) != "aaaaaaaaaa" || string(readData(t, e)) != "aaaaaaaaaa" || r.reads != opened+2 {
+		t.Errorf("Expected two uncached reads, got %d", r.reads-opened)
+	}
+	f.SetCache(true)
+	readData(t, e)
+	readData(t, e)
+	if r.reads != opened+3 {
+		t.Errorf("Expected one cached read, got %d", r.reads-opened-2)
+	}
+
+	if err := f.Detach(); err != nil {
+		t.Fatalf("Failed to detach: %v", err)
+	}
+	reads := r.reads
+	if string(readData(t, f.Entries()[1])) != "bbbbbbbbbbbbbbbbbbbb" || r.reads != reads {
+		t.Errorf("Expected detached archive to serve data from memory")
+	}
+}
+
+func TestWriteToStreamsArchive(t *testing.T) {
+	f := NewImgFile()
+	f.SetEncrypted(false)
+	f.AddEntry("a.dat", bytes.Repeat([]byte{1}, BLOCK_SIZE+1))
+	f.AddEntry("b.dat", []byte("bbbb"))
+
+	var buf bytes.Buffer
+	n, err := f.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Failed to stream archive: %v", err)
+	}
+	if n != int64(buf.Len()) || n != 4*BLOCK_SIZE {
+		t.Errorf("Expected %d bytes written, got %d (buffer %d)", 4*BLOCK_SIZE, n, buf.Len())
+	}
+	b, err := f.Bytes()
+	if err != nil || !bytes.Equal(b, buf.Bytes()) {
+		t.Errorf("Expected Bytes to match WriteTo output (%v)", err)
+	}
+}
+
+func TestWriteToRejectsEntryTooLargeForToc(t *testing.T) {
+	f := NewImgFile()
+	f.SetEncrypted(false)
+	// An opened entry reports its size without reading, so no data is needed.
+	f.addEntry(&ImgEntry{
+		name: "big.dat",
+		toc:  TocEntry{entrySize: TOC_ENTRY_MIN_SIZE},
+		src:  &source{r: bytes.NewReader(nil)},
+		size: (TOC_MAX_USED_BLOCKS + 1) * BLOCK_SIZE,
+	})
+
+	if _, err := f.WriteTo(io.Discard); !errors.Is(err, ErrEntryTooLarge) {
+		t.Errorf("Expected ErrEntryTooLarge, got %v", err)
+	}
+
+	var toc TocEntry
+	if err := toc.setSize(TOC_MAX_USED_BLOCKS * BLOCK_SIZE); err != nil || toc.UsedBlocks != TOC_MAX_USED_BLOCKS {
+		t.Errorf("Expected %d blocks to fit, got %d (%v)", TOC_MAX_USED_BLOCKS, toc.UsedBlocks, err)
+	}
+}
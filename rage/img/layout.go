@@ -0,0 +1,108 @@
+package img
+
+import (
+	"sort"
+)
+
+// BlockRange is a run of blocks [Start, Start+Count).
+type BlockRange struct {
+	Start int `json:"start"`
+	Count int `json:"count"`
+}
+
+// Overlap names two entries whose blocks intersect.
+type Overlap struct {
+	First  string `json:"first"`
+	Second string `json:"second"`
+}
+
+// LayoutReport describes how an archive's file is used, block by block.
+type LayoutReport struct {
+	TotalBlocks int `json:"totalBlocks"`
+	TocBlocks   int `json:"tocBlocks"`
+	UsedBlocks  int `json:"usedBlocks"`
+	FreeBlocks  int `json:"freeBlocks"`
+
+	// Gaps are the runs of blocks that belong to neither the TOC nor an
+	// entry, including the unused tail of entries that shrank in place.
+	Gaps        []BlockRange `json:"gaps"`
+	Unsorted    []string     `json:"unsorted"`
+	Overlaps    []Overlap    `json:"overlaps"`
+	OutOfBounds []string     `json:"outOfBounds"`
+	// Unwritten are entries that have no blocks in the file yet.
+	Unwritten []string `json:"unwritten"`
+}
+
+// Fragmented reports whether Defrag would shrink the file.
+func (r LayoutReport) Fragmented() bool {
+	return r.FreeBlocks > 0 || len(r.Overlaps) > 0
+}
+
+// AnalyzeLayout reports on the file the archive was loaded from. Entries
+// added or moved since the last write are listed as unwritten.
+func (f *ImgFile) AnalyzeLayout() LayoutReport {
+	r := LayoutReport{
+		TotalBlocks: int((f.size + BLOCK_SIZE - 1) / BLOCK_SIZE),
+		TocBlocks:   (f.tocEnd + BLOCK_SIZE - 1) / BLOCK_SIZE,
+	}
+
+	type span struct {
+		name       string
+		start, end int
+	}
+	var spans []span
+	for i, e := range f.entries {
+		if i > 0 && e.name < f.entries[i-1].name {
+			r.Unsorted = append(r.Unsorted, e.name)
+		}
+		if e.disk == nil {
+			r.Unwritten = append(r.Unwritten, e.name)
+			continue
+		}
+		s := span{e.name, e.disk.Block, e.disk.Block + e.disk.Used}
+		if s.start < r.TocBlocks || s.end > r.TotalBlocks {
+			r.OutOfBounds = append(r.OutOfBounds, e.name)
+		}
+		r.UsedBlocks += e.disk.Used
+		spans = append(spans, s)
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	cursor := r.TocBlocks
+	var last span
+	for i, s := range spans {
+		if i > 0 && s.start < last.end {
+			r.Overlaps = append(r.Overlaps, Overlap{First: last.name, Second: s.name})
+		}
+		if s.start > cursor {
+			r.Gaps = append(r.Gaps, BlockRange{Start: cursor, Count: s.start - cursor})
+			r.FreeBlocks += s.start - cursor
+		}
+		if s.end > cursor {
+			cursor = s.end
+		}
+		if i == 0 || s.end > last.end {
+			last = s
+		}
+	}
+	if r.TotalBlocks > cursor {
+		r.Gaps = append(r.Gaps, BlockRange{Start: cursor, Count: r.TotalBlocks - cursor})
+		r.FreeBlocks += r.TotalBlocks - cursor
+	}
+	return r
+}
+
+// Defrag sorts the entries by name and lays them out back to back after
+// the TOC. The archive forgets its file layout, so it can no longer be
+// patched and the next save writes the whole file.
+func (f *ImgFile) Defrag() {
+	sort.SliceStable(f.entries, func(i, j int) bool {
+		return f.entries[i].Name() < f.entries[j].Name()
+	})
+	for i, e := range f.entries {
+		e.idx = i
+		e.disk = nil
+	}
+	f.size = 0
+	f.rebuild()
+}
@@ -0,0 +1,53 @@
+package img
+
+import (
+	"testing"
+)
+
+func TestAnalyzeLayoutFindsGapsAndProblems(t *testing.T) {
+	b := buildPlainImg(t, []string{"b.dat", "a.dat", "c.dat"}, []TocEntry{
+		{Size: 10, OffsetBlock: 2, UsedBlocks: 1},
+		{Size: 3 * BLOCK_SIZE, OffsetBlock: 4, UsedBlocks: 3},
+		{Size: 10, OffsetBlock: 5, UsedBlocks: 1},
+	}, 7)
+	f, err := LoadImgFile(b)
+	if err != nil {
+		t.Fatalf("Failed to load archive: %v", err)
+	}
+
+	r := f.AnalyzeLayout()
+	if r.TotalBlocks != 8 || r.TocBlocks != 1 || r.UsedBlocks != 5 {
+		t.Errorf("Unexpected block counts %+v", r)
+	}
+	// Free: block 1, block 3 and block 7.
+	if r.FreeBlocks != 3 || len(r.Gaps) != 3 || r.Gaps[1] != (BlockRange{Start: 3, Count: 1}) {
+		t.Errorf("Unexpected gaps %+v", r.Gaps)
+	}
+	if len(r.Unsorted) != 1 || r.Unsorted[0] != "a.dat" {
+		t.Errorf("Expected a.dat out of order, got %v", r.Unsorted)
+	}
+	if len(r.Overlaps) != 1 || r.Overlaps[0] != (Overlap{First: "a.dat", Second: "c.dat"}) {
+		t.Errorf("Expected a.dat and c.dat to overlap, got %v", r.Overlaps)
+	}
+	if !r.Fragmented() {
+		t.Errorf("Expected archive to be fragmented")
+	}
+
+	f.Defrag()
+	f.SetEncrypted(false)
+	out, err := f.Bytes()
+	if err != nil {
+		t.Fatalf("Failed to write defragmented archive: %v", err)
+	}
+	loaded, err := LoadImgFile(out)
+	if err != nil {
+		t.Fatalf("Failed to load defragmented archive: %v", err)
+	}
+	r = loaded.AnalyzeLayout()
+	if r.Fragmented() || len(r.Unsorted) != 0 || r.TotalBlocks != 6 {
+		t.Errorf("Expected compact sorted archive, got %+v", r)
+	}
+	if loaded.Entries()[0].Name() != "a.dat" || loaded.Entries()[0].Toc().Size != 3*BLOCK_SIZE {
+		t.Errorf("Unexpected first entry %+v", loaded.Entries()[0].Toc())
+	}
+}
@@ -0,0 +1,133 @@
+package img
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MANIFEST_NAME is the file ExtractToDir writes next to the entries.
+const MANIFEST_NAME = "img_manifest.json"
+
+// Manifest records everything about an archive that is not the entry data
+// itself, so LoadFromDir can rebuild it entry for entry.
+type Manifest struct {
+	Version      int32           `json:"version"`
+	TocEntrySize int16           `json:"tocEntrySize"`
+	Unknown1     int16           `json:"unknown1"`
+	Encrypted    bool            `json:"encrypted"`
+	Entries      []ManifestEntry `json:"entries"`
+}
+
+type ManifestEntry struct {
+	Name           string `json:"name"`
+	IsResourceFile bool   `json:"isResourceFile,omitempty"`
+	RscFlags       int    `json:"rscFlags,omitempty"`
+	ResourceType   int    `json:"resourceType"`
+	Flags          int    `json:"flags"`
+}
+
+// Manifest describes the archive header and the TOC record of every entry
+// in archive order.
+func (f ImgFile) Manifest() Manifest {
+	m := Manifest{
+		Version:      f.header.Version,
+		TocEntrySize: f.header.TocEntrySize,
+		Unknown1:     f.header.Unknown1,
+		Encrypted:    f.encrypted,
+	}
+	for _, e := range f.entries {
+		m.Entries = append(m.Entries, ManifestEntry{
+			Name:           e.name,
+			IsResourceFile: e.toc.IsResourceFile,
+			RscFlags:       e.toc.RscFlags,
+			ResourceType:   e.toc.RsourceType,
+			Flags:          e.toc.Flags,
+		})
+	}
+	return m
+}
+
+func checkEntryName(name string) error {
+	if name == "" || name == MANIFEST_NAME || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid entry name %q", name)
+	}
+	return nil
+}
+
+// ExtractToDir writes every entry to dir under its own name together with
+// a manifest. The directory is created if needed.
+func (f ImgFile) ExtractToDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, e := range f.entries {
+		if err := checkEntryName(e.name); err != nil {
+			return &TocError{Index: e.idx, Reason: err.Error()}
+		}
+		d, err := e.ReadData()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, e.name), d, 0644); err != nil {
+			return err
+		}
+	}
+	b, err := json.MarshalIndent(f.Manifest(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, MANIFEST_NAME), append(b, '\n'), 0644)
+}
+
+// LoadFromDir rebuilds an archive from a directory written by
+// ExtractToDir. Entries keep the manifest order and TOC flags; files in the
+// directory that the manifest does not list are ignored.
+func LoadFromDir(dir string) (*ImgFile, error) {
+	b, err := os.ReadFile(filepath.Join(dir, MANIFEST_NAME))
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", MANIFEST_NAME, err)
+	}
+	if m.TocEntrySize < TOC_ENTRY_MIN_SIZE {
+		return nil, &HeaderError{Field: "TocEntrySize", Value: int64(m.TocEntrySize), Reason: fmt.Sprintf("smaller than %d", TOC_ENTRY_MIN_SIZE)}
+	}
+
+	f := &ImgFile{
+		header: &ImgHeader{
+			Identifier:   HEADER_MAGIC_BYTES,
+			Version:      m.Version,
+			TocEntrySize: m.TocEntrySize,
+			Unknown1:     m.Unknown1,
+		},
+		encrypted: m.Encrypted,
+	}
+	for i, me := range m.Entries {
+		if err := checkEntryName(me.Name); err != nil {
+			return nil, &TocError{Index: i, Reason: err.Error()}
+		}
+		data, err := os.ReadFile(filepath.Join(dir, me.Name))
+		if err != nil {
+			return nil, err
+		}
+		f.entries = append(f.entries, &ImgEntry{
+			idx:  i,
+			name: me.Name,
+			data: data,
+			toc: TocEntry{
+				IsResourceFile: me.IsResourceFile,
+				RscFlags:       me.RscFlags,
+				RsourceType:    me.ResourceType,
+				Flags:          me.Flags,
+				entrySize:      int(m.TocEntrySize),
+			},
+		})
+	}
+	f.rebuild()
+	return f, nil
+}
@@ -0,0 +1,43 @@
+package img
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestExtractToDirAndLoadFromDir(t *testing.T) {
+	b := buildPlainImg(t, []string{"b.sco", "a.wdr"}, []TocEntry{
+		{Size: 10, RsourceType: 0, OffsetBlock: 1, UsedBlocks: 1},
+		{IsResourceFile: true, RscFlags: 0xC0000010, RsourceType: 110, OffsetBlock: 2, UsedBlocks: 1, Flags: 0x700},
+	}, 2)
+	for i := BLOCK_SIZE; i < len(b); i++ {
+		b[i] = byte(i)
+	}
+	f, err := LoadImgFile(b)
+	if err != nil {
+		t.Fatalf("Failed to load archive: %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := f.ExtractToDir(dir); err != nil {
+		t.Fatalf("Failed to extract: %v", err)
+	}
+	loaded, err := LoadFromDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to load from dir: %v", err)
+	}
+
+	if !reflect.DeepEqual(f.Manifest(), loaded.Manifest()) {
+		t.Errorf("Expected manifests to match:\n%+v\n%+v", f.Manifest(), loaded.Manifest())
+	}
+	for i, e := range f.Entries() {
+		l := loaded.Entries()[i]
+		if !bytes.Equal(readData(t, e), readData(t, l)) {
+			t.Errorf("Entry %d: data does not match", i)
+		}
+		if e.Toc().Size != l.Toc().Size || e.Toc().UsedBlocks != l.Toc().UsedBlocks {
+			t.Errorf("Entry %d: expected toc %+v, got %+v", i, e.Toc(), l.Toc())
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package img
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrTocTooLarge is returned by Patch when the TOC has grown into the
+	// blocks of the first entry. The archive has to be written in full.
+	ErrTocTooLarge = errors.New("img: toc does not fit before the first entry")
+
+	// ErrNoSourceFile is returned by Patch for an archive that was built in
+	// memory or detached from the file it was loaded from.
+	ErrNoSourceFile = errors.New("img: archive is not backed by a file")
+)
+
+// Patch updates the file the archive was loaded from in place. Entries
+// whose data was replaced are rewritten in their existing blocks if they
+// still fit and appended to the end of the file otherwise; all other
+// entries keep their offsets. The header and TOC are written last. Blocks
+// of removed or moved entries are left unused. If Patch fails the entries
+// keep the layout of the last successful write.
+func (f *ImgFile) Patch(w io.WriterAt) error {
+	if f.size == 0 {
+		return ErrNoSourceFile
+	}
+	header := *f.header
+	header.EntryCount = int32(len(f.entries))
+	header.TocSize = f.tocSize()
+
+	end := int((f.size + BLOCK_SIZE - 1) / BLOCK_SIZE)
+	for _, e := range f.entries {
+		if e.disk != nil {
+			end = max(end, e.disk.Block+e.disk.Blocks)
+		}
+	}
+
+	tocs := make([]TocEntry, len(f.entries))
+	first := -1
+	for i, e := range f.entries {
+		t := e.toc
+		if err := t.setSize(e.Size()); err != nil {
+			return fmt.Errorf("%s: %w", e.name, err)
+		}
+		if e.disk != nil && t.UsedBlocks <= e.disk.Blocks {
+			t.OffsetBlock = e.disk.Block
+		} else {
+			t.OffsetBlock = end
+			end += t.UsedBlocks
+		}
+		if first == -1 || t.OffsetBlock < first {
+			first = t.OffsetBlock
+		}
+		tocs[i] = t
+	}
+
+	headerAndToc, err := f.headerAndToc(&header, tocs)
+	if err != nil {
+		return err
+	}
+	if first != -1 && len(headerAndToc) > first*BLOCK_SIZE {
+		return ErrTocTooLarge
+	}
+
+	disks := make([]diskSpan, len(f.entries))
+	padding := make([]byte, BLOCK_SIZE)
+	for i, e := range f.entries {
+		t := tocs[i]
+		moved := e.disk == nil || e.disk.Block != t.OffsetBlock
+		if moved {
+			disks[i] = diskSpan{Block: t.OffsetBlock, Blocks: t.UsedBlocks}
+		} else {
+			disks[i] = *e.disk
+		}
+		disks[i].Used = t.UsedBlocks
+		if !e.dirty && !moved {
+			continue
+		}
+		d, err := e.ReadData()
+		if err != nil {
+			return err
+		}
+		off := int64(t.OffsetBlock) * BLOCK_SIZE
+		if _, err := w.WriteAt(d, off); err != nil {
+			return fmt.Errorf("write %s: %w", e.name, err)
+		}
+		// Clear the rest of the entry's blocks, including any it no longer
+		// uses, so stale data does not linger in the archive.
+		blocks := disks[i].Blocks
+		for pos := off + int64(len(d)); pos < off+int64(blocks*BLOCK_SIZE); pos += int64(len(padding)) {
+			n := min(int64(len(padding)), off+int64(blocks*BLOCK_SIZE)-pos)
+			if _, err := w.WriteAt(padding[:n], pos); err != nil {
+				return fmt.Errorf("write %s: %w", e.name, err)
+			}
+		}
+	}
+
+	tocEnd := len(headerAndToc)
+	if tocEnd < f.tocEnd {
+		// Clear what is left of the previous, longer TOC.
+		headerAndToc = append(headerAndToc, make([]byte, f.tocEnd-tocEnd)...)
+	}
+	if _, err := w.WriteAt(headerAndToc, 0); err != nil {
+		return fmt.Errorf("write img toc: %w", err)
+	}
+
+	*f.header = header
+	for i, e := range f.entries {
+		e.toc = tocs[i]
+		e.disk = &disks[i]
+		e.dirty = false
+	}
+	f.size = max(f.size, int64(end)*BLOCK_SIZE)
+	f.tocEnd = tocEnd
+	return nil
+}
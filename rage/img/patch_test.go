@@ -0,0 +1,112 @@
+package img
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPatchRewritesOnlyChangedEntries(t *testing.T) {
+	f := NewImgFile()
+	f.SetEncrypted(false)
+	f.AddEntry("a.sco", bytes.Repeat([]byte{'a'}, BLOCK_SIZE+100))
+	f.AddEntry("b.sco", bytes.Repeat([]byte{'b'}, 100))
+	f.AddEntry("c.sco", bytes.Repeat([]byte{'c'}, 100))
+
+	path := filepath.Join(t.TempDir(), "script.img")
+	b, err := f.Bytes()
+	if err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	defer file.Close()
+	opened, err := OpenImgFile(file, int64(len(b)))
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	offsets := func(f *ImgFile) []int {
+		var o []int
+		for _, e := range f.Entries() {
+			o = append(o, e.Toc().OffsetBlock)
+		}
+		return o
+	}
+	before := offsets(opened)
+
+	opened.Entries()[0].SetData([]byte("short"))
+	opened.Entries()[1].SetData(bytes.Repeat([]byte{'B'}, 3*BLOCK_SIZE))
+	if err := opened.Patch(file); err != nil {
+		t.Fatalf("Failed to patch: %v", err)
+	}
+
+	after := offsets(opened)
+	if after[0] != before[0] || after[2] != before[2] {
+		t.Errorf("Expected unchanged offsets for entries that fit, got %v from %v", after, before)
+	}
+	if st, _ := file.Stat(); after[1] != len(b)/BLOCK_SIZE || st.Size() != int64(len(b)+3*BLOCK_SIZE) {
+		t.Errorf("Expected grown entry appended at block %d, got %v with file size %d", len(b)/BLOCK_SIZE, after, st.Size())
+	}
+
+	patched, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read patched archive: %v", err)
+	}
+	loaded, err := LoadImgFile(patched)
+	if err != nil {
+		t.Fatalf("Failed to load patched archive: %v", err)
+	}
+	want := []string{"short", strings.Repeat("B", 3*BLOCK_SIZE), strings.Repeat("c", 100)}
+	for i, e := range loaded.Entries() {
+		if string(readData(t, e)) != want[i] {
+			t.Errorf("Entry %d: unexpected data after patch", i)
+		}
+	}
+
+	oldTocEnd := HEADER_SIZE + int(loaded.header.TocSize)
+	before = offsets(opened)
+	opened.RemoveEntry(2)
+	if after := offsets(opened); after[0] != before[0] || after[1] != before[1] {
+		t.Errorf("Expected RemoveEntry to keep the file layout, got %v from %v", after, before)
+	}
+	if err := opened.Patch(file); err != nil {
+		t.Fatalf("Failed to patch after remove: %v", err)
+	}
+	patched, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read patched archive: %v", err)
+	}
+	tocEnd := HEADER_SIZE + int(opened.header.TocSize)
+	if tail := patched[tocEnd:oldTocEnd]; !bytes.Equal(tail, make([]byte, len(tail))) {
+		t.Errorf("Expected the old toc tail to be cleared, got %q", tail)
+	}
+
+	for i := 0; i < 100; i++ {
+		opened.AddEntry(strings.Repeat("x", 40)+string(rune('A'+i%26))+strings.Repeat("y", i), nil)
+	}
+	var tocs []TocEntry
+	for _, e := range opened.Entries() {
+		tocs = append(tocs, e.Toc())
+	}
+	layout := opened.AnalyzeLayout()
+	if err := opened.Patch(file); !errors.Is(err, ErrTocTooLarge) {
+		t.Errorf("Expected ErrTocTooLarge, got %v", err)
+	}
+	for i, e := range opened.Entries() {
+		if e.Toc() != tocs[i] {
+			t.Errorf("Entry %s: failed patch changed its toc record to %+v", e.Name(), e.Toc())
+		}
+	}
+	if l := opened.AnalyzeLayout(); len(l.Unwritten) != len(layout.Unwritten) || l.UsedBlocks != layout.UsedBlocks {
+		t.Errorf("Expected failed patch to leave the file layout unchanged")
+	}
+}
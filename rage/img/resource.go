@@ -0,0 +1,57 @@
+package img
+
+import (
+	"fmt"
+
+	"github.com/mrchip53/gta-tools/rage/rsc"
+)
+
+const (
+	// TOC_PADDING_MASK are the bits of TocEntry.Flags holding how many bytes
+	// of the last block a resource entry leaves unused.
+	TOC_PADDING_MASK = 0x7FF
+)
+
+// resourceInfo reads the resource type and flags from the RSC header at
+// the start of data.
+func resourceInfo(data []byte) (resourceType, rscFlags int, ok bool) {
+	h, err := rsc.ParseHeader(data)
+	if err != nil || h.Flags&rsc.FLAGS_RESOURCE == 0 {
+		return 0, 0, false
+	}
+	return int(h.Type), int(h.Flags), true
+}
+
+// setSize lays the entry out for size bytes of data. Resource entries have
+// no size field, so the unused part of the last block goes into Flags.
+func (t *TocEntry) setSize(size int) error {
+	blocks := (size + BLOCK_SIZE - 1) / BLOCK_SIZE
+	if blocks > TOC_MAX_USED_BLOCKS {
+		return ErrEntryTooLarge
+	}
+	t.Size = size
+	t.UsedBlocks = blocks
+	if t.IsResourceFile {
+		t.Flags = t.Flags&^TOC_PADDING_MASK | (t.UsedBlocks*BLOCK_SIZE-size)&TOC_PADDING_MASK
+	}
+	return nil
+}
+
+// AddResourceEntry adds a resource entry with an explicit resource type and
+// flags, for data whose RSC header should not be trusted or is missing.
+func (f *ImgFile) AddResourceEntry(name string, data []byte, resourceType, rscFlags int) error {
+	if uint32(rscFlags)&rsc.FLAGS_RESOURCE == 0 {
+		return fmt.Errorf("resource flags 0x%08X do not have any of the bits 0x%08X set", rscFlags, rsc.FLAGS_RESOURCE)
+	}
+	f.addEntry(&ImgEntry{
+		name: name,
+		data: data,
+		toc: TocEntry{
+			IsResourceFile: true,
+			RscFlags:       rscFlags,
+			RsourceType:    resourceType,
+			entrySize:      int(f.header.TocEntrySize),
+		},
+	})
+	return nil
+}
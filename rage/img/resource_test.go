@@ -0,0 +1,57 @@
+package img
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/mrchip53/gta-tools/rage/rsc"
+)
+
+func rscData(resourceType, flags uint32, size int) []byte {
+	b := make([]byte, size)
+	binary.LittleEndian.PutUint32(b[0:4], rsc.HEADER_MAGIC)
+	binary.LittleEndian.PutUint32(b[4:8], resourceType)
+	binary.LittleEndian.PutUint32(b[8:12], flags)
+	return b
+}
+
+func TestResourceEntriesSurviveRebuild(t *testing.T) {
+	f := NewImgFile()
+	f.AddEntry("model.wdr", rscData(110, 0xC0000123, 0x900))
+	f.AddEntry("plain.dat", make([]byte, 0x900))
+
+	e := f.Entries()[0]
+	toc := e.Toc()
+	if !toc.IsResourceFile || toc.RsourceType != 110 || toc.RscFlags != 0xC0000123 {
+		t.Fatalf("Expected resource entry from RSC header, got %+v", toc)
+	}
+	if toc.UsedBlocks != 2 || toc.Flags&TOC_PADDING_MASK != 0x700 {
+		t.Errorf("Expected 2 blocks with 0x700 bytes padding, got %+v", toc)
+	}
+	reread, err := NewTocEntry(toc.Bytes())
+	if err != nil || reread.Size != 0x900 || reread.RscFlags != toc.RscFlags {
+		t.Errorf("Expected toc to read back with size 0x900, got %+v (%v)", reread, err)
+	}
+	if plain := f.Entries()[1].Toc(); plain.IsResourceFile || plain.Flags != 0 {
+		t.Errorf("Expected plain entry, got %+v", plain)
+	}
+
+	e.SetData(rscData(110, 0xC0000456, 0x1000))
+	f.rebuild()
+	toc = e.Toc()
+	if toc.RscFlags != 0xC0000456 || toc.UsedBlocks != 2 || toc.Flags&TOC_PADDING_MASK != 0 {
+		t.Errorf("Expected replaced resource to update flags and padding, got %+v", toc)
+	}
+
+	if err := f.AddResourceEntry("bad.wtd", make([]byte, 16), 8, 0x123); err == nil {
+		t.Errorf("Expected an error for flags without resource bits")
+	}
+	if err := f.AddResourceEntry("tex.wtd", make([]byte, 16), 8, 0x80000001); err != nil {
+		t.Fatalf("Failed to add resource entry: %v", err)
+	}
+	for _, e := range f.Entries() {
+		if e.Name() == "tex.wtd" && (!e.Toc().IsResourceFile || e.Toc().RsourceType != 8) {
+			t.Errorf("Unexpected toc for added resource %+v", e.Toc())
+		}
+	}
+}
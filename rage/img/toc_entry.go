@@ -2,6 +2,15 @@ package img
 
 import (
 	"encoding/binary"
+	"fmt"
+)
+
+const (
+	TOC_ENTRY_MIN_SIZE = 16
+
+	// TOC_MAX_USED_BLOCKS is the most blocks the 16 bit UsedBlocks field of
+	// a TOC record can hold.
+	TOC_MAX_USED_BLOCKS = 0xFFFF
 )
 
 type TocEntry struct {
@@ -16,7 +25,10 @@ type TocEntry struct {
 	entrySize int
 }
 
-func NewTocEntry(data []byte) TocEntry {
+func NewTocEntry(data []byte) (TocEntry, error) {
+	if len(data) < TOC_ENTRY_MIN_SIZE {
+		return TocEntry{}, fmt.Errorf("toc entry needs %d bytes, got %d", TOC_ENTRY_MIN_SIZE, len(data))
+	}
 	t := TocEntry{entrySize: len(data)}
 	temp := binary.LittleEndian.Uint32(data[0:4])
 	t.IsResourceFile = (temp & 0xc0000000) != 0
@@ -38,7 +50,7 @@ func NewTocEntry(data []byte) TocEntry {
 		}
 		t.Size = size
 	}
-	return t
+	return t, nil
 }
 
 func (t *TocEntry) Bytes() []byte {
@@ -0,0 +1,275 @@
+package img
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/mrchip53/gta-tools/rage/rsc"
+	"github.com/mrchip53/gta-tools/rage/util"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Checks reported by Verify.
+const (
+	CHECK_HEADER    = "header"
+	CHECK_TOC       = "toc"
+	CHECK_NAMES     = "names"
+	CHECK_DUPLICATE = "duplicate"
+	CHECK_ALIGNMENT = "alignment"
+	CHECK_OVERLAP   = "overlap"
+	CHECK_TRUNCATED = "truncated"
+	CHECK_RESOURCE  = "resource"
+	CHECK_CONTENT   = "content"
+)
+
+// Issue is one problem found by Verify. Entry is the TOC index of the entry
+// it concerns, or -1 for problems with the archive as a whole.
+type Issue struct {
+	Severity Severity `json:"severity"`
+	Check    string   `json:"check"`
+	Entry    int      `json:"entry"`
+	Name     string   `json:"name,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// VerifyReport lists every problem Verify found in an archive.
+type VerifyReport struct {
+	Size      int64   `json:"size"`
+	Encrypted bool    `json:"encrypted"`
+	Entries   int     `json:"entries"`
+	Checked   int     `json:"checked"`
+	Issues    []Issue `json:"issues"`
+}
+
+// Errors counts the issues of error severity.
+func (r VerifyReport) Errors() int {
+	n := 0
+	for _, i := range r.Issues {
+		if i.Severity == SeverityError {
+			n++
+		}
+	}
+	return n
+}
+
+// OK reports whether the archive has no errors. Warnings are allowed.
+func (r VerifyReport) OK() bool { return r.Errors() == 0 }
+
+// EntryCheck validates the contents of an entry, for example by parsing it.
+type EntryCheck func(e *ImgEntry) error
+
+// Verify checks a size byte archive read from r without stopping at the
+// first problem. checks maps lower case file extensions such as ".sco" to
+// a check run on the contents of every entry with that extension. Entries
+// whose TOC record is unusable are not read.
+func Verify(r io.ReaderAt, size int64, checks map[string]EntryCheck) VerifyReport {
+	v := &verifier{r: r, report: VerifyReport{Size: size, Issues: []Issue{}}}
+	v.verify(checks)
+	return v.report
+}
+
+type verifier struct {
+	r      io.ReaderAt
+	report VerifyReport
+}
+
+func (v *verifier) add(sev Severity, check string, idx int, name, format string, args ...any) {
+	v.report.Issues = append(v.report.Issues, Issue{
+		Severity: sev,
+		Check:    check,
+		Entry:    idx,
+		Name:     name,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *verifier) verify(checks map[string]EntryCheck) {
+	size := v.report.Size
+	header, toc, ok := v.readToc()
+	if !ok {
+		return
+	}
+	v.report.Entries = int(header.EntryCount)
+
+	if header.Version != 3 {
+		v.add(SeverityWarning, CHECK_HEADER, -1, "", "version %d, expected 3", header.Version)
+	}
+	if size%BLOCK_SIZE != 0 {
+		v.add(SeverityWarning, CHECK_ALIGNMENT, -1, "", "file size 0x%X is not a multiple of the 0x%X byte block size", size, BLOCK_SIZE)
+	}
+
+	records := int(header.EntryCount) * int(header.TocEntrySize)
+	if records > len(toc) {
+		v.add(SeverityError, CHECK_TOC, -1, "", "%d records of %d bytes do not fit in TocSize %d", header.EntryCount, header.TocEntrySize, header.TocSize)
+		return
+	}
+
+	table := string(toc[records:])
+	if !strings.HasSuffix(table, "\x00") {
+		v.add(SeverityError, CHECK_NAMES, -1, "", "name table is not NUL terminated")
+	}
+	names := strings.Split(strings.TrimRight(table, "\x00"), "\x00")
+	if strings.Trim(table, "\x00") == "" {
+		names = nil
+	}
+	if len(names) < int(header.EntryCount) {
+		v.add(SeverityError, CHECK_NAMES, -1, "", "name table holds %d names for %d entries", len(names), header.EntryCount)
+	} else if len(names) > int(header.EntryCount) {
+		v.add(SeverityWarning, CHECK_NAMES, -1, "", "name table holds %d names for %d entries", len(names), header.EntryCount)
+	}
+
+	tocEnd := HEADER_SIZE + int64(header.TocSize)
+	type span struct {
+		idx        int
+		name       string
+		start, end int64
+	}
+	var spans []span
+	seen := map[string]int{}
+
+	for i := range int(header.EntryCount) {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		if name == "" {
+			v.add(SeverityError, CHECK_NAMES, i, "", "entry has no name")
+		} else if first, ok := seen[strings.ToLower(name)]; ok {
+			v.add(SeverityError, CHECK_DUPLICATE, i, name, "name already used by entry %d", first)
+		} else {
+			seen[strings.ToLower(name)] = i
+		}
+
+		t, err := NewTocEntry(toc[i*int(header.TocEntrySize) : (i+1)*int(header.TocEntrySize)])
+		if err != nil {
+			v.add(SeverityError, CHECK_TOC, i, name, "%v", err)
+			continue
+		}
+
+		start := int64(t.OffsetBlock) * BLOCK_SIZE
+		end := start + int64(t.UsedBlocks)*BLOCK_SIZE
+		if t.Size > t.UsedBlocks*BLOCK_SIZE {
+			v.add(SeverityError, CHECK_TOC, i, name, "size %d does not fit in %d blocks", t.Size, t.UsedBlocks)
+			continue
+		}
+		if !t.IsResourceFile && t.UsedBlocks*BLOCK_SIZE-t.Size >= BLOCK_SIZE {
+			v.add(SeverityWarning, CHECK_ALIGNMENT, i, name, "%d blocks allocated for %d bytes", t.UsedBlocks, t.Size)
+		}
+		if start < tocEnd {
+			v.add(SeverityError, CHECK_OVERLAP, i, name, "data at 0x%X starts inside the header and TOC ending at 0x%X", start, tocEnd)
+		}
+		if start+int64(t.Size) > size {
+			v.add(SeverityError, CHECK_TRUNCATED, i, name, "data 0x%X-0x%X extends past the end of the file at 0x%X", start, start+int64(t.Size), size)
+			continue
+		}
+		if end > size {
+			v.add(SeverityWarning, CHECK_TRUNCATED, i, name, "last block is cut short by %d bytes", end-size)
+		}
+		spans = append(spans, span{i, name, start, end})
+
+		if !t.IsResourceFile && checks[strings.ToLower(path.Ext(name))] == nil {
+			continue
+		}
+		e := &ImgEntry{idx: i, name: name, toc: t, data: make([]byte, t.Size)}
+		if _, err := v.r.ReadAt(e.data, start); err != nil {
+			v.add(SeverityError, CHECK_TRUNCATED, i, name, "read data: %v", err)
+			continue
+		}
+		v.report.Checked++
+		if t.IsResourceFile {
+			v.checkResource(e)
+		}
+		if check := checks[strings.ToLower(path.Ext(name))]; check != nil {
+			if err := check(e); err != nil {
+				v.add(SeverityError, CHECK_CONTENT, i, name, "%v", err)
+			}
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var last span
+	for i, s := range spans {
+		if i > 0 && s.start < last.end {
+			v.add(SeverityError, CHECK_OVERLAP, s.idx, s.name, "blocks overlap entry %d (%s)", last.idx, last.name)
+		}
+		if i == 0 || s.end > last.end {
+			last = s
+		}
+	}
+}
+
+// readToc reads and decrypts the header and TOC. It reports false if the
+// archive cannot be checked any further.
+func (v *verifier) readToc() (*ImgHeader, []byte, bool) {
+	size := v.report.Size
+	if size < HEADER_SIZE {
+		v.add(SeverityError, CHECK_HEADER, -1, "", "file of %d bytes is too short for a header", size)
+		return nil, nil, false
+	}
+	headerBytes := make([]byte, HEADER_SIZE)
+	if _, err := v.r.ReadAt(headerBytes, 0); err != nil {
+		v.add(SeverityError, CHECK_HEADER, -1, "", "read header: %v", err)
+		return nil, nil, false
+	}
+	v.report.Encrypted = binary.LittleEndian.Uint32(headerBytes[0:4]) != HEADER_MAGIC_BYTES
+	if v.report.Encrypted {
+		if err := util.Decrypt(headerBytes); err != nil {
+			v.add(SeverityError, CHECK_HEADER, -1, "", "decrypt header: %v", err)
+			return nil, nil, false
+		}
+		if m := binary.LittleEndian.Uint32(headerBytes[0:4]); m != HEADER_MAGIC_BYTES {
+			v.add(SeverityError, CHECK_HEADER, -1, "", "bad magic 0x%08X", m)
+			return nil, nil, false
+		}
+	}
+	header, err := ParseImgHeader(headerBytes)
+	if err != nil {
+		v.add(SeverityError, CHECK_HEADER, -1, "", "%v", err)
+		return nil, nil, false
+	}
+
+	if int64(header.TocSize) > size-HEADER_SIZE {
+		v.add(SeverityError, CHECK_TOC, -1, "", "TocSize %d extends past the end of the file", header.TocSize)
+		return nil, nil, false
+	}
+	toc := make([]byte, header.TocSize)
+	if _, err := v.r.ReadAt(toc, HEADER_SIZE); err != nil {
+		v.add(SeverityError, CHECK_TOC, -1, "", "read toc: %v", err)
+		return nil, nil, false
+	}
+	if v.report.Encrypted {
+		if err := util.Decrypt(toc); err != nil {
+			v.add(SeverityError, CHECK_TOC, -1, "", "decrypt toc: %v", err)
+			return nil, nil, false
+		}
+	}
+	return header, toc, true
+}
+
+// checkResource compares a resource entry's RSC header with its TOC record.
+func (v *verifier) checkResource(e *ImgEntry) {
+	h, err := rsc.ParseHeader(e.data)
+	if err != nil {
+		v.add(SeverityError, CHECK_RESOURCE, e.idx, e.name, "%v", err)
+		return
+	}
+	if int(h.Type) != e.toc.RsourceType {
+		v.add(SeverityError, CHECK_RESOURCE, e.idx, e.name, "RSC type %d does not match TOC type %d", h.Type, e.toc.RsourceType)
+	}
+	if h.Flags != uint32(e.toc.RscFlags) {
+		v.add(SeverityError, CHECK_RESOURCE, e.idx, e.name, "RSC flags 0x%08X do not match TOC flags 0x%08X", h.Flags, uint32(e.toc.RscFlags))
+	}
+	if _, err := rsc.Load(e.data); err != nil {
+		v.add(SeverityError, CHECK_RESOURCE, e.idx, e.name, "%v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package img
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestVerifyReportsEveryProblem(t *testing.T) {
+	b := buildPlainImg(t, []string{"a.sco", "A.SCO", "b.dat", "c.dat"}, []TocEntry{
+		{Size: 10, OffsetBlock: 1, UsedBlocks: 1},
+		{Size: 10, OffsetBlock: 2, UsedBlocks: 1},
+		{Size: 2 * BLOCK_SIZE, OffsetBlock: 2, UsedBlocks: 2},
+		{Size: 10, OffsetBlock: 9, UsedBlocks: 1},
+	}, 4)
+	copy(b[BLOCK_SIZE:], "bad")
+
+	checked := 0
+	r := Verify(bytes.NewReader(b), int64(len(b)), map[string]EntryCheck{
+		".sco": func(e *ImgEntry) error {
+			checked++
+			if bytes.HasPrefix(readData(t, e), []byte("bad")) {
+				return errors.New("bad script")
+			}
+			return nil
+		},
+	})
+	if r.Entries != 4 || checked != 2 {
+		t.Errorf("Expected 4 entries and 2 checked scripts, got %d and %d", r.Entries, checked)
+	}
+
+	want := map[string]int{CHECK_DUPLICATE: 1, CHECK_CONTENT: 0, CHECK_OVERLAP: 2, CHECK_TRUNCATED: 3}
+	for check, entry := range want {
+		found := false
+		for _, i := range r.Issues {
+			if i.Check == check && i.Entry == entry && i.Severity == SeverityError {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected a %s error on entry %d, got %+v", check, entry, r.Issues)
+		}
+	}
+	if r.OK() || r.Errors() != 4 {
+		t.Errorf("Expected 4 errors, got %+v", r.Issues)
+	}
+}
+
+func TestVerifyAcceptsWrittenArchive(t *testing.T) {
+	f := NewImgFile()
+	f.SetEncrypted(false)
+	f.AddEntry("a.sco", []byte("script"))
+	f.AddEntry("b.dat", bytes.Repeat([]byte{1}, BLOCK_SIZE+1))
+	b, err := f.Bytes()
+	if err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	r := Verify(bytes.NewReader(b), int64(len(b)), nil)
+	if len(r.Issues) != 0 || r.Entries != 2 || r.Encrypted {
+		t.Errorf("Expected a clean report, got %+v", r)
+	}
+
+	r = Verify(bytes.NewReader(b[:HEADER_SIZE+4]), HEADER_SIZE+4, nil)
+	if r.OK() || r.Issues[0].Check != CHECK_TOC {
+		t.Errorf("Expected a toc error for a cut off archive, got %+v", r.Issues)
+	}
+}
@@ -0,0 +1,148 @@
+package rpf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"path"
+)
+
+// node is a file or directory in the archive tree.
+type node interface {
+	baseName() string
+	tocRecord() *tocEntry
+}
+
+type Directory struct {
+	name     string
+	parent   *Directory
+	toc      tocEntry
+	children []node
+}
+
+func (d *Directory) baseName() string     { return d.name }
+func (d *Directory) tocRecord() *tocEntry { return &d.toc }
+
+func (d *Directory) Name() string { return d.name }
+
+// Path returns the slash separated path of the directory, "" for the root.
+func (d *Directory) Path() string {
+	if d.parent == nil {
+		return ""
+	}
+	return path.Join(d.parent.Path(), d.name)
+}
+
+func (d *Directory) Dirs() []*Directory {
+	var dirs []*Directory
+	for _, c := range d.children {
+		if dir, ok := c.(*Directory); ok {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func (d *Directory) Files() []*RpfEntry {
+	var files []*RpfEntry
+	for _, c := range d.children {
+		if f, ok := c.(*RpfEntry); ok {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+func (d *Directory) child(name string) node {
+	for _, c := range d.children {
+		if c.baseName() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+type RpfEntry struct {
+	idx    int
+	name   string
+	parent *Directory
+	toc    tocEntry
+	data   []byte // As stored in the archive, possibly compressed
+
+	// err is why the data last set could not be compressed. It is returned
+	// when the entry is read or written.
+	err error
+}
+
+func (e *RpfEntry) baseName() string     { return e.name }
+func (e *RpfEntry) tocRecord() *tocEntry { return &e.toc }
+
+// Name returns the full slash separated path of the entry.
+func (e RpfEntry) Name() string { return path.Join(e.parent.Path(), e.name) }
+
+func (e RpfEntry) BaseName() string { return e.name }
+
+func (e RpfEntry) Index() int { return e.idx }
+
+func (e RpfEntry) Size() int { return e.toc.Size }
+
+func (e RpfEntry) IsCompressed() bool { return e.toc.IsCompressed }
+
+func (e RpfEntry) IsResourceFile() bool { return e.toc.IsResourceFile }
+
+func (e RpfEntry) ResourceType() int { return e.toc.ResourceType }
+
+// ReadData returns the uncompressed contents of the entry.
+func (e RpfEntry) ReadData() ([]byte, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	if !e.toc.IsCompressed {
+		d := make([]byte, len(e.data))
+		copy(d, e.data)
+		return d, nil
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(e.data))
+	if err != nil {
+		return nil, fmt.Errorf("inflate %s: %w", e.Name(), err)
+	}
+	defer zr.Close()
+	d := make([]byte, e.toc.Size)
+	if _, err := io.ReadFull(zr, d); err != nil {
+		return nil, fmt.Errorf("inflate %s: %w", e.Name(), err)
+	}
+	return d, nil
+}
+
+// SetData replaces the contents of the entry, compressing them again if the
+// entry was stored compressed. A compression error is kept and returned by
+// ReadData and by the archive's Bytes.
+func (e *RpfEntry) SetData(data []byte) {
+	e.toc.Size = len(data)
+	e.err = nil
+	if !e.toc.IsCompressed {
+		e.data = data
+		e.toc.SizeInArchive = len(data)
+		return
+	}
+
+	d, err := deflate(data)
+	if err != nil {
+		e.err = fmt.Errorf("deflate %s: %w", e.Name(), err)
+	}
+	e.data = d
+	e.toc.SizeInArchive = len(e.data)
+}
+
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,24 @@
+package rpf
+
+import "fmt"
+
+// HeaderError reports an RPF header field that is missing or out of range.
+type HeaderError struct {
+	Field  string
+	Value  int64
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("rpf header: %s = %d: %s", e.Field, e.Value, e.Reason)
+}
+
+// TocError reports a TOC record that could not be read.
+type TocError struct {
+	Index  int
+	Reason string
+}
+
+func (e *TocError) Error() string {
+	return fmt.Sprintf("rpf toc entry %d: %s", e.Index, e.Reason)
+}
@@ -0,0 +1,18 @@
+package rpf
+
+import "strings"
+
+// Hash returns the one-at-a-time hash RPF3 archives store in place of entry
+// names. Names are hashed lower case.
+func Hash(name string) uint32 {
+	var h uint32
+	for _, c := range []byte(strings.ToLower(name)) {
+		h += uint32(c)
+		h += h << 10
+		h ^= h >> 6
+	}
+	h += h << 3
+	h ^= h >> 11
+	h += h << 15
+	return h
+}
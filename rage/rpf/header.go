@@ -0,0 +1,63 @@
+package rpf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	HEADER_MAGIC_RPF2 = 0x32465052
+	HEADER_MAGIC_RPF3 = 0x33465052
+
+	HEADER_SIZE = 20
+)
+
+type RpfHeader struct {
+	Identifier uint32
+	TocSize    int32
+	EntryCount int32
+	Unknown1   int32
+	Encrypted  int32
+}
+
+func ParseRpfHeader(data []byte) (*RpfHeader, error) {
+	if len(data) < HEADER_SIZE {
+		return nil, &HeaderError{Field: "length", Value: int64(len(data)), Reason: "not enough data to read header"}
+	}
+	h := &RpfHeader{
+		Identifier: binary.LittleEndian.Uint32(data[0:4]),
+		TocSize:    int32(binary.LittleEndian.Uint32(data[4:8])),
+		EntryCount: int32(binary.LittleEndian.Uint32(data[8:12])),
+		Unknown1:   int32(binary.LittleEndian.Uint32(data[12:16])),
+		Encrypted:  int32(binary.LittleEndian.Uint32(data[16:20])),
+	}
+	if h.Identifier != HEADER_MAGIC_RPF2 && h.Identifier != HEADER_MAGIC_RPF3 {
+		return nil, &HeaderError{Field: "Identifier", Value: int64(h.Identifier), Reason: "not an RPF2 or RPF3 archive"}
+	}
+	if h.TocSize < 0 {
+		return nil, &HeaderError{Field: "TocSize", Value: int64(h.TocSize), Reason: "negative"}
+	}
+	if h.EntryCount < 1 {
+		return nil, &HeaderError{Field: "EntryCount", Value: int64(h.EntryCount), Reason: "archive has no root directory"}
+	}
+	if int(h.EntryCount)*TOC_ENTRY_SIZE > int(h.TocSize) {
+		return nil, &HeaderError{Field: "EntryCount", Value: int64(h.EntryCount), Reason: fmt.Sprintf("%d records do not fit in TocSize %d", h.EntryCount, h.TocSize)}
+	}
+	return h, nil
+}
+
+// HashedNames reports whether entry names are stored as hashes instead of
+// offsets into the name table.
+func (h *RpfHeader) HashedNames() bool {
+	return h.Identifier == HEADER_MAGIC_RPF3
+}
+
+func (h *RpfHeader) write() []byte {
+	header := make([]byte, HEADER_SIZE)
+	binary.LittleEndian.PutUint32(header[0:4], h.Identifier)
+	binary.LittleEndian.PutUint32(header[4:8], uint32(h.TocSize))
+	binary.LittleEndian.PutUint32(header[8:12], uint32(h.EntryCount))
+	binary.LittleEndian.PutUint32(header[12:16], uint32(h.Unknown1))
+	binary.LittleEndian.PutUint32(header[16:20], uint32(h.Encrypted))
+	return header
+}
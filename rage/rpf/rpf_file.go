@@ -0,0 +1,356 @@
+package rpf
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/mrchip53/gta-tools/rage"
+	"github.com/mrchip53/gta-tools/rage/util"
+)
+
+const (
+	TOC_OFFSET = 0x800
+	BLOCK_SIZE = 0x800
+)
+
+type RpfFile struct {
+	header    *RpfHeader
+	root      *Directory
+	entries   []*RpfEntry
+	encrypted bool
+}
+
+// NewRpfFile returns an empty unencrypted archive with the given identifier,
+// HEADER_MAGIC_RPF2 or HEADER_MAGIC_RPF3.
+func NewRpfFile(identifier uint32) *RpfFile {
+	return &RpfFile{
+		header: &RpfHeader{Identifier: identifier, EntryCount: 1, TocSize: TOC_ENTRY_SIZE},
+		root:   &Directory{toc: tocEntry{IsDirectory: true}},
+	}
+}
+
+func (f RpfFile) Header() RpfHeader { return *f.header }
+
+func (f RpfFile) Encrypted() bool { return f.encrypted }
+
+// SetEncrypted chooses whether Bytes encrypts the TOC.
+func (f *RpfFile) SetEncrypted(encrypted bool) {
+	f.encrypted = encrypted
+	if !encrypted {
+		f.header.Encrypted = 0
+	} else if f.header.Encrypted == 0 {
+		f.header.Encrypted = 1
+	}
+}
+
+func (f RpfFile) Root() *Directory { return f.root }
+
+func (f RpfFile) Entries() []*RpfEntry { return f.entries }
+
+func (f RpfFile) Files() []rage.Entry {
+	files := make([]rage.Entry, len(f.entries))
+	for i, e := range f.entries {
+		files[i] = e
+	}
+	return files
+}
+
+// reindex flattens the tree into entries, depth first with files ordered
+// by path, and numbers them.
+func (f *RpfFile) reindex() {
+	f.entries = f.entries[:0]
+	var walk func(d *Directory)
+	walk = func(d *Directory) {
+		for _, c := range d.children {
+			switch c := c.(type) {
+			case *RpfEntry:
+				f.entries = append(f.entries, c)
+			case *Directory:
+				walk(c)
+			}
+		}
+	}
+	walk(f.root)
+	sort.SliceStable(f.entries, func(i, j int) bool {
+		return f.entries[i].Name() < f.entries[j].Name()
+	})
+	for i, e := range f.entries {
+		e.idx = i
+	}
+}
+
+// AddEntry stores data at the slash separated name, creating any missing
+// directories. An existing file with the same name is replaced.
+func (f *RpfFile) AddEntry(name string, data []byte) {
+	parts := strings.Split(strings.Trim(path.Clean(strings.ReplaceAll(name, "\\", "/")), "/"), "/")
+	dir := f.root
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := f.child(dir, p).(*Directory)
+		if !ok {
+			next = &Directory{name: p, parent: dir, toc: tocEntry{IsDirectory: true, Name: Hash(p)}}
+			dir.children = append(dir.children, next)
+			f.sortChildren(dir)
+		}
+		dir = next
+	}
+
+	base := parts[len(parts)-1]
+	if existing, ok := f.child(dir, base).(*RpfEntry); ok {
+		existing.SetData(data)
+		return
+	}
+	e := &RpfEntry{name: base, parent: dir, toc: tocEntry{Name: Hash(base)}}
+	e.SetData(data)
+	dir.children = append(dir.children, e)
+	f.sortChildren(dir)
+	f.reindex()
+}
+
+// child finds the child of d called name. Children of RPF3 archives are
+// matched by name hash, as loaded ones are only named after their hash.
+func (f *RpfFile) child(d *Directory, name string) node {
+	if !f.header.HashedNames() {
+		return d.child(name)
+	}
+	h := Hash(name)
+	for _, c := range d.children {
+		if c.tocRecord().Name == h {
+			return c
+		}
+	}
+	return nil
+}
+
+// sortChildren orders a directory's children the way the game looks them
+// up: by name hash in RPF3 archives and by name otherwise.
+func (f *RpfFile) sortChildren(d *Directory) {
+	sort.SliceStable(d.children, func(i, j int) bool {
+		if f.header.HashedNames() {
+			return d.children[i].tocRecord().Name < d.children[j].tocRecord().Name
+		}
+		return d.children[i].baseName() < d.children[j].baseName()
+	})
+}
+
+func (f *RpfFile) RemoveEntry(idx int) {
+	if idx < 0 || idx >= len(f.entries) {
+		return
+	}
+	e := f.entries[idx]
+	d := e.parent
+	for i, c := range d.children {
+		if c == node(e) {
+			d.children = append(d.children[:i], d.children[i+1:]...)
+			break
+		}
+	}
+	f.reindex()
+}
+
+// ResolveNames names RPF3 entries whose hash matches one of the given
+// names, returning how many were resolved.
+func (f *RpfFile) ResolveNames(names []string) int {
+	if !f.header.HashedNames() {
+		return 0
+	}
+	byHash := make(map[uint32]string, len(names))
+	for _, n := range names {
+		for _, p := range strings.Split(strings.ReplaceAll(n, "\\", "/"), "/") {
+			byHash[Hash(p)] = p
+		}
+	}
+
+	resolved := 0
+	var walk func(d *Directory)
+	walk = func(d *Directory) {
+		for _, c := range d.children {
+			if n, ok := byHash[c.tocRecord().Name]; ok {
+				switch c := c.(type) {
+				case *RpfEntry:
+					c.name = n
+				case *Directory:
+					c.name = n
+				}
+				resolved++
+			}
+			if dir, ok := c.(*Directory); ok {
+				walk(dir)
+			}
+		}
+	}
+	walk(f.root)
+	f.reindex()
+	return resolved
+}
+
+// layout orders the tree breadth first so every directory's children
+// occupy consecutive TOC records, as the format requires.
+func (f *RpfFile) layout() []node {
+	nodes := []node{f.root}
+	for i := 0; i < len(nodes); i++ {
+		d, ok := nodes[i].(*Directory)
+		if !ok {
+			continue
+		}
+		d.toc.ContentIndex = len(nodes)
+		d.toc.ContentCount = len(d.children)
+		nodes = append(nodes, d.children...)
+	}
+	return nodes
+}
+
+// Bytes lays the tree out and serialises it, updating the header and the
+// TOC records of every entry to the new layout.
+func (f *RpfFile) Bytes() ([]byte, error) {
+	for _, e := range f.entries {
+		if e.err != nil {
+			return nil, e.err
+		}
+	}
+	nodes := f.layout()
+
+	var names []byte
+	if !f.header.HashedNames() {
+		for _, n := range nodes {
+			n.tocRecord().Name = uint32(len(names))
+			names = append(names, []byte(n.baseName()+"\x00")...)
+		}
+	}
+
+	tocSize := len(nodes)*TOC_ENTRY_SIZE + len(names)
+	tocSize = alignUp(tocSize, 16)
+
+	curOffset := alignUp(TOC_OFFSET+tocSize, BLOCK_SIZE)
+	for _, n := range nodes {
+		if e, ok := n.(*RpfEntry); ok {
+			e.toc.Offset = curOffset
+			curOffset += alignUp(len(e.data), BLOCK_SIZE)
+		}
+	}
+
+	f.header.EntryCount = int32(len(nodes))
+	f.header.TocSize = int32(tocSize)
+
+	toc := make([]byte, 0, tocSize)
+	for _, n := range nodes {
+		toc = append(toc, n.tocRecord().Bytes()...)
+	}
+	toc = append(toc, names...)
+	toc = append(toc, make([]byte, tocSize-len(toc))...)
+	if f.encrypted {
+		if err := util.Encrypt(toc); err != nil {
+			return nil, fmt.Errorf("encrypt rpf toc: %w", err)
+		}
+	}
+
+	out := make([]byte, curOffset)
+	copy(out, f.header.write())
+	copy(out[TOC_OFFSET:], toc)
+	for _, n := range nodes {
+		if e, ok := n.(*RpfEntry); ok {
+			copy(out[e.toc.Offset:], e.data)
+		}
+	}
+	return out, nil
+}
+
+func LoadRpfFile(data []byte) (*RpfFile, error) {
+	header, err := ParseRpfHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if TOC_OFFSET+int(header.TocSize) > len(data) {
+		return nil, &HeaderError{Field: "TocSize", Value: int64(header.TocSize), Reason: "extends past end of file"}
+	}
+
+	encrypted := header.Encrypted != 0
+	toc := make([]byte, header.TocSize)
+	copy(toc, data[TOC_OFFSET:TOC_OFFSET+int(header.TocSize)])
+	if encrypted {
+		if err := util.Decrypt(toc); err != nil {
+			return nil, fmt.Errorf("decrypt rpf toc: %w", err)
+		}
+	}
+
+	count := int(header.EntryCount)
+	records := make([]tocEntry, count)
+	for i := range count {
+		records[i] = newTocEntry(toc[i*TOC_ENTRY_SIZE : (i+1)*TOC_ENTRY_SIZE])
+	}
+	nameTable := toc[count*TOC_ENTRY_SIZE:]
+
+	nameOf := func(i int) (string, error) {
+		t := records[i]
+		if header.HashedNames() {
+			return fmt.Sprintf("0x%08X", t.Name), nil
+		}
+		if int(t.Name) >= len(nameTable) {
+			return "", &TocError{Index: i, Reason: fmt.Sprintf("name offset %d outside name table", t.Name)}
+		}
+		n := nameTable[t.Name:]
+		if end := strings.IndexByte(string(n), 0); end >= 0 {
+			n = n[:end]
+		}
+		return string(n), nil
+	}
+
+	if !records[0].IsDirectory {
+		return nil, &TocError{Index: 0, Reason: "root is not a directory"}
+	}
+	f := &RpfFile{header: header, encrypted: encrypted}
+	f.root = &Directory{toc: records[0]}
+
+	visited := make([]bool, count)
+	visited[0] = true
+	var build func(d *Directory, idx int) error
+	build = func(d *Directory, idx int) error {
+		start, n := d.toc.ContentIndex, d.toc.ContentCount
+		if start < 0 || start+n > count {
+			return &TocError{Index: idx, Reason: fmt.Sprintf("children %d..%d outside %d records", start, start+n, count)}
+		}
+		for i := start; i < start+n; i++ {
+			if visited[i] {
+				return &TocError{Index: i, Reason: "record belongs to more than one directory"}
+			}
+			visited[i] = true
+			name, err := nameOf(i)
+			if err != nil {
+				return err
+			}
+			r := records[i]
+			if r.IsDirectory {
+				child := &Directory{name: name, parent: d, toc: r}
+				d.children = append(d.children, child)
+				if err := build(child, i); err != nil {
+					return err
+				}
+				continue
+			}
+			if r.Offset < 0 || r.SizeInArchive < 0 || r.Offset+r.SizeInArchive > len(data) {
+				return &TocError{Index: i, Reason: fmt.Sprintf("data range 0x%X-0x%X outside archive of 0x%X bytes", r.Offset, r.Offset+r.SizeInArchive, len(data))}
+			}
+			d.children = append(d.children, &RpfEntry{
+				name:   name,
+				parent: d,
+				toc:    r,
+				data:   data[r.Offset : r.Offset+r.SizeInArchive],
+			})
+		}
+		return nil
+	}
+	if err := build(f.root, 0); err != nil {
+		return nil, err
+	}
+
+	f.reindex()
+	return f, nil
+}
+
+func alignUp(n, align int) int {
+	if n%align != 0 {
+		n += align - n%align
+	}
+	return n
+}
@@ -0,0 +1,225 @@
+package rpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/mrchip53/gta-tools/rage/util"
+)
+
+func TestRpfFileRoundTrip(t *testing.T) {
+	for _, id := range []uint32{HEADER_MAGIC_RPF2, HEADER_MAGIC_RPF3} {
+		f := NewRpfFile(id)
+		f.AddEntry("common/data/handling.dat", []byte("handling"))
+		f.AddEntry("common/text/american.gxt", bytes.Repeat([]byte{0xAB}, BLOCK_SIZE+1))
+		f.AddEntry("readme.txt", []byte("hello"))
+
+		packed := f.Root().child("readme.txt").(*RpfEntry)
+		packed.toc.IsCompressed = true
+		packed.SetData([]byte("hello hello hello hello"))
+
+		b, err := f.Bytes()
+		if err != nil {
+			t.Fatalf("Failed to write archive 0x%08X: %v", id, err)
+		}
+		loaded, err := LoadRpfFile(b)
+		if err != nil {
+			t.Fatalf("Failed to load archive 0x%08X: %v", id, err)
+		}
+		if id == HEADER_MAGIC_RPF3 {
+			if n := loaded.ResolveNames([]string{"common/data/handling.dat", "common/text/american.gxt", "readme.txt"}); n != 6 {
+				t.Errorf("Expected 6 resolved names, got %d", n)
+			}
+		}
+
+		if len(loaded.Entries()) != len(f.Entries()) {
+			t.Fatalf("Expected %d entries, got %d", len(f.Entries()), len(loaded.Entries()))
+		}
+		for i, e := range f.Entries() {
+			l := loaded.Entries()[i]
+			if e.Name() != l.Name() {
+				t.Errorf("Entry %d: names do not match. Got '%s' and '%s'", i, e.Name(), l.Name())
+			}
+			if !bytes.Equal(readData(t, e), readData(t, l)) {
+				t.Errorf("Entry %d ('%s'): data does not match", i, e.Name())
+			}
+			if e.IsCompressed() != l.IsCompressed() {
+				t.Errorf("Entry %d ('%s'): compression does not match", i, e.Name())
+			}
+		}
+	}
+}
+
+func TestRpfFileEncryptedToc(t *testing.T) {
+	util.SetAesKey(bytes.Repeat([]byte{0x5A}, 32))
+	defer util.SetAesKey(nil)
+
+	f := NewRpfFile(HEADER_MAGIC_RPF2)
+	f.SetEncrypted(true)
+	f.AddEntry("common/data/handling.dat", []byte("handling"))
+
+	b, err := f.Bytes()
+	if err != nil {
+		t.Fatalf("Failed to write encrypted archive: %v", err)
+	}
+	if bytes.Contains(b[TOC_OFFSET:TOC_OFFSET+f.Header().TocSize], []byte("handling.dat")) {
+		t.Errorf("Expected the name table to be encrypted")
+	}
+	loaded, err := LoadRpfFile(b)
+	if err != nil {
+		t.Fatalf("Failed to load encrypted archive: %v", err)
+	}
+	if !loaded.Encrypted() || len(loaded.Entries()) != 1 || loaded.Entries()[0].Name() != "common/data/handling.dat" {
+		t.Fatalf("Unexpected entries %+v", loaded.Entries())
+	}
+	if string(readData(t, loaded.Entries()[0])) != "handling" {
+		t.Errorf("Entry data does not match")
+	}
+
+	util.SetAesKey(nil)
+	if _, err := LoadRpfFile(b); !errors.Is(err, util.ErrAesKeyNotSet) {
+		t.Errorf("Expected ErrAesKeyNotSet loading without a key, got %v", err)
+	}
+	if _, err := f.Bytes(); !errors.Is(err, util.ErrAesKeyNotSet) {
+		t.Errorf("Expected ErrAesKeyNotSet writing without a key, got %v", err)
+	}
+}
+
+func TestRpf3SortsChildrenByNameHash(t *testing.T) {
+	names := []string{"zeta.dat", "alpha.dat", "Mid.dat", "dir/b.dat", "dir/a.dat", "other/c.dat"}
+	f := NewRpfFile(HEADER_MAGIC_RPF3)
+	for _, n := range names {
+		f.AddEntry(n, []byte(n))
+	}
+
+	b, err := f.Bytes()
+	if err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	loaded, err := LoadRpfFile(b)
+	if err != nil {
+		t.Fatalf("Failed to load archive: %v", err)
+	}
+	var check func(d *Directory)
+	check = func(d *Directory) {
+		for i, c := range d.children {
+			if i > 0 && c.tocRecord().Name <= d.children[i-1].tocRecord().Name {
+				t.Errorf("Directory %q: child %d hash 0x%08X not above 0x%08X", d.Path(), i, c.tocRecord().Name, d.children[i-1].tocRecord().Name)
+			}
+			if dir, ok := c.(*Directory); ok {
+				check(dir)
+			}
+		}
+	}
+	check(loaded.Root())
+
+	if n := loaded.ResolveNames(names); n != len(names)+2 {
+		t.Errorf("Expected %d resolved names, got %d", len(names)+2, n)
+	}
+	for _, e := range loaded.Entries() {
+		if string(readData(t, e)) != e.Name() {
+			t.Errorf("Entry %s: data does not match its name", e.Name())
+		}
+	}
+}
+
+func TestRpf3AddEntryMatchesLoadedHashes(t *testing.T) {
+	f := NewRpfFile(HEADER_MAGIC_RPF3)
+	f.AddEntry("data/a.sco", []byte("old"))
+	b, err := f.Bytes()
+	if err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	loaded, err := LoadRpfFile(b)
+	if err != nil {
+		t.Fatalf("Failed to load archive: %v", err)
+	}
+
+	loaded.AddEntry("data/b.sco", []byte("new"))
+	loaded.AddEntry("data/a.sco", []byte("replaced"))
+	dirs := loaded.Root().Dirs()
+	if len(dirs) != 1 || len(dirs[0].Files()) != 2 {
+		t.Fatalf("Expected one directory holding 2 files, got %d directories", len(dirs))
+	}
+	for _, e := range dirs[0].Files() {
+		want := map[uint32]string{Hash("a.sco"): "replaced", Hash("b.sco"): "new"}[e.toc.Name]
+		if string(readData(t, e)) != want {
+			t.Errorf("Entry %s: expected %q, got %q", e.Name(), want, readData(t, e))
+		}
+	}
+}
+
+func TestRpfCompressedEntries(t *testing.T) {
+	data := bytes.Repeat([]byte("compressible "), 100)
+	f := NewRpfFile(HEADER_MAGIC_RPF2)
+	f.AddEntry("a.dat", nil)
+	e := f.Entries()[0]
+	e.toc.IsCompressed = true
+	e.SetData(data)
+	if e.Size() != len(data) || e.toc.SizeInArchive >= len(data) {
+		t.Errorf("Expected %d bytes stored compressed, got %d in archive", e.Size(), e.toc.SizeInArchive)
+	}
+
+	b, err := f.Bytes()
+	if err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	loaded, err := LoadRpfFile(b)
+	if err != nil {
+		t.Fatalf("Failed to load archive: %v", err)
+	}
+	l := loaded.Entries()[0]
+	if !l.IsCompressed() || !bytes.Equal(readData(t, l), data) {
+		t.Errorf("Compressed entry did not round trip")
+	}
+
+	l.data = []byte("not zlib")
+	if _, err := l.ReadData(); err == nil {
+		t.Errorf("Expected an error inflating corrupt data")
+	}
+}
+
+func TestRpfStockCompressedRecord(t *testing.T) {
+	data := bytes.Repeat([]byte("stock "), 200)
+	packed, err := deflate(data)
+	if err != nil {
+		t.Fatalf("Failed to deflate: %v", err)
+	}
+	f := NewRpfFile(HEADER_MAGIC_RPF2)
+	f.AddEntry("a.dat", packed)
+	b, err := f.Bytes()
+	if err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+
+	// Rewrite the file record the way stock archives store it: the
+	// uncompressed size in word 1 and the stored size with the compressed
+	// flag in word 3.
+	record := b[TOC_OFFSET+TOC_ENTRY_SIZE : TOC_OFFSET+2*TOC_ENTRY_SIZE]
+	binary.LittleEndian.PutUint32(record[4:8], uint32(len(data)))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(len(packed))|0x40000000)
+	stock := append([]byte(nil), record...)
+
+	loaded, err := LoadRpfFile(b)
+	if err != nil {
+		t.Fatalf("Failed to load archive: %v", err)
+	}
+	l := loaded.Entries()[0]
+	if !l.IsCompressed() || l.Size() != len(data) || !bytes.Equal(readData(t, l), data) {
+		t.Errorf("Expected %d bytes inflated from a stock record, got compressed=%v size=%d", len(data), l.IsCompressed(), l.Size())
+	}
+	if r := l.toc.Bytes(); !bytes.Equal(r, stock) {
+		t.Errorf("Expected the record to be written back as %X, got %X", stock, r)
+	}
+}
+
+func readData(t *testing.T, e *RpfEntry) []byte {
+	t.Helper()
+	d, err := e.ReadData()
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", e.Name(), err)
+	}
+	return d
+}
@@ -0,0 +1,89 @@
+package rpf
+
+import "encoding/binary"
+
+const (
+	TOC_ENTRY_SIZE = 16
+
+	tocDirectoryFlag  = 0x80000000
+	tocResourceFlags  = 0xC0000000
+	tocCompressedFlag = 0x40000000
+)
+
+// tocEntry is the raw 16 byte TOC record shared by files and directories.
+type tocEntry struct {
+	Name uint32 // Offset into the name table, or the name hash in RPF3
+
+	// Directory records
+	IsDirectory  bool
+	Flags        uint32
+	ContentIndex int
+	ContentCount int
+	countFlags   uint32
+
+	// File records
+	SizeInArchive  int
+	Offset         int
+	Size           int
+	IsCompressed   bool
+	IsResourceFile bool
+	ResourceType   int
+	RscFlags       uint32
+}
+
+func newTocEntry(data []byte) tocEntry {
+	t := tocEntry{Name: binary.LittleEndian.Uint32(data[0:4])}
+	w1 := binary.LittleEndian.Uint32(data[4:8])
+	w2 := binary.LittleEndian.Uint32(data[8:12])
+	w3 := binary.LittleEndian.Uint32(data[12:16])
+
+	if w2&tocDirectoryFlag != 0 {
+		t.IsDirectory = true
+		t.Flags = w1
+		t.ContentIndex = int(w2 &^ tocDirectoryFlag)
+		t.ContentCount = int(w3 & 0x0FFFFFFF)
+		t.countFlags = w3 &^ 0x0FFFFFFF
+		return t
+	}
+
+	t.Size = int(w1)
+	t.IsResourceFile = w3&tocResourceFlags == tocResourceFlags
+	if t.IsResourceFile {
+		t.ResourceType = int(w2 & 0xFF)
+		t.Offset = int(w2 & 0x7FFFFF00)
+		t.SizeInArchive = t.Size
+		t.RscFlags = w3
+	} else {
+		// w1 holds the uncompressed size and w3 the stored size, with the
+		// compressed flag in bit 30.
+		t.Offset = int(w2)
+		t.SizeInArchive = int(w3 &^ tocCompressedFlag)
+		t.IsCompressed = w3&tocCompressedFlag != 0
+	}
+	return t
+}
+
+func (t *tocEntry) Bytes() []byte {
+	b := make([]byte, TOC_ENTRY_SIZE)
+	binary.LittleEndian.PutUint32(b[0:4], t.Name)
+	if t.IsDirectory {
+		binary.LittleEndian.PutUint32(b[4:8], t.Flags)
+		binary.LittleEndian.PutUint32(b[8:12], uint32(t.ContentIndex)|tocDirectoryFlag)
+		binary.LittleEndian.PutUint32(b[12:16], uint32(t.ContentCount)|t.countFlags)
+		return b
+	}
+
+	binary.LittleEndian.PutUint32(b[4:8], uint32(t.Size))
+	if t.IsResourceFile {
+		binary.LittleEndian.PutUint32(b[8:12], uint32(t.Offset)|uint32(t.ResourceType&0xFF))
+		binary.LittleEndian.PutUint32(b[12:16], t.RscFlags)
+	} else {
+		w3 := uint32(t.SizeInArchive)
+		if t.IsCompressed {
+			w3 |= tocCompressedFlag
+		}
+		binary.LittleEndian.PutUint32(b[8:12], uint32(t.Offset))
+		binary.LittleEndian.PutUint32(b[12:16], w3)
+	}
+	return b
+}
@@ -0,0 +1,14 @@
+package rsc
+
+import "fmt"
+
+// HeaderError reports an RSC header field that is missing or out of range.
+type HeaderError struct {
+	Field  string
+	Value  int64
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("rsc header: %s = %d: %s", e.Field, e.Value, e.Reason)
+}
@@ -0,0 +1,101 @@
+package rsc
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	HEADER_MAGIC = 0x05435352 // "RSC\x05"
+	HEADER_SIZE  = 12
+
+	// FLAGS_RESOURCE are the top bits set on every resource's flags. The IMG
+	// TOC uses them to tell resources from plain files.
+	FLAGS_RESOURCE = 0xC0000000
+
+	flagsSystemSize   = 0x00007FFF
+	flagsGraphicsSize = 0x3FFF8000
+)
+
+// Known resource types. The type doubles as the resource version.
+const (
+	TYPE_ANIMATION_DICTIONARY = 1
+	TYPE_TEXTURE_DICTIONARY   = 8
+	TYPE_BOUNDS               = 32
+	TYPE_DRAWABLE             = 110
+	TYPE_FRAGMENT             = 112
+)
+
+var TypeNames = map[uint32]string{
+	TYPE_ANIMATION_DICTIONARY: "Animation dictionary",
+	TYPE_TEXTURE_DICTIONARY:   "Texture dictionary",
+	TYPE_BOUNDS:               "Bounds",
+	TYPE_DRAWABLE:             "Drawable",
+	TYPE_FRAGMENT:             "Fragment",
+}
+
+type Header struct {
+	Identifier uint32
+	Type       uint32
+	Flags      uint32
+}
+
+func ParseHeader(data []byte) (*Header, error) {
+	if len(data) < HEADER_SIZE {
+		return nil, &HeaderError{Field: "length", Value: int64(len(data)), Reason: "not enough data to read header"}
+	}
+	h := &Header{
+		Identifier: binary.LittleEndian.Uint32(data[0:4]),
+		Type:       binary.LittleEndian.Uint32(data[4:8]),
+		Flags:      binary.LittleEndian.Uint32(data[8:12]),
+	}
+	if h.Identifier != HEADER_MAGIC {
+		return nil, &HeaderError{Field: "Identifier", Value: int64(h.Identifier), Reason: "not an RSC5 resource"}
+	}
+	return h, nil
+}
+
+// IsResource reports whether data starts with an RSC header.
+func IsResource(data []byte) bool {
+	_, err := ParseHeader(data)
+	return err == nil
+}
+
+func (h Header) TypeName() string {
+	if n, ok := TypeNames[h.Type]; ok {
+		return n
+	}
+	return fmt.Sprintf("Unknown (%d)", h.Type)
+}
+
+// SystemSize is the size of the virtual (system memory) segment.
+func (h Header) SystemSize() int { return segmentSize(h.Flags) }
+
+// GraphicsSize is the size of the physical (graphics memory) segment.
+func (h Header) GraphicsSize() int { return segmentSize(h.Flags >> 15) }
+
+// segmentSize decodes an 11 bit block count and 4 bit shift.
+func segmentSize(f uint32) int {
+	return int(f&0x7FF) << ((f>>11)&0xF + 8)
+}
+
+// encodeSegmentSize returns the smallest encodable size holding n bytes
+// together with its encoding.
+func encodeSegmentSize(n int) (int, uint32, error) {
+	for shift := uint32(0); shift <= 0xF; shift++ {
+		unit := 1 << (shift + 8)
+		count := (n + unit - 1) / unit
+		if count <= 0x7FF {
+			return count * unit, uint32(count) | shift<<11, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("segment of %d bytes is too large", n)
+}
+
+func (h *Header) write() []byte {
+	b := make([]byte, HEADER_SIZE)
+	binary.LittleEndian.PutUint32(b[0:4], h.Identifier)
+	binary.LittleEndian.PutUint32(b[4:8], h.Type)
+	binary.LittleEndian.PutUint32(b[8:12], h.Flags)
+	return b
+}
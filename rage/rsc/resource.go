@@ -0,0 +1,88 @@
+package rsc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+// Resource is an RSC5 resource inflated into its two memory segments.
+type Resource struct {
+	Header   Header
+	System   []byte
+	Graphics []byte
+
+	compressedSize int
+}
+
+// Load parses the header and inflates the payload that follows it. The
+// payload may not inflate to more than the segment sizes in the header.
+func Load(data []byte) (*Resource, error) {
+	h, err := ParseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(data[HEADER_SIZE:]))
+	if err != nil {
+		return nil, fmt.Errorf("inflate resource: %w", err)
+	}
+	defer zr.Close()
+	limit := int64(h.SystemSize()) + int64(h.GraphicsSize())
+	payload, err := io.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("inflate resource: %w", err)
+	}
+	if int64(len(payload)) > limit {
+		return nil, &HeaderError{Field: "Flags", Value: int64(h.Flags), Reason: fmt.Sprintf("payload inflates past the %d bytes of its segments", limit)}
+	}
+
+	sys := h.SystemSize()
+	if len(payload) < sys {
+		return nil, &HeaderError{Field: "Flags", Value: int64(h.Flags), Reason: fmt.Sprintf("system segment of %d bytes but only %d inflated", sys, len(payload))}
+	}
+	return &Resource{
+		Header:         *h,
+		System:         payload[:sys],
+		Graphics:       payload[sys:],
+		compressedSize: len(data) - HEADER_SIZE,
+	}, nil
+}
+
+// CompressedSize is the size of the payload as it was loaded.
+func (r *Resource) CompressedSize() int { return r.compressedSize }
+
+// Bytes compresses both segments behind a copy of the header with its size
+// flags set to match them. Segments are zero padded to the next encodable
+// size. r is not modified.
+func (r *Resource) Bytes() ([]byte, error) {
+	sysSize, sysBits, err := encodeSegmentSize(len(r.System))
+	if err != nil {
+		return nil, err
+	}
+	gfxSize, gfxBits, err := encodeSegmentSize(len(r.Graphics))
+	if err != nil {
+		return nil, err
+	}
+	h := r.Header
+	h.Flags = h.Flags&^(flagsSystemSize|flagsGraphicsSize) | sysBits | gfxBits<<15
+
+	payload := make([]byte, sysSize+gfxSize)
+	copy(payload, r.System)
+	copy(payload[sysSize:], r.Graphics)
+
+	var buf bytes.Buffer
+	buf.Write(h.write())
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
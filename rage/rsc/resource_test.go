@@ -0,0 +1,56 @@
+package rsc
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestResourceRoundTrip(t *testing.T) {
+	r := &Resource{
+		Header:   Header{Identifier: HEADER_MAGIC, Type: TYPE_DRAWABLE, Flags: FLAGS_RESOURCE},
+		System:   bytes.Repeat([]byte{0x11}, 0x300),
+		Graphics: bytes.Repeat([]byte{0x22}, 0x1234),
+	}
+	b, err := r.Bytes()
+	if err != nil {
+		t.Fatalf("Failed to write resource: %v", err)
+	}
+	if !IsResource(b) {
+		t.Fatalf("Expected written data to start with an RSC header")
+	}
+
+	loaded, err := Load(b)
+	if err != nil {
+		t.Fatalf("Failed to load resource: %v", err)
+	}
+	h := loaded.Header
+	if h.Type != TYPE_DRAWABLE || h.Flags&FLAGS_RESOURCE != FLAGS_RESOURCE || h.TypeName() != "Drawable" {
+		t.Errorf("Unexpected header %+v", h)
+	}
+	if h.SystemSize() != 0x300 || h.GraphicsSize() != 0x1300 {
+		t.Errorf("Expected segments of 0x300 and 0x1300 bytes, got 0x%X and 0x%X", h.SystemSize(), h.GraphicsSize())
+	}
+	if !bytes.Equal(loaded.System, r.System) || !bytes.Equal(loaded.Graphics[:len(r.Graphics)], r.Graphics) {
+		t.Errorf("Segments do not match after round trip")
+	}
+	if loaded.CompressedSize() != len(b)-HEADER_SIZE {
+		t.Errorf("Expected compressed size %d, got %d", len(b)-HEADER_SIZE, loaded.CompressedSize())
+	}
+
+	if r.Header.Flags != FLAGS_RESOURCE {
+		t.Errorf("Expected Bytes to leave the header alone, got flags 0x%08X", r.Header.Flags)
+	}
+
+	var headerErr *HeaderError
+	if _, err := Load([]byte("RSC")); !errors.As(err, &headerErr) {
+		t.Errorf("Expected HeaderError for truncated header, got %v", err)
+	}
+
+	// A header claiming smaller segments than the payload inflates to.
+	small := append([]byte(nil), b...)
+	small[8], small[9], small[10], small[11] = 1, 0, 0, 0x80
+	if _, err := Load(small); !errors.As(err, &headerErr) {
+		t.Errorf("Expected HeaderError for an oversized payload, got %v", err)
+	}
+}
@@ -0,0 +1,428 @@
+// Package asm converts between RAGE script bytecode and the textual syntax
+// printed by opcode.Instruction.String.
+package asm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+// SyntaxError reports a source line that could not be assembled.
+type SyntaxError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %v: %q", e.Line, e.Err, e.Text)
+}
+
+func (e *SyntaxError) Unwrap() error { return e.Err }
+
+var opcodesByName = func() map[string]uint8 {
+	m := make(map[string]uint8, len(opcode.Names))
+	for k, v := range opcode.Names {
+		m[strings.ToLower(v)] = k
+	}
+	return m
+}()
+
+// LookupOpcode returns the opcode for a mnemonic, ignoring case.
+func LookupOpcode(name string) (uint8, bool) {
+	op, ok := opcodesByName[strings.ToLower(name)]
+	return op, ok
+}
+
+type pending struct {
+	ins    opcode.Instruction
+	labels []string
+	line   int
+	text   string
+}
+
+// Assemble parses source into instructions laid out from offset 0. Lines
+// hold one instruction each; "name:" defines a label, ";" starts a comment
+// and a leading 0xNNNN offset, as shown by the script view, is ignored.
+// Branch operands may name a label or give an absolute offset.
+func Assemble(src string) ([]opcode.Instruction, error) {
+	var items []pending
+	labels := make(map[string]int)
+	offset := 0
+
+	for i, raw := range strings.Split(src, "\n") {
+		lineNo := i + 1
+		text := stripComment(raw)
+		if text == "" {
+			continue
+		}
+		if strings.HasSuffix(text, ":") && !strings.ContainsAny(text, " \t\"") {
+			name := strings.TrimSuffix(text, ":")
+			if _, dup := labels[name]; dup {
+				return nil, &SyntaxError{Line: lineNo, Text: raw, Err: fmt.Errorf("label %s redefined", name)}
+			}
+			labels[name] = offset
+			continue
+		}
+
+		ins, refs, err := ParseInstruction(text, offset)
+		if err != nil {
+			return nil, &SyntaxError{Line: lineNo, Text: raw, Err: err}
+		}
+		items = append(items, pending{ins: ins, labels: refs, line: lineNo, text: raw})
+		offset += ins.GetLength()
+	}
+
+	byOffset := make(map[int]opcode.Instruction, len(items))
+	for _, it := range items {
+		byOffset[it.ins.GetOffset()] = it.ins
+	}
+
+	resolve := func(it pending, slot int, target uint32) (int, error) {
+		if slot >= len(it.labels) || it.labels[slot] == "" {
+			return int(target), nil
+		}
+		t, ok := labels[it.labels[slot]]
+		if !ok {
+			t, ok = labelOffset(it.labels[slot])
+		}
+		if !ok {
+			return 0, &SyntaxError{Line: it.line, Text: it.text, Err: fmt.Errorf("undefined label %s", it.labels[slot])}
+		}
+		return t, nil
+	}
+
+	result := make([]opcode.Instruction, 0, len(items))
+	for _, it := range items {
+		switch in := it.ins.(type) {
+		case *opcode.Branch:
+			target, err := resolve(it, 0, in.GetOperands()[0].(uint32))
+			if err != nil {
+				return nil, err
+			}
+			in.UpdateTargetOffset(target)
+			in.TargetInstruction = byOffset[target]
+		case *opcode.Switch:
+			for i, c := range in.Cases {
+				target, err := resolve(it, i, c.Target)
+				if err != nil {
+					return nil, err
+				}
+				in.UpdateCaseTarget(i, target)
+				in.Cases[i].TargetInstruction = byOffset[target]
+			}
+		}
+		result = append(result, it.ins)
+	}
+	return result, nil
+}
+
+// AssembleCode assembles source into raw code bytes.
+func AssembleCode(src string) ([]byte, error) {
+	ins, err := Assemble(src)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(ins), nil
+}
+
+// Encode concatenates the bytes of each instruction.
+func Encode(ins []opcode.Instruction) []byte {
+	var code []byte
+	for _, in := range ins {
+		code = append(code, in.GetOpcode())
+		code = append(code, in.GetArgs()...)
+	}
+	return code
+}
+
+// ParseInstruction parses a single instruction placed at offset. Branch and
+// switch targets that name a label are returned in target order, "" for
+// numeric targets, and left at zero for the caller to resolve.
+func ParseInstruction(text string, offset int) (opcode.Instruction, []string, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil, nil, fmt.Errorf("empty instruction")
+	}
+	if _, ok := LookupOpcode(fields[0]); !ok && len(fields) > 1 && strings.HasPrefix(fields[0], "0x") {
+		text = strings.TrimSpace(strings.TrimPrefix(text, fields[0]))
+		fields = fields[1:]
+	}
+
+	mnemonic := fields[0]
+	rest := strings.TrimSpace(strings.TrimPrefix(text, mnemonic))
+	ops := fields[1:]
+
+	if strings.EqualFold(mnemonic, opcode.Names[opcode.OP_PUSHD]) {
+		if len(ops) != 1 {
+			return nil, nil, fmt.Errorf("PushD takes 1 operand")
+		}
+		v, err := parseInt(ops[0], 16)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The value is encoded as opcode v+96, from 0x50 for -16 to 0xFF
+		// for 159.
+		if v < -16 || v > 159 {
+			return nil, nil, fmt.Errorf("PushD value %s out of range -16..159", ops[0])
+		}
+		return opcode.NewInstruction(offset, uint8(v+96), []byte{}), nil, nil
+	}
+
+	op, ok := LookupOpcode(mnemonic)
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown opcode %s", mnemonic)
+	}
+
+	var args []byte
+	var labels []string
+	switch op {
+	case opcode.OP_JUMP, opcode.OP_JUMP_FALSE, opcode.OP_JUMP_TRUE, opcode.OP_CALL:
+		if len(ops) != 1 {
+			return nil, nil, fmt.Errorf("%s takes 1 operand", mnemonic)
+		}
+		target, label := parseTarget(ops[0])
+		args = binary.LittleEndian.AppendUint32(nil, target)
+		labels = append(labels, label)
+	case opcode.OP_SWITCH:
+		if len(ops) > 0xFF {
+			return nil, nil, fmt.Errorf("switch has %d cases, at most 255 allowed", len(ops))
+		}
+		args = []byte{uint8(len(ops))}
+		for _, o := range ops {
+			value, t, ok := strings.Cut(o, ":")
+			if !ok {
+				return nil, nil, fmt.Errorf("expected VALUE:TARGET, got %s", o)
+			}
+			v, err := parseInt(value, 32)
+			if err != nil {
+				return nil, nil, err
+			}
+			target, label := parseTarget(t)
+			args = binary.LittleEndian.AppendUint32(args, uint32(v))
+			args = binary.LittleEndian.AppendUint32(args, target)
+			labels = append(labels, label)
+		}
+	case opcode.OP_PUSH:
+		if len(ops) != 1 {
+			return nil, nil, fmt.Errorf("%s takes 1 operand", mnemonic)
+		}
+		v, err := parseInt(ops[0], 32)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = binary.LittleEndian.AppendUint32(nil, uint32(v))
+	case opcode.OP_PUSHS:
+		if len(ops) != 1 {
+			return nil, nil, fmt.Errorf("%s takes 1 operand", mnemonic)
+		}
+		v, err := parseInt(ops[0], 16)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = binary.LittleEndian.AppendUint16(nil, uint16(v))
+	case opcode.OP_PUSHF:
+		if len(ops) != 1 {
+			return nil, nil, fmt.Errorf("%s takes 1 operand", mnemonic)
+		}
+		bits, err := parseFloatBits(ops[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		args = binary.LittleEndian.AppendUint32(nil, bits)
+	case opcode.OP_PUSH_STRING:
+		s, err := parseString(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(s) > 0xFF {
+			return nil, nil, fmt.Errorf("string of %d bytes is too long", len(s))
+		}
+		args = append([]byte{uint8(len(s))}, s...)
+	case opcode.OP_CALL_NATIVE:
+		a, err := parseNative(ops)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = a
+	default:
+		for _, o := range ops {
+			v, err := parseInt(o, 16)
+			if err != nil {
+				return nil, nil, err
+			}
+			if op == opcode.OP_FN_BEGIN && len(args) == 1 {
+				args = binary.LittleEndian.AppendUint16(args, uint16(v))
+				continue
+			}
+			if v < -128 || v > 0xFF {
+				return nil, nil, fmt.Errorf("operand %s does not fit in a byte", o)
+			}
+			args = append(args, uint8(v))
+		}
+	}
+
+	p1 := uint8(0)
+	if len(args) > 0 {
+		p1 = args[0]
+	}
+	if want := opcode.GetInstructionLength(op, p1) - 1; want != len(args) {
+		return nil, nil, fmt.Errorf("%s expects %d argument bytes, got %d", mnemonic, want, len(args))
+	}
+	return opcode.NewInstruction(offset, op, args), labels, nil
+}
+
+// parseTarget returns a numeric branch target, or the label to resolve.
+func parseTarget(s string) (uint32, string) {
+	if v, err := parseInt(s, 32); err == nil {
+		return uint32(v), ""
+	}
+	return 0, s
+}
+
+// parseNative parses "NAME in=N out=N". The counts may be left out for
+// natives with a known signature. Counts that are given are kept as
+// written, so existing scripts assemble back unchanged; VerifyStack reports
+// the ones that disagree with the signature.
+func parseNative(ops []string) ([]byte, error) {
+	counted := len(ops) >= 3 && strings.HasPrefix(ops[len(ops)-2], "in=") && strings.HasPrefix(ops[len(ops)-1], "out=")
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("CallNative expects NAME in=N out=N")
+	}
+	var hash uint32
+	nameParts := ops
+	if counted {
+		nameParts = ops[:len(ops)-2]
+	}
+	name := strings.Join(nameParts, " ")
+	if strings.HasPrefix(name, "Unknown (") && strings.HasSuffix(name, ")") {
+		v, err := strconv.ParseUint(name[len("Unknown ("):len(name)-1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid native hash in %s", name)
+		}
+		hash = uint32(v)
+	} else if h, ok := opcode.NativeHash(name); ok {
+		hash = h
+	} else if v, err := parseInt(name, 32); err == nil {
+		hash = uint32(v)
+	} else {
+		return nil, fmt.Errorf("unknown native %s", name)
+	}
+
+	if !counted {
+		n, ok := opcode.Natives.Lookup(hash)
+		if !ok || !n.Typed {
+			return nil, fmt.Errorf("CallNative %s needs in=N out=N, its signature is unknown", name)
+		}
+		args := []byte{uint8(n.In()), uint8(n.Out())}
+		return binary.LittleEndian.AppendUint32(args, hash), nil
+	}
+
+	in, err := parseKeyed(ops[len(ops)-2], "in")
+	if err != nil {
+		return nil, err
+	}
+	out, err := parseKeyed(ops[len(ops)-1], "out")
+	if err != nil {
+		return nil, err
+	}
+	args := []byte{in, out}
+	return binary.LittleEndian.AppendUint32(args, hash), nil
+}
+
+func parseKeyed(s, key string) (uint8, error) {
+	v, ok := strings.CutPrefix(s, key+"=")
+	if !ok {
+		return 0, fmt.Errorf("expected %s=N, got %s", key, s)
+	}
+	n, err := strconv.ParseUint(v, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s count %s", key, v)
+	}
+	return uint8(n), nil
+}
+
+// parseString reads a quoted PushString operand, appending the implied
+// terminator unless the literal is followed by noterm.
+func parseString(s string) ([]byte, error) {
+	lit, err := strconv.QuotedPrefix(s)
+	if err != nil {
+		return nil, fmt.Errorf("expected quoted string, got %s", s)
+	}
+	v, _ := strconv.Unquote(lit)
+	switch strings.TrimSpace(s[len(lit):]) {
+	case "":
+		return append([]byte(v), 0), nil
+	case "noterm":
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("unexpected text after string: %s", s[len(lit):])
+	}
+}
+
+// parseInt accepts decimal or 0x prefixed values, signed or unsigned, that
+// fit in bits.
+func parseInt(s string, bits int) (int64, error) {
+	if v, err := strconv.ParseInt(s, 0, bits); err == nil {
+		return v, nil
+	}
+	v, err := strconv.ParseUint(s, 0, bits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %d bit integer %s", bits, s)
+	}
+	return int64(v), nil
+}
+
+// parseFloatBits parses a PushF operand, either a decimal float or the raw
+// 0xNNNNNNNN bits opcode.FormatFloat writes for values that do not survive
+// a decimal round trip.
+func parseFloatBits(s string) (uint32, error) {
+	if raw, ok := strings.CutPrefix(strings.ToLower(s), "0x"); ok && !strings.Contains(raw, "p") {
+		v, err := strconv.ParseUint(raw, 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid float bits %s", s)
+		}
+		return uint32(v), nil
+	}
+	f, err := strconv.ParseFloat(s, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid float %s", s)
+	}
+	return math.Float32bits(float32(f)), nil
+}
+
+// labelOffset recovers the offset from a generated sub_0xNNNN or
+// loc_0xNNNN label that has no definition in the source.
+func labelOffset(label string) (int, bool) {
+	for _, prefix := range []string{"sub_", "loc_"} {
+		if rest, ok := strings.CutPrefix(label, prefix); ok {
+			v, err := strconv.ParseUint(rest, 0, 32)
+			return int(v), err == nil
+		}
+	}
+	return 0, false
+}
+
+func stripComment(line string) string {
+	inString := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if inString {
+				i++
+			}
+		case '"':
+			inString = !inString
+		case ';':
+			if !inString {
+				return strings.TrimSpace(line[:i])
+			}
+		}
+	}
+	return strings.TrimSpace(line)
+}
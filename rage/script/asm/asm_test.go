@@ -0,0 +1,148 @@
+package asm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/mrchip53/gta-tools/rage"
+	"github.com/mrchip53/gta-tools/rage/script"
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+func sampleCode() []byte {
+	var c []byte
+	c = append(c, opcode.OP_FN_BEGIN, 1, 2, 0)
+	c = append(c, opcode.OP_PUSH)
+	c = binary.LittleEndian.AppendUint32(c, 0xFFFFFFFE)
+	c = append(c, opcode.OP_PUSHS, 0x34, 0x12)
+	c = append(c, opcode.OP_PUSHF)
+	c = binary.LittleEndian.AppendUint32(c, math.Float32bits(1.5))
+	c = append(c, 0x50, 0x61, 0xFF)
+	c = append(c, opcode.OP_PUSH_STRING, 6, 'h', 'i', ' ', '"', ';', 0)
+	c = append(c, opcode.OP_JUMP_FALSE)
+	c = binary.LittleEndian.AppendUint32(c, 0x31)
+	c = append(c, opcode.OP_CALL_NATIVE, 1, 0)
+	c = binary.LittleEndian.AppendUint32(c, 912261746)
+	c = append(c, opcode.OP_CALL_NATIVE, 0, 1)
+	c = binary.LittleEndian.AppendUint32(c, 12345)
+	c = append(c, opcode.OP_STR_CPY, 16)
+	// 0x31
+	c = append(c, opcode.OP_ADD, opcode.OP_CALL)
+	c = binary.LittleEndian.AppendUint32(c, 0x00)
+	c = append(c, opcode.OP_JUMP)
+	c = binary.LittleEndian.AppendUint32(c, 0x1234)
+	c = append(c, opcode.OP_SWITCH, 2)
+	c = binary.LittleEndian.AppendUint32(c, 3)
+	c = binary.LittleEndian.AppendUint32(c, 0x31)
+	c = binary.LittleEndian.AppendUint32(c, 0xFFFFFFFF)
+	c = binary.LittleEndian.AppendUint32(c, 0x00)
+	c = append(c, opcode.OP_FN_END, 1, 0)
+	return c
+}
+
+func sampleScript(t *testing.T, code []byte) script.RageScript {
+	t.Helper()
+	return script.NewRageScript(rage.NewFileEntry("test.sco", script.PlainBytes(code, []uint{0}, nil)))
+}
+
+func TestDisassembleAssembleRoundTrip(t *testing.T) {
+	code := sampleCode()
+	rs := sampleScript(t, code)
+	src := Disassemble(rs.Opcodes)
+	if !strings.Contains(src, "Switch 3:loc_0x0031 -1:sub_0x0000") {
+		t.Errorf("Expected signed switch case values in:\n%s", src)
+	}
+
+	got, err := AssembleCode(src)
+	if err != nil {
+		t.Fatalf("Failed to assemble:\n%s\n%v", src, err)
+	}
+	if !bytes.Equal(got, code) {
+		t.Fatalf("Round trip mismatch:\n%s\nwant % X\ngot  % X", src, code, got)
+	}
+}
+
+func TestPushFKeepsSpecialValuesBitForBit(t *testing.T) {
+	for _, bits := range []uint32{0x7FC00001, 0xFFC00000, 0x7F800000, 0xFF800000, 0x80000000, math.Float32bits(-2.5)} {
+		in := opcode.NewInstruction(0, opcode.OP_PUSHF, binary.LittleEndian.AppendUint32(nil, bits))
+		src := FormatInstruction(in, nil)
+		got, _, err := ParseInstruction(src, 0)
+		if err != nil {
+			t.Errorf("0x%08X: failed to parse %q: %v", bits, src, err)
+			continue
+		}
+		if b := binary.LittleEndian.Uint32(got.GetArgs()); b != bits {
+			t.Errorf("0x%08X: %q assembled to 0x%08X", bits, src, b)
+		}
+	}
+	if _, _, err := ParseInstruction("PushF 0x1p-2", 0); err != nil {
+		t.Errorf("Expected hex float syntax to parse, got %v", err)
+	}
+}
+
+func TestPushDRejectsValuesOutsideItsOpcodes(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		want uint8
+	}{{"PushD -16", 0x50}, {"PushD -1", 0x5F}, {"PushD 0", 0x60}, {"PushD 159", 0xFF}} {
+		ins, _, err := ParseInstruction(tc.src, 0)
+		if err != nil || ins.GetOpcode() != tc.want {
+			t.Errorf("%s: expected opcode 0x%02X, got %v %v", tc.src, tc.want, ins, err)
+		}
+	}
+	for _, src := range []string{"PushD 200", "PushD -100", "PushD -17", "PushD 160", "PushD 255"} {
+		if _, _, err := ParseInstruction(src, 0); err == nil {
+			t.Errorf("%s: expected an out of range error", src)
+		}
+	}
+}
+
+func TestAssembleRelocatesLabels(t *testing.T) {
+	ins, err := Assemble(`
+main:
+	FnBegin 0 0
+	Jump end ; skip the push
+	Push 7
+end:
+	FnEnd 0 0
+`)
+	if err != nil {
+		t.Fatalf("Failed to assemble: %v", err)
+	}
+	if target := ins[1].GetOperands()[0].(uint32); target != 0x0E {
+		t.Errorf("Expected jump to 0x0E, got 0x%X", target)
+	}
+	if ins[1].(*opcode.Branch).TargetInstruction != ins[3] {
+		t.Errorf("Jump is not linked to its target instruction")
+	}
+
+	if _, err := Assemble("Jump nowhere"); err == nil {
+		t.Errorf("Expected an error for an undefined label")
+	}
+	if _, err := Assemble("CallNative NOT_A_NATIVE in=0 out=0"); err == nil {
+		t.Errorf("Expected an error for an unknown native")
+	}
+}
+
+func TestNativeCountsFollowSignatures(t *testing.T) {
+	ins, err := Assemble("CallNative WAIT")
+	if err != nil {
+		t.Fatalf("Failed to assemble: %v", err)
+	}
+	if args := ins[0].GetArgs(); args[0] != 1 || args[1] != 0 {
+		t.Errorf("Expected in=1 out=0 from the signature, got in=%d out=%d", args[0], args[1])
+	}
+	ins, err = Assemble("CallNative WAIT in=0 out=1")
+	if err != nil {
+		t.Fatalf("Expected counts that disagree with the signature to be kept, got %v", err)
+	}
+	if args := ins[0].GetArgs(); args[0] != 0 || args[1] != 1 {
+		t.Errorf("Expected in=0 out=1 as written, got in=%d out=%d", args[0], args[1])
+	}
+	if _, err := Assemble("CallNative LINE"); err == nil {
+		t.Errorf("Expected an error for a native without a signature and no counts")
+	}
+}
@@ -0,0 +1,61 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/x/ansi"
+
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+// Labels names every subroutine sub_0xNNNN and every other branch or switch
+// target that falls on an instruction loc_0xNNNN.
+func Labels(ins []opcode.Instruction) map[int]string {
+	labels := make(map[int]string)
+	starts := make(map[int]bool, len(ins))
+	for _, in := range ins {
+		starts[in.GetOffset()] = true
+		if in.GetOpcode() == opcode.OP_FN_BEGIN {
+			labels[in.GetOffset()] = fmt.Sprintf("sub_0x%04X", in.GetOffset())
+		}
+	}
+	name := func(target int) {
+		if _, named := labels[target]; !named && starts[target] {
+			labels[target] = fmt.Sprintf("loc_0x%04X", target)
+		}
+	}
+	for _, in := range ins {
+		switch in := in.(type) {
+		case *opcode.Branch:
+			name(int(in.GetOperands()[0].(uint32)))
+		case *opcode.Switch:
+			for _, c := range in.Cases {
+				name(int(c.Target))
+			}
+		}
+	}
+	return labels
+}
+
+// Disassemble renders instructions as source accepted by Assemble, with
+// label definitions before every branch target.
+func Disassemble(ins []opcode.Instruction) string {
+	labels := Labels(ins)
+	var sb strings.Builder
+	for _, in := range ins {
+		if l, ok := labels[in.GetOffset()]; ok {
+			if sb.Len() > 0 && in.GetOpcode() == opcode.OP_FN_BEGIN {
+				sb.WriteString("\n")
+			}
+			sb.WriteString(l + ":\n")
+		}
+		sb.WriteString("\t" + FormatInstruction(in, labels) + "\n")
+	}
+	return sb.String()
+}
+
+// FormatInstruction returns the plain text form of an instruction.
+func FormatInstruction(in opcode.Instruction, labels map[int]string) string {
+	return strings.TrimSpace(ansi.Strip(in.String("", labels)))
+}
@@ -0,0 +1,191 @@
+package script
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+// BasicBlock is a run of instructions with a single entry and exit. Start
+// and End index Opcodes, End exclusive. Successors list branch targets
+// first and the fall-through block last.
+type BasicBlock struct {
+	Index        int
+	Start        int
+	End          int
+	Instructions []opcode.Instruction
+	Successors   []*BasicBlock
+	Predecessors []*BasicBlock
+}
+
+func (b *BasicBlock) Offset() int { return b.Instructions[0].GetOffset() }
+
+func (b *BasicBlock) Last() opcode.Instruction { return b.Instructions[len(b.Instructions)-1] }
+
+func (b *BasicBlock) String() string { return fmt.Sprintf("block_0x%04X", b.Offset()) }
+
+// Function is the control-flow graph of one subroutine, the instructions
+// from a FnBegin up to the next one. Blocks are in code order and the first
+// is the entry.
+type Function struct {
+	Name   string
+	Offset int
+	Start  int
+	End    int
+	Blocks []*BasicBlock
+}
+
+func (f *Function) Entry() *BasicBlock { return f.Blocks[0] }
+
+// BlockAt returns the block holding the instruction at index, or nil.
+func (f *Function) BlockAt(index int) *BasicBlock {
+	i := sort.Search(len(f.Blocks), func(i int) bool { return f.Blocks[i].End > index })
+	if i < len(f.Blocks) && f.Blocks[i].Start <= index {
+		return f.Blocks[i]
+	}
+	return nil
+}
+
+// IsTerminator reports whether control never falls through ins to the
+// next instruction.
+func IsTerminator(ins opcode.Instruction) bool {
+	switch ins.GetOpcode() {
+	case opcode.OP_JUMP, opcode.OP_FN_END:
+		return true
+	}
+	return false
+}
+
+// BranchTargets returns the code offsets an instruction may jump to within
+// its function. Calls are not included.
+func BranchTargets(ins opcode.Instruction) []int {
+	switch ins := ins.(type) {
+	case *opcode.Branch:
+		if ins.GetOpcode() == opcode.OP_CALL {
+			return nil
+		}
+		return []int{int(ins.GetOperands()[0].(uint32))}
+	case *opcode.Switch:
+		targets := make([]int, len(ins.Cases))
+		for i, c := range ins.Cases {
+			targets[i] = int(c.Target)
+		}
+		return targets
+	}
+	return nil
+}
+
+// Functions splits the script into subroutines and builds the basic blocks
+// of each. Code before the first FnBegin is ignored.
+func (r *RageScript) Functions() []*Function {
+	var funcs []*Function
+	for i, ins := range r.Opcodes {
+		if ins.GetOpcode() != opcode.OP_FN_BEGIN {
+			continue
+		}
+		if n := len(funcs); n > 0 {
+			funcs[n-1].End = i
+		}
+		funcs = append(funcs, &Function{
+			Name:   r.Subroutines[ins.GetOffset()],
+			Offset: ins.GetOffset(),
+			Start:  i,
+			End:    len(r.Opcodes),
+		})
+	}
+	for _, f := range funcs {
+		r.buildBlocks(f)
+	}
+	return funcs
+}
+
+// FunctionAt returns the subroutine holding the instruction at index. Only
+// that subroutine's blocks are built.
+func (r *RageScript) FunctionAt(index int) *Function {
+	if index < 0 || index >= len(r.Opcodes) {
+		return nil
+	}
+	start := index
+	for start >= 0 && r.Opcodes[start].GetOpcode() != opcode.OP_FN_BEGIN {
+		start--
+	}
+	if start < 0 {
+		return nil
+	}
+	end := index + 1
+	for end < len(r.Opcodes) && r.Opcodes[end].GetOpcode() != opcode.OP_FN_BEGIN {
+		end++
+	}
+	f := &Function{
+		Name:   r.Subroutines[r.Opcodes[start].GetOffset()],
+		Offset: r.Opcodes[start].GetOffset(),
+		Start:  start,
+		End:    end,
+	}
+	r.buildBlocks(f)
+	return f
+}
+
+func (r *RageScript) buildBlocks(f *Function) {
+	indexOf := make(map[int]int, f.End-f.Start)
+	for i := f.Start; i < f.End; i++ {
+		indexOf[r.Opcodes[i].GetOffset()] = i
+	}
+
+	leaders := map[int]bool{f.Start: true}
+	for i := f.Start; i < f.End; i++ {
+		ins := r.Opcodes[i]
+		targets := BranchTargets(ins)
+		for _, t := range targets {
+			if idx, ok := indexOf[t]; ok {
+				leaders[idx] = true
+			}
+		}
+		if (targets != nil || IsTerminator(ins)) && i+1 < f.End {
+			leaders[i+1] = true
+		}
+	}
+
+	starts := make([]int, 0, len(leaders))
+	for idx := range leaders {
+		starts = append(starts, idx)
+	}
+	sort.Ints(starts)
+
+	byStart := make(map[int]*BasicBlock, len(starts))
+	for n, start := range starts {
+		end := f.End
+		if n+1 < len(starts) {
+			end = starts[n+1]
+		}
+		b := &BasicBlock{Index: n, Start: start, End: end, Instructions: r.Opcodes[start:end]}
+		f.Blocks = append(f.Blocks, b)
+		byStart[start] = b
+	}
+
+	link := func(from *BasicBlock, toIndex int) {
+		to, ok := byStart[toIndex]
+		if !ok {
+			return
+		}
+		for _, s := range from.Successors {
+			if s == to {
+				return
+			}
+		}
+		from.Successors = append(from.Successors, to)
+		to.Predecessors = append(to.Predecessors, from)
+	}
+	for _, b := range f.Blocks {
+		last := b.Last()
+		for _, t := range BranchTargets(last) {
+			if idx, ok := indexOf[t]; ok {
+				link(b, idx)
+			}
+		}
+		if !IsTerminator(last) {
+			link(b, b.End)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package script
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+func TestFunctionsBuildsBasicBlocks(t *testing.T) {
+	var code []byte
+	code = append(code, opcode.OP_FN_BEGIN, 0, 0, 0) // 0x00
+	code = append(code, 0x61)                        // 0x04
+	code = append(code, opcode.OP_JUMP_FALSE)        // 0x05
+	code = binary.LittleEndian.AppendUint32(code, 0x10)
+	code = append(code, 0x62, opcode.OP_JUMP) // 0x0A
+	code = binary.LittleEndian.AppendUint32(code, 0x11)
+	code = append(code, 0x63)                        // 0x10
+	code = append(code, opcode.OP_FN_END, 0, 0)      // 0x11
+	code = append(code, opcode.OP_FN_BEGIN, 0, 0, 0) // 0x14
+	code = append(code, opcode.OP_FN_END, 0, 0)      // 0x18
+
+	rs := newTestScript(t, code, nil, nil)
+	funcs := rs.Functions()
+	if len(funcs) != 2 {
+		t.Fatalf("Expected 2 functions, got %d", len(funcs))
+	}
+
+	f := funcs[0]
+	if len(f.Blocks) != 4 {
+		t.Fatalf("Expected 4 blocks, got %d", len(f.Blocks))
+	}
+	entry, then, els, join := f.Blocks[0], f.Blocks[1], f.Blocks[2], f.Blocks[3]
+	if len(entry.Successors) != 2 || entry.Successors[0] != els || entry.Successors[1] != then {
+		t.Errorf("Unexpected entry successors %v", entry.Successors)
+	}
+	if len(then.Successors) != 1 || then.Successors[0] != join {
+		t.Errorf("Unexpected then successors %v", then.Successors)
+	}
+	if len(join.Predecessors) != 2 || len(join.Successors) != 0 {
+		t.Errorf("Unexpected join edges %v %v", join.Predecessors, join.Successors)
+	}
+	if f.BlockAt(3) != then || rs.FunctionAt(8).Offset != 0x14 {
+		t.Errorf("Block or function lookup failed")
+	}
+	for _, want := range funcs {
+		for i := want.Start; i < want.End; i++ {
+			got := rs.FunctionAt(i)
+			if got == nil || got.Start != want.Start || got.End != want.End || len(got.Blocks) != len(want.Blocks) {
+				t.Errorf("FunctionAt(%d) = %+v, want %+v", i, got, want)
+			}
+		}
+	}
+	if rs.FunctionAt(len(rs.Opcodes)) != nil {
+		t.Errorf("Expected no function past the end")
+	}
+}
@@ -0,0 +1,68 @@
+package script
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+
+	"github.com/mrchip53/gta-tools/rage/util"
+)
+
+// decompressPayload decrypts and inflates the first CompressedSize bytes of
+// data, the body of a HEADER_MAGIC_ENCRYPTED_COMPRESSED script. The inflated
+// stream holds the code, locals and globals back to back, sized by the header.
+func decompressPayload(data []byte, h scriptHeader) (code, locals, globals []byte, err error) {
+	if h.CompressedSize < 0 || h.CodeSize < 0 || h.LocalVarCount < 0 || h.GlobalVarCount < 0 {
+		return nil, nil, nil, fmt.Errorf("invalid compressed script header %+v", h)
+	}
+	if int(h.CompressedSize) > len(data) {
+		return nil, nil, nil, fmt.Errorf("compressed size %d exceeds %d bytes of script data", h.CompressedSize, len(data))
+	}
+
+	ed := make([]byte, h.CompressedSize)
+	copy(ed, data)
+	if err = util.Decrypt(ed); err != nil {
+		return nil, nil, nil, err
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(ed))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("inflate script: %w", err)
+	}
+	defer zr.Close()
+
+	code = make([]byte, h.CodeSize)
+	locals = make([]byte, h.LocalVarCount*4)
+	globals = make([]byte, h.GlobalVarCount*4)
+	for _, b := range [][]byte{code, locals, globals} {
+		if _, err = io.ReadFull(zr, b); err != nil {
+			return nil, nil, nil, fmt.Errorf("inflate script: %w", err)
+		}
+	}
+	return code, locals, globals, nil
+}
+
+// compressPayload deflates code, locals and globals into a single zlib
+// stream and encrypts it, producing the body of a compressed script.
+func compressPayload(code, locals, globals []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range [][]byte{code, locals, globals} {
+		if _, err = zw.Write(b); err != nil {
+			return nil, err
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+
+	payload := buf.Bytes()
+	if err = util.Encrypt(payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
@@ -0,0 +1,367 @@
+// Package decompiler lifts RAGE script subroutines into structured
+// pseudo-code.
+package decompiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mrchip53/gta-tools/rage/script"
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+type loopCtx struct {
+	header int
+	exit   int
+}
+
+type structurer struct {
+	rs      *script.RageScript
+	fn      *script.Function
+	sigs    map[int]signature
+	params  int
+	blockOf map[int]int  // Code offset to block index
+	tails   map[int]int  // Loop header to the last block jumping back to it
+	labels  map[int]bool // Blocks that are goto targets
+	gotos   map[int]bool
+	temps   int
+	sb      strings.Builder
+	depth   int
+}
+
+// Decompile returns pseudo-code for fn, which must come from rs.Functions.
+func Decompile(rs *script.RageScript, fn *script.Function) string {
+	s := &structurer{
+		rs:      rs,
+		fn:      fn,
+		sigs:    signatures(rs),
+		blockOf: make(map[int]int, len(fn.Blocks)),
+		tails:   make(map[int]int),
+	}
+	if ops := rs.Opcodes[fn.Start].GetOperands(); len(ops) == 2 {
+		s.params = int(ops[0].(uint8))
+	}
+	for i, b := range fn.Blocks {
+		s.blockOf[b.Offset()] = i
+	}
+	for i, b := range fn.Blocks {
+		last := b.Last()
+		if last.GetOpcode() != opcode.OP_JUMP {
+			continue
+		}
+		if h, ok := s.blockOf[int(last.GetOperands()[0].(uint32))]; ok && h <= i {
+			s.tails[h] = max(s.tails[h], i)
+		}
+	}
+
+	// The first pass finds goto targets so the second can label them.
+	s.run()
+	s.labels = s.gotos
+	return s.run()
+}
+
+// DecompileAt decompiles the subroutine holding the instruction at index.
+func DecompileAt(rs *script.RageScript, index int) (string, error) {
+	fn := rs.FunctionAt(index)
+	if fn == nil {
+		return "", fmt.Errorf("instruction %d is not inside a subroutine", index)
+	}
+	return Decompile(rs, fn), nil
+}
+
+func (s *structurer) run() string {
+	s.sb.Reset()
+	s.gotos = make(map[int]bool)
+	s.temps = 0
+	s.depth = 0
+
+	sig := s.sigs[s.fn.Offset]
+	args := make([]string, sig.params)
+	for i := range args {
+		args[i] = fmt.Sprintf("arg%d", i)
+	}
+	s.line("%s(%s) {", s.fn.Name, strings.Join(args, ", "))
+	s.depth++
+	if sig.returns > 0 {
+		s.line("// returns %d value(s)", sig.returns)
+	}
+	s.region(0, len(s.fn.Blocks), -1, nil, -1)
+	s.depth--
+	s.line("}")
+	return s.sb.String()
+}
+
+func (s *structurer) line(format string, a ...any) {
+	s.sb.WriteString(strings.Repeat("    ", s.depth))
+	fmt.Fprintf(&s.sb, format, a...)
+	s.sb.WriteString("\n")
+}
+
+func (s *structurer) label(i int) string {
+	return s.fn.Blocks[i].String()
+}
+
+// lift runs the non-terminating instructions of block i and returns the
+// lifter holding their statements and stack together with the terminator,
+// if any.
+func (s *structurer) lift(i int) (*lifter, opcode.Instruction) {
+	if s.labels[i] {
+		s.depth--
+		s.line("%s:", s.label(i))
+		s.depth++
+	}
+	l := &lifter{rs: s.rs, sigs: s.sigs, params: s.params, temps: s.temps}
+	var term opcode.Instruction
+	for _, ins := range s.fn.Blocks[i].Instructions {
+		if !l.lift(ins) {
+			term = ins
+		}
+	}
+	s.temps = l.temps
+	return l, term
+}
+
+// statements prints what the lifter produced, spilling leftover stack
+// values first.
+func (s *structurer) statements(l *lifter) {
+	l.flush()
+	for _, st := range l.stmts {
+		s.line("%s", st)
+	}
+}
+
+func (s *structurer) target(offset int) int {
+	if i, ok := s.blockOf[offset]; ok {
+		return i
+	}
+	return -1
+}
+
+// jump prints the transfer of control to block t from inside [lo, hi).
+func (s *structurer) jump(t, follow int, loop *loopCtx, last bool) {
+	switch {
+	case t == follow:
+	case loop != nil && t == loop.header:
+		if !last {
+			s.line("continue;")
+		}
+	case loop != nil && t == loop.exit:
+		s.line("break;")
+	case t < 0:
+		s.line("goto ?;")
+	default:
+		s.gotos[t] = true
+		s.line("goto %s;", s.label(t))
+	}
+}
+
+// region prints blocks [lo, hi). Jumps to follow need no statement because
+// control reaches it anyway. skipLoop suppresses loop detection for a
+// header that is already being printed.
+func (s *structurer) region(lo, hi, follow int, loop *loopCtx, skipLoop int) {
+	for i := lo; i < hi; {
+		if tail, ok := s.tails[i]; ok && i != skipLoop && tail < hi {
+			s.loop(i, tail, loop)
+			i = tail + 1
+			continue
+		}
+
+		l, term := s.lift(i)
+		next := i + 1
+		atEnd := next == hi
+		if term == nil {
+			s.statements(l)
+			i = next
+			continue
+		}
+
+		switch term.GetOpcode() {
+		case opcode.OP_FN_END:
+			ops := term.GetOperands()
+			n := 0
+			if len(ops) == 2 {
+				n = int(ops[1].(uint8))
+			}
+			vals := l.popN(n)
+			s.statements(l)
+			switch {
+			case n == 1:
+				s.line("return %s;", vals[0])
+			case n > 1:
+				strs := make([]string, n)
+				for k, v := range vals {
+					strs[k] = v.String()
+				}
+				s.line("return %s;", strings.Join(strs, ", "))
+			case i != len(s.fn.Blocks)-1:
+				s.line("return;")
+			}
+			i = next
+
+		case opcode.OP_JUMP:
+			s.statements(l)
+			s.jump(s.target(int(term.GetOperands()[0].(uint32))), follow, loop, atEnd && loop != nil && hi == loop.exit)
+			i = next
+
+		case opcode.OP_JUMP_FALSE, opcode.OP_JUMP_TRUE:
+			cond := l.pop()
+			s.statements(l)
+			if term.GetOpcode() == opcode.OP_JUMP_TRUE {
+				cond = negate(cond)
+			}
+			t := s.target(int(term.GetOperands()[0].(uint32)))
+			switch {
+			case loop != nil && t == loop.exit:
+				s.line("if (%s) {", negate(cond))
+				s.depth++
+				s.line("break;")
+				s.depth--
+				s.line("}")
+				i = next
+			case t > i && t <= hi:
+				i = s.ifElse(cond, i, t, hi, follow, loop)
+			default:
+				s.line("if (%s) {", negate(cond))
+				s.depth++
+				s.jump(t, -1, loop, false)
+				s.depth--
+				s.line("}")
+				i = next
+			}
+
+		case opcode.OP_SWITCH:
+			value := l.pop()
+			s.statements(l)
+			i = s.switchCase(term.(*opcode.Switch), value, i, hi, loop)
+		}
+	}
+}
+
+func (s *structurer) ifElse(cond expr, i, t, hi, follow int, loop *loopCtx) int {
+	end := t
+	if t-1 > i {
+		last := s.fn.Blocks[t-1].Last()
+		if last.GetOpcode() == opcode.OP_JUMP {
+			if j := s.target(int(last.GetOperands()[0].(uint32))); j > t && j <= hi {
+				end = j
+			}
+		}
+	}
+
+	s.line("if (%s) {", cond)
+	s.depth++
+	s.region(i+1, t, end, loop, -1)
+	s.depth--
+	if end > t {
+		s.line("} else {")
+		s.depth++
+		s.region(t, end, end, loop, -1)
+		s.depth--
+	}
+	s.line("}")
+	return end
+}
+
+func (s *structurer) loop(h, tail int, outer *loopCtx) {
+	ctx := &loopCtx{header: h, exit: tail + 1}
+	hb := s.fn.Blocks[h]
+	term := hb.Last()
+	if (term.GetOpcode() == opcode.OP_JUMP_FALSE || term.GetOpcode() == opcode.OP_JUMP_TRUE) &&
+		s.target(int(term.GetOperands()[0].(uint32))) == ctx.exit && !s.labels[h] {
+		l := &lifter{rs: s.rs, sigs: s.sigs, params: s.params, temps: s.temps}
+		for _, ins := range hb.Instructions {
+			l.lift(ins)
+		}
+		if len(l.stmts) == 0 && len(l.stack) == 1 {
+			s.temps = l.temps
+			cond := l.pop()
+			if term.GetOpcode() == opcode.OP_JUMP_TRUE {
+				cond = negate(cond)
+			}
+			s.line("while (%s) {", cond)
+			s.depth++
+			s.region(h+1, ctx.exit, -1, ctx, -1)
+			s.depth--
+			s.line("}")
+			return
+		}
+	}
+
+	s.line("while (true) {")
+	s.depth++
+	s.region(h, ctx.exit, -1, ctx, h)
+	s.depth--
+	s.line("}")
+}
+
+func (s *structurer) switchCase(sw *opcode.Switch, value expr, i, hi int, loop *loopCtx) int {
+	byTarget := make(map[int][]int32)
+	var starts []int
+	for _, c := range sw.Cases {
+		t := s.target(int(c.Target))
+		if t <= i || t >= hi {
+			continue
+		}
+		if _, seen := byTarget[t]; !seen {
+			starts = append(starts, t)
+		}
+		byTarget[t] = append(byTarget[t], c.Value)
+	}
+	sort.Ints(starts)
+
+	exit := hi
+	if len(starts) > 0 {
+		maxCase := starts[len(starts)-1]
+		for b := i + 1; b < hi; b++ {
+			last := s.fn.Blocks[b].Last()
+			if last.GetOpcode() != opcode.OP_JUMP {
+				continue
+			}
+			if j := s.target(int(last.GetOperands()[0].(uint32))); j > maxCase && j <= hi {
+				exit = j
+				break
+			}
+		}
+	}
+
+	s.line("switch (%s) {", value)
+	for n, start := range starts {
+		end := exit
+		if n+1 < len(starts) {
+			end = starts[n+1]
+		}
+		for _, v := range byTarget[start] {
+			s.line("case %d:", v)
+		}
+		s.depth++
+		s.region(start, end, exit, loop, -1)
+		s.line("break;")
+		s.depth--
+	}
+	if len(starts) > 0 && i+1 < starts[0] {
+		mark := s.sb.Len()
+		s.line("default:")
+		s.depth++
+		body := s.sb.Len()
+		s.region(i+1, starts[0], exit, loop, -1)
+		if s.sb.Len() == body {
+			s.depth--
+			truncate(&s.sb, mark)
+		} else {
+			s.line("break;")
+			s.depth--
+		}
+	}
+	s.line("}")
+	if len(starts) == 0 {
+		return i + 1
+	}
+	return exit
+}
+
+func truncate(sb *strings.Builder, n int) {
+	str := sb.String()[:n]
+	sb.Reset()
+	sb.WriteString(str)
+}
@@ -0,0 +1,101 @@
+package decompiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mrchip53/gta-tools/rage"
+	"github.com/mrchip53/gta-tools/rage/script"
+	"github.com/mrchip53/gta-tools/rage/script/asm"
+)
+
+func assemble(t *testing.T, src string) script.RageScript {
+	t.Helper()
+	code, err := asm.AssembleCode(src)
+	if err != nil {
+		t.Fatalf("Failed to assemble: %v", err)
+	}
+	return script.NewRageScript(rage.NewFileEntry("test.sco", script.PlainBytes(code, nil, nil)))
+}
+
+const source = `
+	FnBegin 1 4
+	PushD 0
+	Var3
+	RefSet
+loop:
+	Var3
+	RefGet
+	Var0
+	RefGet
+	CmpLt
+	JumpFalse done
+	Var3
+	RefGet
+	PushD 2
+	Mod
+	Switch 0:even 1:odd
+	Jump next
+even:
+	Var3
+	RefGet
+	CallNative WAIT in=1 out=0
+	Jump next
+odd:
+	PushD 1
+	LocalVar
+	RefGet
+	JumpFalse skip
+	PushString "odd"
+	PushD 4
+	GlobalVar
+	StrCpy 16
+	Jump next
+skip:
+	PushD 0
+	PushD 1
+	LocalVar
+	RefSet
+next:
+	Var3
+	RefGet
+	PushD 1
+	Add
+	Var3
+	RefSet
+	Jump loop
+done:
+	Var3
+	RefGet
+	FnEnd 1 1
+`
+
+func TestDecompileStructuresControlFlow(t *testing.T) {
+	rs := assemble(t, source)
+	out, err := DecompileAt(&rs, 0)
+	if err != nil {
+		t.Fatalf("Failed to decompile: %v", err)
+	}
+
+	for _, want := range []string{
+		"sub_0x0000(arg0) {",
+		"var3 = 0;",
+		"while (var3 < arg0) {",
+		"switch (var3 % 2) {",
+		"case 0:",
+		"WAIT(ms: var3);",
+		"if (static1) {",
+		`StrCpy(global4, "odd", 16);`,
+		"} else {",
+		"static1 = 0;",
+		"var3 = var3 + 1;",
+		"return var3;",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "goto") {
+		t.Errorf("Unexpected goto in:\n%s", out)
+	}
+}
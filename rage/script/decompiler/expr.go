@@ -0,0 +1,80 @@
+package decompiler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// expr is a lifted stack value. Values that are the address of a named
+// variable keep the name in addr so loads and stores read naturally.
+type expr struct {
+	text   string
+	addr   string
+	atomic bool
+}
+
+func atom(format string, a ...any) expr {
+	return expr{text: fmt.Sprintf(format, a...), atomic: true}
+}
+
+func addrOf(name string) expr {
+	return expr{addr: name, atomic: true}
+}
+
+func (e expr) String() string {
+	if e.addr != "" {
+		return "&" + e.addr
+	}
+	return e.text
+}
+
+func (e expr) paren() string {
+	if e.atomic {
+		return e.String()
+	}
+	return "(" + e.String() + ")"
+}
+
+// deref reads through an address.
+func (e expr) deref() expr {
+	if e.addr != "" {
+		return atom("%s", e.addr)
+	}
+	return atom("*%s", e.paren())
+}
+
+// lvalue names the location an address points at.
+func (e expr) lvalue() string {
+	if e.addr != "" {
+		return e.addr
+	}
+	return "*" + e.paren()
+}
+
+func binop(a expr, op string, b expr) expr {
+	return expr{text: a.paren() + " " + op + " " + b.paren()}
+}
+
+func unary(op string, a expr) expr {
+	return expr{text: op + a.paren()}
+}
+
+func call(name string, args []expr) expr {
+	strs := make([]string, len(args))
+	for i, a := range args {
+		strs[i] = a.String()
+	}
+	return atom("%s(%s)", name, strings.Join(strs, ", "))
+}
+
+// negate returns the logical inverse of a condition, folding a leading !.
+func negate(e expr) expr {
+	if strings.HasPrefix(e.text, "!") && e.addr == "" {
+		inner := e.text[1:]
+		if strings.HasPrefix(inner, "(") && strings.HasSuffix(inner, ")") {
+			return expr{text: inner[1 : len(inner)-1]}
+		}
+		return atom("%s", inner)
+	}
+	return unary("!", e)
+}
@@ -0,0 +1,293 @@
+package decompiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/mrchip53/gta-tools/rage/script"
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+// signature is the parameter and return count of a subroutine.
+type signature struct {
+	params  int
+	returns int
+}
+
+// signatures reads every FnBegin and FnEnd to learn how many values each
+// subroutine pops and pushes.
+func signatures(rs *script.RageScript) map[int]signature {
+	sigs := make(map[int]signature)
+	cur := -1
+	for _, ins := range rs.Opcodes {
+		switch ins.GetOpcode() {
+		case opcode.OP_FN_BEGIN:
+			cur = ins.GetOffset()
+			if ops := ins.GetOperands(); len(ops) == 2 {
+				sigs[cur] = signature{params: int(ops[0].(uint8))}
+			}
+		case opcode.OP_FN_END:
+			if ops := ins.GetOperands(); cur >= 0 && len(ops) == 2 {
+				s := sigs[cur]
+				s.returns = int(ops[1].(uint8))
+				sigs[cur] = s
+			}
+		}
+	}
+	return sigs
+}
+
+// lifter turns the instructions of a block into statements, leaving the
+// condition or switch value of the terminator on the stack.
+type lifter struct {
+	rs     *script.RageScript
+	sigs   map[int]signature
+	params int
+	stack  []expr
+	stmts  []string
+	temps  int
+}
+
+func (l *lifter) push(e expr) { l.stack = append(l.stack, e) }
+
+func (l *lifter) pop() expr {
+	if len(l.stack) == 0 {
+		return atom("stack_underflow")
+	}
+	e := l.stack[len(l.stack)-1]
+	l.stack = l.stack[:len(l.stack)-1]
+	return e
+}
+
+func (l *lifter) popN(n int) []expr {
+	args := make([]expr, n)
+	for i := n - 1; i >= 0; i-- {
+		args[i] = l.pop()
+	}
+	return args
+}
+
+func (l *lifter) emit(format string, a ...any) {
+	l.stmts = append(l.stmts, fmt.Sprintf(format, a...))
+}
+
+// flush spills values left on the stack so no work is lost at a block
+// boundary.
+func (l *lifter) flush() {
+	for _, e := range l.stack {
+		l.emit("push(%s);", e)
+	}
+	l.stack = l.stack[:0]
+}
+
+func (l *lifter) frameVar(slot int) string {
+	if slot < l.params {
+		return fmt.Sprintf("arg%d", slot)
+	}
+	return fmt.Sprintf("var%d", slot)
+}
+
+// results pushes the values returned by a call, or emits it as a statement
+// when it returns nothing.
+func (l *lifter) results(c expr, n int) {
+	switch n {
+	case 0:
+		l.emit("%s;", c)
+	case 1:
+		l.push(c)
+	default:
+		names := make([]expr, n)
+		for i := range names {
+			names[i] = atom("tmp%d", l.temps)
+			l.temps++
+		}
+		first := names[0].text
+		l.emit("%s..%s = %s;", first, names[n-1].text, c)
+		for _, e := range names {
+			l.push(e)
+		}
+	}
+}
+
+func constIndex(e expr) (int, bool) {
+	v, err := strconv.Atoi(e.text)
+	return v, err == nil && e.addr == ""
+}
+
+func (l *lifter) indexed(prefix string, idx expr) expr {
+	if v, ok := constIndex(idx); ok {
+		return addrOf(fmt.Sprintf("%s%d", prefix, v))
+	}
+	return addrOf(fmt.Sprintf("%ss[%s]", prefix, idx))
+}
+
+var binaryOps = map[uint8]string{
+	opcode.OP_ADD: "+", opcode.OP_SUB: "-", opcode.OP_MUL: "*", opcode.OP_DIV: "/", opcode.OP_MOD: "%",
+	opcode.OP_CMP_EQ: "==", opcode.OP_CMP_NE: "!=", opcode.OP_CMP_GT: ">", opcode.OP_CMP_GE: ">=",
+	opcode.OP_CMP_LT: "<", opcode.OP_CMP_LE: "<=",
+	opcode.OP_ADDF: "+", opcode.OP_SUBF: "-", opcode.OP_MULF: "*", opcode.OP_DIVF: "/", opcode.OP_MODF: "%",
+	opcode.OP_CMP_EQF: "==", opcode.OP_CMP_NEF: "!=", opcode.OP_CMP_GTF: ">", opcode.OP_CMP_GEF: ">=",
+	opcode.OP_CMP_LTF: "<", opcode.OP_CMP_LEF: "<=",
+	opcode.OP_AND: "&", opcode.OP_OR: "|", opcode.OP_XOR: "^",
+}
+
+var vectorOps = map[uint8]string{
+	opcode.OP_ADD_VEC: "+", opcode.OP_SUB_VEC: "-", opcode.OP_MUL_VEC: "*", opcode.OP_DIV_VEC: "/",
+}
+
+// lift executes one instruction symbolically. It returns false for
+// terminators, which the structurer handles.
+func (l *lifter) lift(ins opcode.Instruction) bool {
+	op := ins.GetOpcode()
+	ops := ins.GetOperands()
+
+	if sym, ok := binaryOps[op]; ok {
+		b, a := l.pop(), l.pop()
+		l.push(binop(a, sym, b))
+		return true
+	}
+	if sym, ok := vectorOps[op]; ok {
+		b := l.popN(3)
+		a := l.popN(3)
+		for i := range 3 {
+			l.push(binop(a[i], sym, b[i]))
+		}
+		return true
+	}
+	if op > 0x4F {
+		l.push(atom("%d", int(op)-96))
+		return true
+	}
+
+	switch op {
+	case opcode.OP_IS_ZERO:
+		l.push(negate(l.pop()))
+	case opcode.OP_NEG, opcode.OP_NEGF:
+		l.push(unary("-", l.pop()))
+	case opcode.OP_NEG_VEC:
+		v := l.popN(3)
+		for _, e := range v {
+			l.push(unary("-", e))
+		}
+	case opcode.OP_TO_F:
+		l.push(call("float", []expr{l.pop()}))
+	case opcode.OP_FROM_F:
+		l.push(call("int", []expr{l.pop()}))
+	case opcode.OP_VEC_FROM_F:
+		v := l.pop()
+		l.push(v)
+		l.push(v)
+		l.push(v)
+	case opcode.OP_PUSH:
+		l.push(atom("%d", int32(ops[0].(uint32))))
+	case opcode.OP_PUSHS:
+		l.push(atom("%d", int16(ops[0].(uint16))))
+	case opcode.OP_PUSHF:
+		l.push(atom("%sf", strconv.FormatFloat(float64(ops[0].(float32)), 'g', -1, 32)))
+	case opcode.OP_PUSH_STRING:
+		l.push(atom("%s", opcode.QuoteString(ops[0].(string))))
+	case opcode.OP_NULL_OBJ:
+		l.push(atom("null"))
+	case opcode.OP_DUP:
+		v := l.pop()
+		l.push(v)
+		l.push(v)
+	case opcode.OP_POP:
+		l.emit("%s;", l.pop())
+	case opcode.OP_CALL_NATIVE:
+		name, in, out := ops[0].(string), int(ops[1].(uint8)), int(ops[2].(uint8))
+		hash := binary.LittleEndian.Uint32(ins.GetArgs()[2:6])
+		n, known := opcode.Natives.Lookup(hash)
+		if !known {
+			name = fmt.Sprintf("NATIVE_0x%08X", hash)
+		}
+		args := l.popN(in)
+		if n.Typed && len(n.Params) == in {
+			for i, p := range n.Params {
+				args[i] = atom("%s: %s", p.Name, args[i])
+			}
+		}
+		l.results(call(name, args), out)
+	case opcode.OP_CALL:
+		target := int(ops[0].(uint32))
+		sig := l.sigs[target]
+		name := l.rs.Subroutines[target]
+		if name == "" {
+			name = fmt.Sprintf("sub_0x%04X", target)
+		}
+		l.results(call(name, l.popN(sig.params)), sig.returns)
+	case opcode.OP_REF_GET:
+		l.push(l.pop().deref())
+	case opcode.OP_REF_SET:
+		ptr := l.pop()
+		v := l.pop()
+		l.emit("%s = %s;", ptr.lvalue(), v)
+	case opcode.OP_REF_PEEK_SET:
+		ptr := l.pop()
+		v := l.pop()
+		l.emit("%s = %s;", ptr.lvalue(), v)
+		l.push(ptr.deref())
+	case opcode.OP_ARRAY_EXPLODE:
+		ptr := l.pop()
+		n := l.pop()
+		if c, ok := constIndex(n); ok {
+			for i := range c {
+				l.push(atom("%s[%d]", ptr.lvalue(), i))
+			}
+		} else {
+			l.emit("explode(%s, %s);", ptr, n)
+		}
+	case opcode.OP_ARRAY_IMPLODE:
+		ptr := l.pop()
+		n := l.pop()
+		if c, ok := constIndex(n); ok {
+			vals := l.popN(c)
+			for i, v := range vals {
+				l.emit("%s[%d] = %s;", ptr.lvalue(), i, v)
+			}
+		} else {
+			l.emit("implode(%s, %s);", ptr, n)
+		}
+	case opcode.OP_VAR0, opcode.OP_VAR1, opcode.OP_VAR2, opcode.OP_VAR3,
+		opcode.OP_VAR4, opcode.OP_VAR5, opcode.OP_VAR6, opcode.OP_VAR7:
+		l.push(addrOf(l.frameVar(int(op - opcode.OP_VAR0))))
+	case opcode.OP_VAR:
+		idx := l.pop()
+		if v, ok := constIndex(idx); ok {
+			l.push(addrOf(l.frameVar(v)))
+		} else {
+			l.push(addrOf(fmt.Sprintf("frame[%s]", idx)))
+		}
+	case opcode.OP_LOCAL_VAR:
+		l.push(l.indexed("static", l.pop()))
+	case opcode.OP_GLOBAL_VAR:
+		l.push(l.indexed("global", l.pop()))
+	case opcode.OP_ARRAY_REF:
+		size := l.pop()
+		idx := l.pop()
+		ptr := l.pop()
+		elem := ptr.lvalue() + "[" + idx.String() + "]"
+		if s, ok := constIndex(size); !ok || s != 1 {
+			elem += fmt.Sprintf(" /* size %s */", size)
+		}
+		l.push(addrOf(elem))
+	case opcode.OP_STR_CPY, opcode.OP_INT_TO_STR, opcode.OP_STR_CAT, opcode.OP_STR_CAT_I:
+		dst := l.pop()
+		src := l.pop()
+		l.emit("%s(%s, %s, %d);", opcode.Names[op], dst.lvalue(), src, ops[0].(uint8))
+	case opcode.OP_STR_VAR_CPY:
+		args := l.popN(3)
+		l.emit("%s;", call(opcode.Names[op], args))
+	case opcode.OP_CATCH:
+		l.push(atom("exception"))
+	case opcode.OP_THROW:
+		l.emit("throw %s;", l.pop())
+	case opcode.OP_FN_BEGIN:
+	case opcode.OP_JUMP, opcode.OP_JUMP_FALSE, opcode.OP_JUMP_TRUE, opcode.OP_SWITCH, opcode.OP_FN_END:
+		return false
+	default:
+		l.emit("%s;", call(opcode.Names[op], nil))
+	}
+	return true
+}
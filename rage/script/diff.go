@@ -0,0 +1,565 @@
+package script
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+// Function statuses reported by Diff.
+const (
+	DIFF_ADDED   = "added"
+	DIFF_REMOVED = "removed"
+	DIFF_CHANGED = "changed"
+)
+
+// Line operations in a FunctionDiff.
+const (
+	LINE_EQUAL  = "equal"
+	LINE_INSERT = "insert"
+	LINE_REMOVE = "remove"
+	LINE_MODIFY = "modify"
+)
+
+// maxEditDistance bounds the alignment work per subroutine. Subroutines
+// that differ by more edits are shown as removed and inserted in full.
+const maxEditDistance = 4096
+
+// HeaderChange is a script header field that differs.
+type HeaderChange struct {
+	Field string `json:"field"`
+	Old   int64  `json:"old"`
+	New   int64  `json:"new"`
+}
+
+// StaticChange is a local or global static slot that differs. Old or New
+// is nil when the slot only exists in one version.
+type StaticChange struct {
+	Global bool  `json:"global"`
+	Index  int   `json:"index"`
+	Old    *uint `json:"old,omitempty"`
+	New    *uint `json:"new,omitempty"`
+}
+
+// DiffLine pairs an instruction of the old script with one of the new.
+// Old and New index Opcodes and are -1 on the side the line is missing
+// from. A modified line has the same opcode with different operands.
+type DiffLine struct {
+	Op  string `json:"op"`
+	Old int    `json:"old"`
+	New int    `json:"new"`
+}
+
+// FunctionDiff is a subroutine that was added, removed or changed.
+// Subroutines are matched by aligning the sequences of both versions, so
+// one inserted in the middle only shows up as added. Index numbers the
+// subroutine in the new script, or in the old one if it was removed. Lines
+// aligns every instruction of both versions, unchanged ones included.
+type FunctionDiff struct {
+	Index   int        `json:"index"`
+	Status  string     `json:"status"`
+	OldName string     `json:"oldName,omitempty"`
+	NewName string     `json:"newName,omitempty"`
+	OldLen  int        `json:"oldLen"`
+	NewLen  int        `json:"newLen"`
+	Lines   []DiffLine `json:"lines"`
+}
+
+// Inserted, Removed and Modified count the lines of each kind.
+func (f FunctionDiff) Inserted() int { return f.count(LINE_INSERT) }
+func (f FunctionDiff) Removed() int  { return f.count(LINE_REMOVE) }
+func (f FunctionDiff) Modified() int { return f.count(LINE_MODIFY) }
+
+func (f FunctionDiff) count(op string) int {
+	n := 0
+	for _, l := range f.Lines {
+		if l.Op == op {
+			n++
+		}
+	}
+	return n
+}
+
+// ScriptDiff is the difference between two versions of a script.
+type ScriptDiff struct {
+	Header    []HeaderChange `json:"header"`
+	Statics   []StaticChange `json:"statics"`
+	Functions []FunctionDiff `json:"functions"`
+}
+
+// Empty reports whether the scripts behave the same.
+func (d ScriptDiff) Empty() bool {
+	return len(d.Header) == 0 && len(d.Statics) == 0 && len(d.Functions) == 0
+}
+
+// Diff compares two versions of a script. Code is compared per subroutine
+// with branch, switch and call targets resolved to the instruction or
+// subroutine they point at, so code that only moved compares equal.
+func Diff(old, new *RageScript) ScriptDiff {
+	d := ScriptDiff{
+		Header:    diffHeader(old.Header, new.Header),
+		Statics:   append(diffStatics(old.Locals, new.Locals, false), diffStatics(old.Globals, new.Globals, true)...),
+		Functions: []FunctionDiff{},
+	}
+
+	// Subroutines are aligned with calls keyed without their callee, then
+	// calls are keyed by the callee's subroutine in the new script.
+	oldFuncs, newFuncs := old.Functions(), new.Functions()
+	anyCallee := func(int) string { return "fn" }
+	pairs := alignFunctions(old.instructionKeys(oldFuncs, anyCallee), new.instructionKeys(newFuncs, anyCallee), oldFuncs, newFuncs)
+	toNew := make(map[int]int, len(pairs))
+	for _, p := range pairs {
+		if p.old != -1 && p.new != -1 {
+			toNew[p.old] = p.new
+		}
+	}
+	oldKeys := old.instructionKeys(oldFuncs, func(f int) string {
+		if n, ok := toNew[f]; ok {
+			return fmt.Sprintf("fn%d", n)
+		}
+		return fmt.Sprintf("old fn%d", f)
+	})
+	newKeys := new.instructionKeys(newFuncs, func(f int) string { return fmt.Sprintf("fn%d", f) })
+
+	for _, p := range pairs {
+		fd := FunctionDiff{Index: p.new, Status: DIFF_CHANGED, Lines: []DiffLine{}}
+		switch {
+		case p.old == -1:
+			fd.Status = DIFF_ADDED
+		case p.new == -1:
+			fd.Index, fd.Status = p.old, DIFF_REMOVED
+		}
+		var a, b []string
+		oldStart, newStart := 0, 0
+		if p.old != -1 {
+			f := oldFuncs[p.old]
+			fd.OldName, fd.OldLen = f.Name, f.End-f.Start
+			a, oldStart = oldKeys[f.Start:f.End], f.Start
+		}
+		if p.new != -1 {
+			f := newFuncs[p.new]
+			fd.NewName, fd.NewLen = f.Name, f.End-f.Start
+			b, newStart = newKeys[f.Start:f.End], f.Start
+		}
+		if fd.Status == DIFF_CHANGED && equalKeys(a, b) {
+			continue
+		}
+		for _, l := range alignLines(a, b, old.Opcodes[oldStart:oldStart+len(a)], new.Opcodes[newStart:newStart+len(b)]) {
+			if l.Old != -1 {
+				l.Old += oldStart
+			}
+			if l.New != -1 {
+				l.New += newStart
+			}
+			fd.Lines = append(fd.Lines, l)
+		}
+		d.Functions = append(d.Functions, fd)
+	}
+	return d
+}
+
+// funcPair matches a subroutine of the old script with one of the new.
+// old or new is -1 for a subroutine only in the other script.
+type funcPair struct {
+	old, new int
+}
+
+// alignFunctions aligns the subroutines of two scripts by a hash of their
+// instruction keys. Within each run of changes, removed and inserted
+// subroutines are paired up in order as changed.
+func alignFunctions(oldKeys, newKeys []string, oldFuncs, newFuncs []*Function) []funcPair {
+	hashes := func(keys []string, funcs []*Function) []string {
+		h := make([]string, len(funcs))
+		for i, f := range funcs {
+			s := fnv.New64a()
+			for _, k := range keys[f.Start:f.End] {
+				s.Write([]byte(k + "\n"))
+			}
+			h[i] = fmt.Sprintf("%016X", s.Sum64())
+		}
+		return h
+	}
+
+	var pairs []funcPair
+	var removed, inserted []int
+	flush := func() {
+		n := min(len(removed), len(inserted))
+		for i := range n {
+			pairs = append(pairs, funcPair{removed[i], inserted[i]})
+		}
+		for _, i := range removed[n:] {
+			pairs = append(pairs, funcPair{i, -1})
+		}
+		for _, j := range inserted[n:] {
+			pairs = append(pairs, funcPair{-1, j})
+		}
+		removed, inserted = nil, nil
+	}
+
+	for _, l := range editScript(hashes(oldKeys, oldFuncs), hashes(newKeys, newFuncs)) {
+		switch l.Op {
+		case LINE_REMOVE:
+			removed = append(removed, l.Old)
+		case LINE_INSERT:
+			inserted = append(inserted, l.New)
+		default:
+			flush()
+			pairs = append(pairs, funcPair{l.Old, l.New})
+		}
+	}
+	flush()
+	return pairs
+}
+
+func diffHeader(old, new scriptHeader) []HeaderChange {
+	fields := []struct {
+		name     string
+		old, new int64
+	}{
+		{"Identifier", int64(old.Identifier), int64(new.Identifier)},
+		{"CodeSize", int64(old.CodeSize), int64(new.CodeSize)},
+		{"LocalVarCount", int64(old.LocalVarCount), int64(new.LocalVarCount)},
+		{"GlobalVarCount", int64(old.GlobalVarCount), int64(new.GlobalVarCount)},
+		{"ScriptFlags", int64(old.ScriptFlags), int64(new.ScriptFlags)},
+		{"GlobalsSignature", int64(old.GlobalsSignature), int64(new.GlobalsSignature)},
+	}
+	changes := []HeaderChange{}
+	for _, f := range fields {
+		if f.old != f.new {
+			changes = append(changes, HeaderChange{Field: f.name, Old: f.old, New: f.new})
+		}
+	}
+	return changes
+}
+
+func diffStatics(old, new []uint, global bool) []StaticChange {
+	changes := []StaticChange{}
+	for i := range max(len(old), len(new)) {
+		c := StaticChange{Global: global, Index: i}
+		if i < len(old) {
+			c.Old = &old[i]
+		}
+		if i < len(new) {
+			c.New = &new[i]
+		}
+		if c.Old != nil && c.New != nil && *c.Old == *c.New {
+			continue
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+func equalKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// instructionKeys renders every instruction in Opcodes for comparison.
+// Jump targets become instruction indexes relative to their subroutine and
+// call targets are rendered by callKey from their subroutine number.
+func (r *RageScript) instructionKeys(funcs []*Function, callKey func(f int) string) []string {
+	indexOf := make(map[int]int, len(r.Opcodes))
+	for i, ins := range r.Opcodes {
+		indexOf[ins.GetOffset()] = i
+	}
+	funcOf := make(map[int]int, len(funcs))
+	for i, f := range funcs {
+		funcOf[f.Offset] = i
+	}
+	start := 0
+	target := func(offset uint32) string {
+		if i, ok := indexOf[int(offset)]; ok {
+			return fmt.Sprintf("@%d", i-start)
+		}
+		return fmt.Sprintf("0x%X", offset)
+	}
+
+	keys := make([]string, len(r.Opcodes))
+	for i, ins := range r.Opcodes {
+		if ins.GetOpcode() == opcode.OP_FN_BEGIN {
+			start = i
+		}
+		var sb strings.Builder
+		sb.WriteString(opcode.Names[ins.GetOpcode()])
+		switch ins := ins.(type) {
+		case *opcode.Branch:
+			t := ins.GetOperands()[0].(uint32)
+			if ins.GetOpcode() == opcode.OP_CALL {
+				if f, ok := funcOf[int(t)]; ok {
+					sb.WriteString(" " + callKey(f))
+					break
+				}
+			}
+			sb.WriteString(" " + target(t))
+		case *opcode.Switch:
+			for _, c := range ins.Cases {
+				fmt.Fprintf(&sb, " %d:%s", c.Value, target(c.Target))
+			}
+		default:
+			for _, o := range ins.GetOperands() {
+				fmt.Fprintf(&sb, " %v", o)
+			}
+		}
+		keys[i] = sb.String()
+	}
+	return keys
+}
+
+// alignLines aligns two instruction sequences by their keys. Within each
+// run of changes, removed and inserted instructions with the same opcode
+// are paired up as modified.
+func alignLines(a, b []string, oldIns, newIns []opcode.Instruction) []DiffLine {
+	var lines []DiffLine
+	var removed, inserted []int
+	flush := func() {
+		i, j := 0, 0
+		for i < len(removed) && j < len(inserted) {
+			if oldIns[removed[i]].GetOpcode() == newIns[inserted[j]].GetOpcode() {
+				lines = append(lines, DiffLine{Op: LINE_MODIFY, Old: removed[i], New: inserted[j]})
+				i++
+				j++
+				continue
+			}
+			lines = append(lines, DiffLine{Op: LINE_REMOVE, Old: removed[i], New: -1})
+			i++
+		}
+		for ; i < len(removed); i++ {
+			lines = append(lines, DiffLine{Op: LINE_REMOVE, Old: removed[i], New: -1})
+		}
+		for ; j < len(inserted); j++ {
+			lines = append(lines, DiffLine{Op: LINE_INSERT, Old: -1, New: inserted[j]})
+		}
+		removed, inserted = nil, nil
+	}
+
+	for _, l := range editScript(a, b) {
+		switch l.Op {
+		case LINE_REMOVE:
+			removed = append(removed, l.Old)
+		case LINE_INSERT:
+			inserted = append(inserted, l.New)
+		default:
+			flush()
+			lines = append(lines, l)
+		}
+	}
+	flush()
+	return lines
+}
+
+// editScript returns the shortest sequence of equal, remove and insert
+// lines turning a into b, using the linear space variant of Myers'
+// algorithm. If more than maxEditDistance edits are needed, all of a is
+// removed and all of b inserted.
+func editScript(a, b []string) []DiffLine {
+	off := (len(a)+len(b)+1)/2 + 1
+	e := &editor{
+		a:   a,
+		b:   b,
+		off: off,
+		vf:  make([]int, 2*off+1),
+		vb:  make([]int, 2*off+1),
+	}
+	if e.diff(0, len(a), 0, len(b)) {
+		return e.lines
+	}
+
+	lines := make([]DiffLine, 0, len(a)+len(b))
+	for i := range a {
+		lines = append(lines, DiffLine{Op: LINE_REMOVE, Old: i, New: -1})
+	}
+	for j := range b {
+		lines = append(lines, DiffLine{Op: LINE_INSERT, Old: -1, New: j})
+	}
+	return lines
+}
+
+// editor holds the state of editScript. vf and vb are the furthest
+// reaching x of the forward and backward searches by diagonal, shared by
+// every subproblem.
+type editor struct {
+	a, b   []string
+	off    int
+	vf, vb []int
+	lines  []DiffLine
+}
+
+// diff appends the edit script turning a[a0:a1] into b[b0:b1]. It reports
+// false if that takes more than maxEditDistance edits.
+func (e *editor) diff(a0, a1, b0, b1 int) bool {
+	for a0 < a1 && b0 < b1 && e.a[a0] == e.b[b0] {
+		e.lines = append(e.lines, DiffLine{Op: LINE_EQUAL, Old: a0, New: b0})
+		a0++
+		b0++
+	}
+	suffix := 0
+	for a0 < a1-suffix && b0 < b1-suffix && e.a[a1-suffix-1] == e.b[b1-suffix-1] {
+		suffix++
+	}
+	a1, b1 = a1-suffix, b1-suffix
+
+	switch {
+	case a0 == a1:
+		for j := b0; j < b1; j++ {
+			e.lines = append(e.lines, DiffLine{Op: LINE_INSERT, Old: -1, New: j})
+		}
+	case b0 == b1:
+		for i := a0; i < a1; i++ {
+			e.lines = append(e.lines, DiffLine{Op: LINE_REMOVE, Old: i, New: -1})
+		}
+	default:
+		x, y, u, v, ok := e.middleSnake(a0, a1, b0, b1)
+		if !ok {
+			return false
+		}
+		e.diff(a0, x, b0, y)
+		for ; x < u; x, y = x+1, y+1 {
+			e.lines = append(e.lines, DiffLine{Op: LINE_EQUAL, Old: x, New: y})
+		}
+		e.diff(u, a1, v, b1)
+	}
+
+	for i := range suffix {
+		e.lines = append(e.lines, DiffLine{Op: LINE_EQUAL, Old: a1 + i, New: b1 + i})
+	}
+	return true
+}
+
+// middleSnake finds the diagonal run from (x, y) to (u, v) in the middle of
+// a shortest edit path from (a0, b0) to (a1, b1) by searching from both ends
+// at once. ok is false if the path is longer than maxEditDistance.
+func (e *editor) middleSnake(a0, a1, b0, b1 int) (x, y, u, v int, ok bool) {
+	n, m := a1-a0, b1-b0
+	delta := n - m
+	odd := delta%2 != 0
+	vf, vb, off := e.vf, e.vb, e.off
+	vf[off+1], vb[off+1] = 0, 0
+
+	for d := 0; d <= (n+m+1)/2; d++ {
+		if 2*d-1 > maxEditDistance {
+			return 0, 0, 0, 0, false
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var px int
+			if k == -d || (k != d && vf[off+k-1] < vf[off+k+1]) {
+				px = vf[off+k+1]
+			} else {
+				px = vf[off+k-1] + 1
+			}
+			py := px - k
+			sx, sy := px, py
+			for px < n && py < m && e.a[a0+px] == e.b[b0+py] {
+				px++
+				py++
+			}
+			vf[off+k] = px
+			if c := delta - k; odd && c >= -(d-1) && c <= d-1 && px >= n-vb[off+c] {
+				return a0 + sx, b0 + sy, a0 + px, b0 + py, true
+			}
+		}
+
+		// The backward search runs over the reversed sequences, where
+		// diagonal c meets diagonal delta-c of the forward search.
+		for c := -d; c <= d; c += 2 {
+			var px int
+			if c == -d || (c != d && vb[off+c-1] < vb[off+c+1]) {
+				px = vb[off+c+1]
+			} else {
+				px = vb[off+c-1] + 1
+			}
+			py := px - c
+			sx, sy := px, py
+			for px < n && py < m && e.a[a1-px-1] == e.b[b1-py-1] {
+				px++
+				py++
+			}
+			vb[off+c] = px
+			if k := delta - c; !odd && k >= -d && k <= d && vf[off+k] >= n-px {
+				return a1 - px, b1 - py, a1 - sx, b1 - sy, true
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
+
+// diffContext is how many unchanged lines DiffText shows around changes.
+const diffContext = 3
+
+// DiffText renders a diff of two scripts as text: header and static
+// changes, then each changed subroutine with its changed lines and a few
+// unchanged lines around them. Lines start with "+" if inserted, "-" if
+// removed and "~" if modified.
+func DiffText(old, new *RageScript, d ScriptDiff) string {
+	var sb strings.Builder
+	for _, h := range d.Header {
+		fmt.Fprintf(&sb, "header %s: %d -> %d\n", h.Field, h.Old, h.New)
+	}
+	for _, s := range d.Statics {
+		kind := "local"
+		if s.Global {
+			kind = "global"
+		}
+		fmt.Fprintf(&sb, "%s %d: %s -> %s\n", kind, s.Index, FormatStatic(s.Old), FormatStatic(s.New))
+	}
+
+	for _, f := range d.Functions {
+		fmt.Fprintf(&sb, "@@ sub %d %s: %s -> %s (%d inserted, %d removed, %d modified)\n",
+			f.Index, f.Status, nameOr(f.OldName), nameOr(f.NewName), f.Inserted(), f.Removed(), f.Modified())
+		shown := -1
+		for i, l := range f.Lines {
+			if !nearChange(f.Lines, i) {
+				continue
+			}
+			if shown != -1 && i > shown+1 {
+				sb.WriteString("   ...\n")
+			}
+			shown = i
+			switch l.Op {
+			case LINE_EQUAL:
+				fmt.Fprintf(&sb, "  %s\n", old.LineText(l.Old))
+			case LINE_REMOVE:
+				fmt.Fprintf(&sb, "- %s\n", old.LineText(l.Old))
+			case LINE_INSERT:
+				fmt.Fprintf(&sb, "+ %s\n", new.LineText(l.New))
+			case LINE_MODIFY:
+				fmt.Fprintf(&sb, "~ %s => %s\n", old.LineText(l.Old), new.LineText(l.New))
+			}
+		}
+	}
+	return sb.String()
+}
+
+// nearChange reports whether the line at i is a change or within
+// diffContext lines of one.
+func nearChange(lines []DiffLine, i int) bool {
+	for j := max(i-diffContext, 0); j <= min(i+diffContext, len(lines)-1); j++ {
+		if lines[j].Op != LINE_EQUAL {
+			return true
+		}
+	}
+	return false
+}
+
+// LineText renders the instruction at index with its code offset.
+func (r *RageScript) LineText(index int) string {
+	ins := r.Opcodes[index]
+	return fmt.Sprintf("0x%04X %s", ins.GetOffset(), ins.String("", r.Subroutines))
+}
+
+func nameOr(name string) string {
+	if name == "" {
+		return "-"
+	}
+	return name
+}
@@ -0,0 +1,173 @@
+package script
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+// twoFunctions returns code for a subroutine calling a second, empty one,
+// with extra instructions at the start of the first.
+func twoFunctions(extra ...byte) []byte {
+	code := append([]byte{opcode.OP_FN_BEGIN, 0, 0, 0}, extra...)
+	code = append(code, opcode.OP_CALL)
+	code = binary.LittleEndian.AppendUint32(code, uint32(len(code)+4+3))
+	code = append(code, opcode.OP_FN_END, 0, 0)
+	return append(code, opcode.OP_FN_BEGIN, 0, 0, 0, opcode.OP_FN_END, 0, 0)
+}
+
+func TestDiffIgnoresRelocatedCalls(t *testing.T) {
+	old := newTestScript(t, twoFunctions(), []uint{1}, nil)
+	new := newTestScript(t, twoFunctions(opcode.OP_ADD), []uint{2}, nil)
+
+	d := Diff(&old, &new)
+	if len(d.Functions) != 1 || d.Functions[0].Index != 0 || d.Functions[0].OldLen != 3 || d.Functions[0].NewLen != 4 {
+		t.Errorf("Expected only the first subroutine to change, got %+v", d.Functions)
+	}
+	want := []DiffLine{{LINE_EQUAL, 0, 0}, {LINE_INSERT, -1, 1}, {LINE_EQUAL, 1, 2}, {LINE_EQUAL, 2, 3}}
+	if got := d.Functions[0].Lines; len(got) != len(want) {
+		t.Errorf("Unexpected lines %+v", got)
+	} else {
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("Line %d: expected %+v, got %+v", i, want[i], got[i])
+			}
+		}
+	}
+	if len(d.Header) != 1 || d.Header[0] != (HeaderChange{Field: "CodeSize", Old: 19, New: 20}) {
+		t.Errorf("Unexpected header changes %+v", d.Header)
+	}
+	if len(d.Statics) != 1 || d.Statics[0].Global || *d.Statics[0].Old != 1 || *d.Statics[0].New != 2 {
+		t.Errorf("Unexpected static changes %+v", d.Statics)
+	}
+
+	if d := Diff(&old, &old); !d.Empty() {
+		t.Errorf("Expected no differences with itself, got %+v", d)
+	}
+}
+
+func TestDiffAlignsInsertedSubroutines(t *testing.T) {
+	call := func(target uint32) []byte { return binary.LittleEndian.AppendUint32([]byte{opcode.OP_CALL}, target) }
+	fn := func(body ...byte) []byte {
+		return append(append([]byte{opcode.OP_FN_BEGIN, 0, 0, 0}, body...), opcode.OP_FN_END, 0, 0)
+	}
+	join := func(fns ...[]byte) []byte {
+		var code []byte
+		for _, f := range fns {
+			code = append(code, f...)
+		}
+		return code
+	}
+	old := newTestScript(t, join(fn(append(call(17), call(25)...)...), fn(opcode.OP_ADD), fn(opcode.OP_DUP, opcode.OP_DUP)), nil, nil)
+	new := newTestScript(t, join(fn(append(call(25), call(33)...)...), fn(opcode.OP_POP), fn(opcode.OP_ADD), fn(opcode.OP_DUP, opcode.OP_DUP)), nil, nil)
+
+	d := Diff(&old, &new)
+	if len(d.Functions) != 1 || d.Functions[0].Status != DIFF_ADDED || d.Functions[0].Index != 1 || d.Functions[0].NewLen != 3 {
+		t.Errorf("Expected only subroutine 1 to be added, got %+v", d.Functions)
+	}
+
+	d = Diff(&new, &old)
+	if len(d.Functions) != 1 || d.Functions[0].Status != DIFF_REMOVED || d.Functions[0].Index != 1 {
+		t.Errorf("Expected only subroutine 1 to be removed, got %+v", d.Functions)
+	}
+}
+
+func TestDiffPairsModifiedInstructions(t *testing.T) {
+	push := func(v uint32) []byte { return binary.LittleEndian.AppendUint32([]byte{opcode.OP_PUSH}, v) }
+	old := newTestScript(t, twoFunctions(append(push(1), opcode.OP_POP)...), nil, nil)
+	new := newTestScript(t, twoFunctions(append(push(2), opcode.OP_DUP)...), nil, nil)
+
+	d := Diff(&old, &new)
+	if len(d.Functions) != 1 {
+		t.Fatalf("Expected one changed subroutine, got %+v", d.Functions)
+	}
+	f := d.Functions[0]
+	if f.Modified() != 1 || f.Removed() != 1 || f.Inserted() != 1 {
+		t.Errorf("Expected one modified, removed and inserted line, got %+v", f.Lines)
+	}
+	if f.Lines[1] != (DiffLine{Op: LINE_MODIFY, Old: 1, New: 1}) {
+		t.Errorf("Expected the push to be modified, got %+v", f.Lines[1])
+	}
+}
+
+func TestEditScriptFindsShortestEdit(t *testing.T) {
+	lines := editScript([]string{"a", "b", "c", "a", "b", "b", "a"}, []string{"c", "b", "a", "b", "a", "c"})
+	edits := 0
+	for _, l := range lines {
+		if l.Op != LINE_EQUAL {
+			edits++
+		}
+	}
+	if edits != 5 {
+		t.Errorf("Expected 5 edits, got %d: %+v", edits, lines)
+	}
+}
+
+func TestEditScriptMatchesLCS(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for iter := 0; iter < 200; iter++ {
+		a := make([]string, rng.Intn(40))
+		b := make([]string, rng.Intn(40))
+		for i := range a {
+			a[i] = string(rune('a' + rng.Intn(4)))
+		}
+		for i := range b {
+			b[i] = string(rune('a' + rng.Intn(4)))
+		}
+
+		// lcs[i][j] is the longest common subsequence of a[i:] and b[j:].
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+
+		x, y, edits := 0, 0, 0
+		for _, l := range editScript(a, b) {
+			switch l.Op {
+			case LINE_EQUAL:
+				if l.Old != x || l.New != y || a[x] != b[y] {
+					t.Fatalf("%v -> %v: bad equal line %+v at %d,%d", a, b, l, x, y)
+				}
+				x, y = x+1, y+1
+			case LINE_REMOVE:
+				if l.Old != x {
+					t.Fatalf("%v -> %v: bad remove line %+v at %d", a, b, l, x)
+				}
+				x, edits = x+1, edits+1
+			case LINE_INSERT:
+				if l.New != y {
+					t.Fatalf("%v -> %v: bad insert line %+v at %d", a, b, l, y)
+				}
+				y, edits = y+1, edits+1
+			}
+		}
+		if x != len(a) || y != len(b) {
+			t.Fatalf("%v -> %v: script stops at %d,%d", a, b, x, y)
+		}
+		if want := len(a) + len(b) - 2*lcs[0][0]; edits != want {
+			t.Fatalf("%v -> %v: expected %d edits, got %d", a, b, want, edits)
+		}
+	}
+}
+
+func TestEditScriptGivesUpOnDistantSequences(t *testing.T) {
+	a, b := make([]string, 3000), make([]string, 3000)
+	for i := range a {
+		a[i], b[i] = "a", "b"
+	}
+	lines := editScript(a, b)
+	if len(lines) != 6000 || lines[2999].Op != LINE_REMOVE || lines[3000] != (DiffLine{Op: LINE_INSERT, Old: -1, New: 0}) {
+		t.Errorf("Expected a full replace, got %d lines", len(lines))
+	}
+}
@@ -0,0 +1,42 @@
+package script
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNilInstruction is returned when a nil instruction is inserted.
+var ErrNilInstruction = errors.New("script: cannot insert a nil instruction")
+
+// HeaderError reports a script header field that is missing or out of range.
+type HeaderError struct {
+	Field  string
+	Value  int64
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("script header: %s = %d: %s", e.Field, e.Value, e.Reason)
+}
+
+// CodeError reports bytecode that cannot be disassembled.
+type CodeError struct {
+	Offset int
+	Reason string
+}
+
+func (e *CodeError) Error() string {
+	return fmt.Sprintf("script code at 0x%04X: %s", e.Offset, e.Reason)
+}
+
+// IndexError reports an instruction or slot index that is out of range for
+// an edit.
+type IndexError struct {
+	Op    string
+	Index int
+	Len   int
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("script: %s: index %d out of range for %d items", e.Op, e.Index, e.Len)
+}
@@ -1,6 +1,7 @@
 package opcode
 
 import (
+	"encoding/binary"
 	"fmt"
 	"strings"
 
@@ -12,11 +13,12 @@ type Base struct {
 }
 
 func NewInstruction(offset int, opcode uint8, args []byte) Instruction {
+	if opcode > 0x4F {
+		return NewPush(offset, opcode, args)
+	}
 	f, ok := Instructions[opcode]
 	if ok {
-		ins := f(offset, opcode, args)
-		ins.Disassemble()
-		return ins
+		return f(offset, opcode, args)
 	}
 
 	p := &Base{
@@ -32,6 +34,17 @@ func NewInstruction(offset int, opcode uint8, args []byte) Instruction {
 }
 
 func (p *Base) Disassemble() {
+	p.Operands = make([]any, 0)
+	switch {
+	case p.GetOpcode() == OP_FN_BEGIN && len(p.Args) == 3:
+		p.Operands = append(p.Operands, p.Args[0], binary.LittleEndian.Uint16(p.Args[1:3]))
+	case p.GetOpcode() == OP_FN_END && len(p.Args) == 2:
+		p.Operands = append(p.Operands, p.Args[0], p.Args[1])
+	default:
+		for _, b := range p.Args {
+			p.Operands = append(p.Operands, b)
+		}
+	}
 }
 
 func (p *Base) GetOffset() int {
@@ -29,7 +29,7 @@ func NewBranch(offset int, opcode uint8, args []byte) *Branch {
 
 func (p *Branch) Disassemble() {
 	bo := binary.LittleEndian.Uint32(p.Args[0:4])
-	p.Operands = append(p.Operands, bo)
+	p.Operands = []any{bo}
 }
 
 func (p *Branch) GetOffset() int {
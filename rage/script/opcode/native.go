@@ -29,11 +29,11 @@ func (p *Native) Disassemble() {
 	in := p.Opcode.Args[0]
 	out := p.Opcode.Args[1]
 	native := binary.LittleEndian.Uint32(p.Args[2:6])
-	nativeStr, ok := nativeFunctions[native]
+	nativeStr, ok := NativeName(native)
 	if !ok {
 		nativeStr = fmt.Sprintf("Unknown (%d)", native)
 	}
-	p.Operands = append(p.Operands, nativeStr, in, out)
+	p.Operands = []any{nativeStr, in, out}
 }
 
 func (p *Native) GetOffset() int {
@@ -0,0 +1,246 @@
+package opcode
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NativeParam is a parameter of a native. Pointer types end in "*".
+type NativeParam struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// NativeInfo describes a native. Natives only known by name have Typed
+// false, and their Return and Params mean nothing.
+type NativeInfo struct {
+	Hash     uint32        `json:"hash"`
+	Name     string        `json:"name"`
+	Return   string        `json:"return,omitempty"`
+	Params   []NativeParam `json:"params,omitempty"`
+	Category string        `json:"category,omitempty"`
+	Notes    string        `json:"notes,omitempty"`
+	Typed    bool          `json:"typed"`
+}
+
+// In returns the number of values a call pops.
+func (n NativeInfo) In() int { return len(n.Params) }
+
+// Out returns the number of values a call pushes.
+func (n NativeInfo) Out() int {
+	if n.Return == "void" {
+		return 0
+	}
+	return 1
+}
+
+// Signature renders the native as declared in the database.
+func (n NativeInfo) Signature() string {
+	if !n.Typed {
+		return n.Name
+	}
+	params := make([]string, len(n.Params))
+	for i, p := range n.Params {
+		params[i] = p.Type + " " + p.Name
+	}
+	return fmt.Sprintf("%s %s(%s)", n.Return, n.Name, strings.Join(params, ", "))
+}
+
+// CheckCall reports a CallNative whose counts disagree with the signature.
+// Untyped natives accept any counts.
+func (n NativeInfo) CheckCall(in, out int) error {
+	if !n.Typed || (in == n.In() && out == n.Out()) {
+		return nil
+	}
+	return fmt.Errorf("%s takes in=%d out=%d, not in=%d out=%d", n.Signature(), n.In(), n.Out(), in, out)
+}
+
+// NativeDB maps native hashes to their descriptions.
+type NativeDB struct {
+	byHash map[uint32]*NativeInfo
+	byName map[string]uint32
+}
+
+func NewNativeDB() *NativeDB {
+	return &NativeDB{byHash: make(map[uint32]*NativeInfo), byName: make(map[string]uint32)}
+}
+
+// Parse adds the natives in src to the database, replacing any with the
+// same hash. The format is described at the top of natives.dat.
+func (db *NativeDB) Parse(src string) error {
+	category := ""
+	for i, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			category = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		n, err := parseNativeLine(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if n == nil {
+			continue
+		}
+		n.Category = category
+		db.add(n)
+	}
+	return nil
+}
+
+func (db *NativeDB) add(n *NativeInfo) {
+	if old, ok := db.byHash[n.Hash]; ok && db.byName[old.Name] == n.Hash {
+		delete(db.byName, old.Name)
+	}
+	db.byHash[n.Hash] = n
+	db.byName[n.Name] = n.Hash
+}
+
+// parseNativeLine parses "hash=NAME" or "hash=RETURN NAME(TYPE name, ...)"
+// with optional "; notes". It returns nil for natives whose hash is
+// UNKNOWN.
+func parseNativeLine(line string) (*NativeInfo, error) {
+	key, decl, ok := strings.Cut(line, "=")
+	if !ok {
+		return nil, fmt.Errorf("expected hash=NAME, got %q", line)
+	}
+	key = strings.TrimSpace(key)
+	if key == "UNKNOWN" {
+		return nil, nil
+	}
+	hash, err := strconv.ParseUint(key, 0, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hash %q", key)
+	}
+	n := &NativeInfo{Hash: uint32(hash)}
+	decl, notes, _ := strings.Cut(decl, ";")
+	n.Notes = strings.TrimSpace(notes)
+	decl = strings.TrimSpace(decl)
+
+	open := strings.Index(decl, "(")
+	if open == -1 {
+		if decl == "" || strings.ContainsAny(decl, " \t)") {
+			return nil, fmt.Errorf("invalid native name %q", decl)
+		}
+		n.Name = decl
+		return n, nil
+	}
+	if !strings.HasSuffix(decl, ")") {
+		return nil, fmt.Errorf("missing ) in %q", decl)
+	}
+	head := strings.Fields(decl[:open])
+	switch len(head) {
+	case 1:
+		n.Return, n.Name = "void", head[0]
+	case 2:
+		n.Return, n.Name = head[0], head[1]
+	default:
+		return nil, fmt.Errorf("expected RETURN NAME(...), got %q", decl)
+	}
+	if args := strings.TrimSpace(decl[open+1 : len(decl)-1]); args != "" {
+		for j, a := range strings.Split(args, ",") {
+			p, err := parseNativeParam(a, j)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", n.Name, err)
+			}
+			n.Params = append(n.Params, p)
+		}
+	}
+	n.Typed = true
+	return n, nil
+}
+
+// parseNativeParam parses "TYPE name", "TYPE* name", "TYPE *name" or a
+// bare TYPE, which is named after its position.
+func parseNativeParam(s string, index int) (NativeParam, error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), "*", "* ")
+	fields := strings.Fields(s)
+	var p NativeParam
+	switch {
+	case len(fields) == 0:
+		return p, fmt.Errorf("empty parameter %d", index+1)
+	case len(fields) >= 2 && fields[len(fields)-1] != "*":
+		p.Type = strings.Join(fields[:len(fields)-1], "")
+		p.Name = fields[len(fields)-1]
+	default:
+		p.Type = strings.Join(fields, "")
+		p.Name = fmt.Sprintf("arg%d", index+1)
+	}
+	return p, nil
+}
+
+// Lookup returns the native with the given hash.
+func (db *NativeDB) Lookup(hash uint32) (NativeInfo, bool) {
+	n, ok := db.byHash[hash]
+	if !ok {
+		return NativeInfo{}, false
+	}
+	return *n, true
+}
+
+// LookupName returns the native with the given name.
+func (db *NativeDB) LookupName(name string) (NativeInfo, bool) {
+	h, ok := db.byName[name]
+	if !ok {
+		return NativeInfo{}, false
+	}
+	return db.Lookup(h)
+}
+
+// All returns every native sorted by name.
+func (db *NativeDB) All() []NativeInfo {
+	all := make([]NativeInfo, 0, len(db.byHash))
+	for _, n := range db.byHash {
+		all = append(all, *n)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+//go:embed natives.dat
+var nativesDat string
+
+// Natives is the database used to name and check natives. It starts with
+// the entries embedded from natives.dat.
+var Natives = NewNativeDB()
+
+func init() {
+	if err := Natives.Parse(nativesDat); err != nil {
+		panic("natives.dat: " + err.Error())
+	}
+}
+
+// LoadNatives adds the natives in the file at path to Natives, overriding
+// the built-in entries.
+func LoadNatives(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := Natives.Parse(string(data)); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// NativeHash returns the hash of a native by name.
+func NativeHash(name string) (uint32, bool) {
+	h, ok := Natives.byName[name]
+	return h, ok
+}
+
+// NativeName returns the name of a native by hash.
+func NativeName(hash uint32) (string, bool) {
+	n, ok := Natives.byHash[hash]
+	if !ok {
+		return "", false
+	}
+	return n.Name, true
+}
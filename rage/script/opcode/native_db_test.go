@@ -0,0 +1,65 @@
+package opcode
+
+import "testing"
+
+func TestNativeDBParsesSignaturesAndOverrides(t *testing.T) {
+	db := NewNativeDB()
+	err := db.Parse(`# comment
+1=PLAIN
+UNKNOWN=NOBODY_KNOWS
+[Math]
+2=float SCALE(float value, int *times) ; notes here
+3=PROC()
+[]
+4=int COUNT(Ped)
+`)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	if n, ok := db.Lookup(1); !ok || n.Name != "PLAIN" || n.Typed || n.CheckCall(3, 2) != nil {
+		t.Errorf("Unexpected untyped native %+v", n)
+	}
+	if _, ok := db.LookupName("NOBODY_KNOWS"); ok {
+		t.Errorf("Expected natives with an UNKNOWN hash to be skipped")
+	}
+	n, ok := db.LookupName("SCALE")
+	if !ok || n.Category != "Math" || n.Notes != "notes here" || n.Signature() != "float SCALE(float value, int* times)" {
+		t.Fatalf("Unexpected native %+v", n)
+	}
+	if n.CheckCall(2, 1) != nil || n.CheckCall(2, 0) == nil {
+		t.Errorf("Expected SCALE to take in=2 out=1")
+	}
+	if n, _ := db.LookupName("PROC"); n.Return != "void" || n.In() != 0 || n.Out() != 0 {
+		t.Errorf("Expected PROC to take and return nothing, got %+v", n)
+	}
+	if n, _ := db.LookupName("COUNT"); n.Category != "" || n.Params[0].Name != "arg1" || n.Params[0].Type != "Ped" {
+		t.Errorf("Unexpected native %+v", n)
+	}
+
+	if err := db.Parse("1=void RENAMED(int x)"); err != nil {
+		t.Fatalf("Failed to parse override: %v", err)
+	}
+	if _, ok := db.LookupName("PLAIN"); ok {
+		t.Errorf("Expected the old name to be replaced")
+	}
+	if n, ok := db.LookupName("RENAMED"); !ok || n.Hash != 1 || !n.Typed {
+		t.Errorf("Unexpected override %+v", n)
+	}
+
+	for _, bad := range []string{"x=NAME", "5=int BROKEN(int", "6=A B C(int)", "7"} {
+		if err := NewNativeDB().Parse(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestBuiltInNativesHaveSignatures(t *testing.T) {
+	n, ok := Natives.LookupName("WAIT")
+	if !ok || !n.Typed || n.In() != 1 || n.Out() != 0 {
+		t.Errorf("Unexpected WAIT %+v", n)
+	}
+	if name, ok := NativeName(n.Hash); !ok || name != "WAIT" {
+		t.Errorf("Expected NativeName to find WAIT, got %q", name)
+	}
+}
@@ -1,10 +1,6 @@
 package opcode
 
 import (
-	_ "embed"
-	"strconv"
-	"strings"
-
 	"github.com/charmbracelet/lipgloss"
 )
 
@@ -225,6 +221,9 @@ var Instructions = map[uint8]func(int, uint8, []byte) Instruction{
 	OP_CALL: func(offset int, opcode uint8, args []byte) Instruction {
 		return NewBranch(offset, opcode, args)
 	},
+	OP_SWITCH: func(offset int, opcode uint8, args []byte) Instruction {
+		return NewSwitch(offset, opcode, args)
+	},
 }
 
 var (
@@ -233,34 +232,6 @@ var (
 	highlightStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFF00"))
 )
 
-//go:embed native_new.dat
-var nativeNew string
-
-var nativeFunctions map[uint32]string
-
-func parseNativeFile(file string) map[uint32]string {
-	m := make(map[uint32]string)
-	lines := strings.Split(file, "\n")
-	for _, line := range lines {
-		parts := strings.Split(line, "=")
-		if len(parts) == 2 {
-			key := strings.TrimSpace(parts[0])
-			value := strings.TrimSpace(parts[1])
-			if key != "" && value != "" {
-				keyInt, err := strconv.ParseUint(key, 10, 32)
-				if err == nil {
-					m[uint32(keyInt)] = value
-				}
-			}
-		}
-	}
-	return m
-}
-
-func init() {
-	nativeFunctions = parseNativeFile(nativeNew)
-}
-
 type Opcode struct {
 	Offset   int
 	Opcode   uint8
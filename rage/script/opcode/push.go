@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
+	"strconv"
 	"strings"
 
 	"github.com/charmbracelet/lipgloss"
@@ -41,7 +42,7 @@ func (p *Push) Disassemble() {
 	} else if p.Opcode.Opcode == OP_PUSH {
 		p.Operands = append(p.Operands, binary.LittleEndian.Uint32(p.Args[0:4]))
 	} else if p.Opcode.Opcode > 79 {
-		p.Operands = append(p.Operands, p.GetOpcode()-96)
+		p.Operands = append(p.Operands, int(p.GetOpcode())-96)
 	}
 }
 
@@ -80,8 +81,33 @@ func (p *Push) String(color string, subroutines map[int]string) string {
 	for j := range len(ops) {
 		opstrs[j] = fmt.Sprintf("%v", ops[j])
 	}
+	if p.GetOpcode() == OP_PUSH_STRING {
+		opstrs[0] = QuoteString(ops[0].(string))
+	}
+	if p.GetOpcode() == OP_PUSHF {
+		opstrs[0] = FormatFloat(ops[0].(float32))
+	}
 	name = functionNameStyle.Render(name)
 	opstr := style.Render(strings.Join(opstrs, " "))
 	sb.WriteString(name + " " + opstr)
 	return sb.String()
 }
+
+// FormatFloat renders a PushF operand. NaNs, infinities and negative zero
+// are written as their raw bits, 0xNNNNNNNN, so they survive reassembly
+// bit for bit.
+func FormatFloat(f float32) string {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) || (f == 0 && math.Signbit(float64(f))) {
+		return fmt.Sprintf("0x%08X", math.Float32bits(f))
+	}
+	return fmt.Sprintf("%v", f)
+}
+
+// QuoteString renders a PushString operand as a quoted literal. The
+// terminating NUL is implied; strings without one are marked noterm.
+func QuoteString(s string) string {
+	if strings.HasSuffix(s, "\x00") {
+		return strconv.Quote(strings.TrimSuffix(s, "\x00"))
+	}
+	return strconv.Quote(s) + " noterm"
+}
@@ -0,0 +1,101 @@
+package opcode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+type SwitchCase struct {
+	Value             int32
+	Target            uint32
+	TargetInstruction Instruction
+}
+
+type Switch struct {
+	Opcode
+	Cases []SwitchCase
+}
+
+func NewSwitch(offset int, opcode uint8, args []byte) *Switch {
+	p := &Switch{
+		Opcode: Opcode{
+			Offset:   offset,
+			Opcode:   opcode,
+			Args:     args,
+			Operands: make([]any, 0),
+		},
+	}
+	p.Disassemble()
+	return p
+}
+
+func (p *Switch) Disassemble() {
+	count := 0
+	if len(p.Args) > 0 {
+		count = int(p.Args[0])
+	}
+	p.Cases = make([]SwitchCase, 0, count)
+	p.Operands = []any{uint8(count)}
+	for i := range count {
+		c := p.Args[1+i*8:]
+		if len(c) < 8 {
+			break
+		}
+		sc := SwitchCase{
+			Value:  int32(binary.LittleEndian.Uint32(c[0:4])),
+			Target: binary.LittleEndian.Uint32(c[4:8]),
+		}
+		p.Cases = append(p.Cases, sc)
+		p.Operands = append(p.Operands, sc.Value, sc.Target)
+	}
+}
+
+func (p *Switch) GetOffset() int {
+	return p.Offset
+}
+
+func (p *Switch) GetOpcode() uint8 {
+	return p.Opcode.Opcode
+}
+
+func (p *Switch) GetOperands() []any {
+	return p.Operands
+}
+
+func (p *Switch) GetLength() int {
+	l := uint8(0)
+	if len(p.Args) > 0 {
+		l = p.Args[0]
+	}
+	return GetInstructionLength(p.GetOpcode(), l)
+}
+
+// UpdateCaseTarget rewrites the jump offset of case i.
+func (p *Switch) UpdateCaseTarget(i int, newOffset int) {
+	newTarget := uint32(newOffset)
+	p.Cases[i].Target = newTarget
+	p.Operands[2+i*2] = newTarget
+	binary.LittleEndian.PutUint32(p.Args[1+i*8+4:1+i*8+8], newTarget)
+}
+
+func (p *Switch) String(color string, subroutines map[int]string) string {
+	style := lipgloss.NewStyle()
+	if color != "" {
+		style = style.Foreground(lipgloss.Color(color))
+	}
+	name := functionNameStyle.Render(Names[p.GetOpcode()])
+	cases := make([]string, len(p.Cases))
+	for i, c := range p.Cases {
+		target := fmt.Sprintf("0x%04X", c.Target)
+		if subroutines != nil {
+			if l, ok := subroutines[int(c.Target)]; ok {
+				target = l
+			}
+		}
+		cases[i] = fmt.Sprintf("%d:%s", c.Value, target)
+	}
+	return name + " " + style.Render(strings.Join(cases, " "))
+}
@@ -7,7 +7,7 @@ import (
 
 	"github.com/charmbracelet/lipgloss"
 
-	"github.com/mrchip53/gta-tools/rage/img"
+	"github.com/mrchip53/gta-tools/rage"
 	"github.com/mrchip53/gta-tools/rage/script/opcode"
 	"github.com/mrchip53/gta-tools/rage/util"
 )
@@ -19,9 +19,10 @@ const (
 )
 
 var (
-	highlightStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFF00"))
-	opNameStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("#00FFFF"))
-	markerStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF00FF"))
+	highlightStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFF00"))
+	opNameStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("#00FFFF"))
+	markerStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF00FF"))
+	breakpointStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5555"))
 )
 
 type scriptHeader struct {
@@ -50,18 +51,21 @@ func (h scriptHeader) Bytes() []byte {
 	return buf
 }
 
-func newScriptHeader(data []byte) (int32, scriptHeader) {
-	if len(data) < 28 {
-		panic("Not enough data to read header")
+func newScriptHeader(data []byte) (int32, scriptHeader, error) {
+	if len(data) < 24 {
+		return 0, scriptHeader{}, &HeaderError{Field: "length", Value: int64(len(data)), Reason: "need 24 bytes"}
 	}
 	i := binary.LittleEndian.Uint32(data[0:4])
 	var c int32
 	l := int32(24)
 	if i == HEADER_MAGIC_ENCRYPTED_COMPRESSED {
+		if len(data) < 28 {
+			return 0, scriptHeader{}, &HeaderError{Field: "length", Value: int64(len(data)), Reason: "need 28 bytes"}
+		}
 		c = int32(binary.LittleEndian.Uint32(data[24:28]))
 		l = 28
 	}
-	return l, scriptHeader{
+	h := scriptHeader{
 		Identifier:       i,
 		CodeSize:         int32(binary.LittleEndian.Uint32(data[4:8])),
 		LocalVarCount:    int32(binary.LittleEndian.Uint32(data[8:12])),
@@ -70,6 +74,15 @@ func newScriptHeader(data []byte) (int32, scriptHeader) {
 		GlobalsSignature: int32(binary.LittleEndian.Uint32(data[20:24])),
 		CompressedSize:   c,
 	}
+	for _, f := range []struct {
+		name  string
+		value int32
+	}{{"CodeSize", h.CodeSize}, {"LocalVarCount", h.LocalVarCount}, {"GlobalVarCount", h.GlobalVarCount}} {
+		if f.value < 0 {
+			return 0, scriptHeader{}, &HeaderError{Field: f.name, Value: int64(f.value), Reason: "negative"}
+		}
+	}
+	return l, h, nil
 }
 
 type RageScript struct {
@@ -79,78 +92,123 @@ type RageScript struct {
 	Locals      []uint
 	Globals     []uint
 	Unsupported bool
+	// Err is why the payload could not be decoded when Unsupported is set.
+	Err error
 
 	Opcodes     []opcode.Instruction
 	Subroutines map[int]string
 
-	Entry *img.ImgEntry
-
-	localBytes  []byte
-	globalBytes []byte
+	Entry rage.Entry
 
 	// Render options
 	showBytecode bool
 }
 
-func NewRageScript(entry *img.ImgEntry) RageScript {
-	data := entry.Data()
-	s, h := newScriptHeader(data)
+// NewRageScript decodes a script entry. A script that cannot be decoded
+// comes back Unsupported with the reason in Err.
+func NewRageScript(entry rage.Entry) RageScript {
+	script := RageScript{
+		Name:        entry.Name(),
+		Subroutines: make(map[int]string),
+		Entry:       entry,
+	}
+	data, err := entry.ReadData()
+	if err == nil {
+		err = script.load(data)
+	}
+	if err != nil {
+		script.Unsupported = true
+		script.Err = err
+	}
+	return script
+}
+
+// PlainBytes returns an unencrypted script file holding code and the given
+// statics, as read by NewRageScript.
+func PlainBytes(code []byte, locals, globals []uint) []byte {
+	h := scriptHeader{
+		Identifier:     HEADER_MAGIC,
+		CodeSize:       int32(len(code)),
+		LocalVarCount:  int32(len(locals)),
+		GlobalVarCount: int32(len(globals)),
+	}
+	data := append(h.Bytes(), code...)
+	data = append(data, encodeVars(locals)...)
+	return append(data, encodeVars(globals)...)
+}
+
+func (r *RageScript) load(data []byte) error {
+	s, h, err := newScriptHeader(data)
+	if err != nil {
+		return err
+	}
+	switch h.Identifier {
+	case HEADER_MAGIC, HEADER_MAGIC_ENCRYPTED, HEADER_MAGIC_ENCRYPTED_COMPRESSED:
+	default:
+		return &HeaderError{Field: "Identifier", Value: int64(h.Identifier), Reason: "bad magic"}
+	}
+	r.Header = h
 
 	encrypted := h.Identifier == HEADER_MAGIC_ENCRYPTED
 	compressed := h.Identifier == HEADER_MAGIC_ENCRYPTED_COMPRESSED
 
 	var code, l, g []byte
 	if compressed {
-		ed := data[s : s+h.CompressedSize]
-		cd := util.Decrypt(ed)
-		_ = cd
-		panic("compressed")
+		code, l, g, err = decompressPayload(data[s:], h)
+		if err != nil {
+			return err
+		}
 	} else {
-		code = data[s : s+h.CodeSize]
-		l = data[s+h.CodeSize : s+h.CodeSize+h.LocalVarCount*4]
-		g = data[s+h.CodeSize+h.LocalVarCount*4 : s+h.CodeSize+h.LocalVarCount*4+h.GlobalVarCount*4]
+		start := int(s)
+		codeEnd := start + int(h.CodeSize)
+		localsEnd := codeEnd + int(h.LocalVarCount)*4
+		globalsEnd := localsEnd + int(h.GlobalVarCount)*4
+		if globalsEnd > len(data) {
+			return &HeaderError{Field: "CodeSize", Value: int64(h.CodeSize),
+				Reason: fmt.Sprintf("code and statics need %d bytes, have %d", globalsEnd, len(data))}
+		}
+		code = data[start:codeEnd]
+		l = data[codeEnd:localsEnd]
+		g = data[localsEnd:globalsEnd]
 
 		if encrypted {
-			util.Decrypt(code)
-			util.Decrypt(l)
-			util.Decrypt(g)
+			for _, b := range [][]byte{code, l, g} {
+				if err := util.Decrypt(b); err != nil {
+					return fmt.Errorf("decrypt script: %w", err)
+				}
+			}
 		}
 	}
 
-	script := RageScript{
-		Name:        entry.Name(),
-		Header:      h,
-		Code:        code,
-		Unsupported: compressed,
-		Subroutines: make(map[int]string),
-		Entry:       entry,
-		localBytes:  l,
-		globalBytes: g,
-	}
-
-	script.disassemble()
+	r.Code = code
+	r.Locals = decodeVars(l)
+	r.Globals = decodeVars(g)
+	return r.disassemble()
+}
 
-	return script
+// Check loads a script entry the way NewRageScript does and returns why it
+// cannot be used.
+func Check(entry rage.Entry) error {
+	return NewRageScript(entry).Err
 }
 
-func (r *RageScript) disassemble() {
+func (r *RageScript) disassemble() error {
 	r.Opcodes = make([]opcode.Instruction, 0)
 	offsetToInstructionMap := make(map[int]opcode.Instruction)
 	var ptr int
 	for ptr < len(r.Code) {
 		c := r.Code[ptr]
-		l := opcode.GetInstructionLength(c, r.Code[ptr+1])
+		var p1 uint8
+		if ptr+1 < len(r.Code) {
+			p1 = r.Code[ptr+1]
+		}
+		l := opcode.GetInstructionLength(c, p1)
+		if ptr+l > len(r.Code) {
+			return &CodeError{Offset: ptr, Reason: fmt.Sprintf("opcode 0x%02X needs %d bytes, %d left", c, l, len(r.Code)-ptr)}
+		}
 		args := make([]byte, l-1)
 		copy(args, r.Code[ptr+1:ptr+l])
-		var ins opcode.Instruction = opcode.NewInstruction(ptr, c, args)
-		if c > 0x4F && c <= 0xFF {
-			ins = opcode.NewPush(ptr, c, args)
-		} else {
-			f, ok := opcode.Instructions[c]
-			if ok {
-				ins = f(ptr, c, args)
-			}
-		}
+		ins := opcode.NewInstruction(ptr, c, args)
 		if ins.GetOpcode() == opcode.OP_FN_BEGIN {
 			r.Subroutines[ptr] = fmt.Sprintf("sub_0x%04X", ptr)
 		}
@@ -166,10 +224,21 @@ func (r *RageScript) disassemble() {
 				branchIns.TargetInstruction = targetIns
 			}
 		}
+		if switchIns, ok := ins.(*opcode.Switch); ok {
+			for i, c := range switchIns.Cases {
+				if targetIns, found := offsetToInstructionMap[int(c.Target)]; found {
+					switchIns.Cases[i].TargetInstruction = targetIns
+				}
+			}
+		}
 	}
+	return nil
 }
 
-func (r *RageScript) Rebuild() {
+// Rebuild reassigns offsets, relocates branches and writes the script back
+// to its entry. The entry is left unchanged when the script cannot be
+// serialised.
+func (r *RageScript) Rebuild() error {
 	newCode := make([]byte, 0)
 	currentOffset := 0
 
@@ -189,6 +258,13 @@ func (r *RageScript) Rebuild() {
 				branchIns.UpdateTargetOffset(branchIns.TargetInstruction.GetOffset())
 			}
 		}
+		if switchIns, ok := ins.(*opcode.Switch); ok {
+			for i, c := range switchIns.Cases {
+				if c.TargetInstruction != nil {
+					switchIns.UpdateCaseTarget(i, c.TargetInstruction.GetOffset())
+				}
+			}
+		}
 
 		newCode = append(newCode, ins.GetOpcode())
 		newCode = append(newCode, ins.GetArgs()...)
@@ -196,26 +272,39 @@ func (r *RageScript) Rebuild() {
 
 	r.Code = newCode
 	r.Header.CodeSize = int32(len(r.Code))
-	r.Entry.SetData(r.Bytes())
+	data, err := r.Bytes()
+	if err != nil {
+		return err
+	}
+	r.Entry.SetData(data)
+	return nil
+}
+
+// ReplaceOpcodes swaps in a new instruction listing, such as one produced
+// by the assembler, and rebuilds the script from it.
+func (r *RageScript) ReplaceOpcodes(ins []opcode.Instruction) error {
+	r.Opcodes = ins
+	return r.Rebuild()
 }
 
-func (r *RageScript) MoveInstruction(index int, offset int) {
+func (r *RageScript) MoveInstruction(index int, offset int) error {
 	if index < 0 || index >= len(r.Opcodes) {
-		fmt.Println("Error: MoveInstruction index out of bounds")
-		return
+		return &IndexError{Op: "move instruction", Index: index, Len: len(r.Opcodes)}
+	}
+	if offset < 0 || offset >= len(r.Opcodes) {
+		return &IndexError{Op: "move instruction", Index: offset, Len: len(r.Opcodes)}
 	}
 
 	ins := r.Opcodes[index]
 	r.Opcodes = append(r.Opcodes[:index], r.Opcodes[index+1:]...)
 	r.Opcodes = append(r.Opcodes[:offset], append([]opcode.Instruction{ins}, r.Opcodes[offset:]...)...)
 
-	r.Rebuild()
+	return r.Rebuild()
 }
 
-func (r *RageScript) DuplicateInstruction(index int) {
+func (r *RageScript) DuplicateInstruction(index int) error {
 	if index < 0 || index >= len(r.Opcodes) {
-		fmt.Println("Error: DuplicateInstruction index out of bounds")
-		return
+		return &IndexError{Op: "duplicate instruction", Index: index, Len: len(r.Opcodes)}
 	}
 
 	originalIns := r.Opcodes[index]
@@ -225,92 +314,88 @@ func (r *RageScript) DuplicateInstruction(index int) {
 	newArgs := make([]byte, len(originalArgs))
 	copy(newArgs, originalArgs)
 
-	var newIns opcode.Instruction
-	tempOffset := originalIns.GetOffset()
-
-	if originalOpcode > 0x4F && originalOpcode <= 0xFF {
-		newIns = opcode.NewPush(tempOffset, originalOpcode, newArgs)
-	} else {
-		f, ok := opcode.Instructions[originalOpcode]
-		if ok {
-			newIns = f(tempOffset, originalOpcode, newArgs)
-		} else {
-			newIns = opcode.NewInstruction(tempOffset, originalOpcode, newArgs)
-		}
-	}
+	newIns := opcode.NewInstruction(originalIns.GetOffset(), originalOpcode, newArgs)
 
 	r.Opcodes = append(r.Opcodes[:index+1], append([]opcode.Instruction{newIns}, r.Opcodes[index+1:]...)...)
 
-	r.Rebuild()
+	return r.Rebuild()
 }
 
-func (r *RageScript) EditInstruction(index int, newIns opcode.Instruction) {
+func (r *RageScript) EditInstruction(index int, newIns opcode.Instruction) error {
 	if index < 0 || index >= len(r.Opcodes) {
-		fmt.Println("Error: EditInstruction index out of bounds")
-		return
+		return &IndexError{Op: "edit instruction", Index: index, Len: len(r.Opcodes)}
+	}
+	if newIns == nil {
+		return ErrNilInstruction
 	}
 
 	r.Opcodes[index] = newIns
 
-	r.Rebuild()
+	return r.Rebuild()
 }
 
-func (r *RageScript) InsertInstruction(index int, newIns opcode.Instruction) {
+func (r *RageScript) InsertInstruction(index int, newIns opcode.Instruction) error {
 	if index < 0 || index > len(r.Opcodes) {
-		fmt.Println("Error: InsertInstruction index out of bounds")
-		return
+		return &IndexError{Op: "insert instruction", Index: index, Len: len(r.Opcodes)}
 	}
 
 	if newIns == nil {
-		fmt.Println("Error: InsertInstruction cannot insert a nil instruction")
-		return
+		return ErrNilInstruction
 	}
 
 	r.Opcodes = append(r.Opcodes[:index], append([]opcode.Instruction{newIns}, r.Opcodes[index:]...)...)
 
-	r.Rebuild()
+	return r.Rebuild()
 }
 
-func (r *RageScript) RemoveInstruction(index int) {
+func (r *RageScript) RemoveInstruction(index int) error {
 	if index < 0 || index >= len(r.Opcodes) {
-		fmt.Println("Error: RemoveInstruction index out of bounds")
-		return
+		return &IndexError{Op: "remove instruction", Index: index, Len: len(r.Opcodes)}
 	}
 
 	r.Opcodes = append(r.Opcodes[:index], r.Opcodes[index+1:]...)
 
-	r.Rebuild()
+	return r.Rebuild()
 }
 
-func (r *RageScript) Bytes() []byte {
-	headerBytes := r.Header.Bytes()
+// Bytes serialises the script, compressing and encrypting it again when the
+// header asks for it.
+func (r *RageScript) Bytes() ([]byte, error) {
+	// A script whose payload could not be decoded is written back untouched.
+	if r.Unsupported {
+		return r.Entry.ReadData()
+	}
 
-	currentCode := make([]byte, len(r.Code))
-	copy(currentCode, r.Code)
+	r.Header.LocalVarCount = int32(len(r.Locals))
+	r.Header.GlobalVarCount = int32(len(r.Globals))
 
-	needsEncryption := r.Header.Identifier == HEADER_MAGIC_ENCRYPTED || r.Header.Identifier == HEADER_MAGIC_ENCRYPTED_COMPRESSED
+	if r.Header.Identifier == HEADER_MAGIC_ENCRYPTED_COMPRESSED {
+		payload, err := compressPayload(r.Code, encodeVars(r.Locals), encodeVars(r.Globals))
+		if err != nil {
+			return nil, fmt.Errorf("compress script: %w", err)
+		}
+		r.Header.CompressedSize = int32(len(payload))
 
-	if needsEncryption {
-		util.Encrypt(currentCode)
+		var result []byte
+		result = append(result, r.Header.Bytes()...)
+		result = append(result, payload...)
+		return result, nil
 	}
 
-	// If the script was originally compressed (and is thus 'Unsupported' by current loading logic)
-	if r.Unsupported { // This implies r.Header.Identifier == HEADER_MAGIC_ENCRYPTED_COMPRESSED
-		// For compressed scripts, NewRageScript (if it didn't panic) would only provide decompressed code.
-		// Locals and globals are not parsed from the stream in that path.
-		// The output will be header + encrypted(decompressed_code).
-		// This will not match original CompressedSize, but it's the best representation possible.
-		panic("compressed")
-	}
+	headerBytes := r.Header.Bytes()
 
-	localsData := make([]byte, len(r.localBytes))
-	copy(localsData, r.localBytes)
-	globalsData := make([]byte, len(r.globalBytes))
-	copy(globalsData, r.globalBytes)
+	currentCode := make([]byte, len(r.Code))
+	copy(currentCode, r.Code)
+
+	localsData := encodeVars(r.Locals)
+	globalsData := encodeVars(r.Globals)
 
 	if r.Header.Identifier == HEADER_MAGIC_ENCRYPTED {
-		util.Encrypt(localsData)
-		util.Encrypt(globalsData)
+		for _, b := range [][]byte{currentCode, localsData, globalsData} {
+			if err := util.Encrypt(b); err != nil {
+				return nil, fmt.Errorf("encrypt script: %w", err)
+			}
+		}
 	}
 
 	var result []byte
@@ -318,7 +403,7 @@ func (r *RageScript) Bytes() []byte {
 	result = append(result, currentCode...)
 	result = append(result, localsData...)
 	result = append(result, globalsData...)
-	return result
+	return result, nil
 }
 
 func (r RageScript) GetOffset(line int) int {
@@ -387,56 +472,89 @@ func (r RageScript) String(y int, offset, height, marker1, marker2 int) string {
 		if i >= len(r.Opcodes) {
 			break
 		}
+		r.writeLine(&sb, i, y, i == marker1 || i == marker2 || (i > marker1 && i < marker2))
+	}
 
-		ins := r.Opcodes[i]
-
-		color := ""
-		if i == y {
-			color = "#FFFF00"
-		}
+	return sb.String()
+}
 
-		offsetStr := fmt.Sprintf("0x%04X ", ins.GetOffset())
+// DebugString renders the code like String with the instruction at pc
+// highlighted, a gutter marking breakpoints and the cursor line shown in
+// the marker color.
+func (r RageScript) DebugString(pc, cursor, offset, height int, breakpoints map[int]bool) string {
+	var sb strings.Builder
 
-		if i == marker1 || i == marker2 || (i > marker1 && i < marker2) {
-			offsetStr = markerStyle.Render(offsetStr)
+	for i := offset; i < offset+height; i++ {
+		if i >= len(r.Opcodes) {
+			break
 		}
-		sb.WriteString(offsetStr)
-
-		str := ins.String(color, r.Subroutines)
-		sb.WriteString(str)
-
-		if i == y {
-			style := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFF00"))
-			sb.WriteString(style.Render(" <-"))
-			if r.showBytecode {
-				strs := []string{fmt.Sprintf("%02X", ins.GetOpcode())}
-				for _, b := range ins.GetArgs() {
-					strs = append(strs, fmt.Sprintf("%02X", b))
-				}
-				sb.WriteString(style.Render(" [" + strings.Join(strs, " ") + "]"))
-			}
+		if breakpoints[i] {
+			sb.WriteString(breakpointStyle.Render("●"))
+		} else {
+			sb.WriteString(" ")
 		}
+		r.writeLine(&sb, i, pc, i == cursor)
+	}
 
-		context := ""
-		switch ins.(type) {
-		case *opcode.Branch:
-			style := lipgloss.NewStyle().Foreground(lipgloss.Color("#44FF44"))
-			context += style.Render("-> ")
-			next := "?"
-			for _, v := range r.Opcodes {
-				if v.GetOffset() == int(ins.GetOperands()[0].(uint32)) {
-					next = v.String("", r.Subroutines)
-					break
-				}
+	return sb.String()
+}
+
+func (r RageScript) writeLine(sb *strings.Builder, i, y int, marked bool) {
+	ins := r.Opcodes[i]
+
+	color := ""
+	if i == y {
+		color = "#FFFF00"
+	}
+
+	offsetStr := fmt.Sprintf("0x%04X ", ins.GetOffset())
+
+	if marked {
+		offsetStr = markerStyle.Render(offsetStr)
+	}
+	sb.WriteString(offsetStr)
+
+	str := ins.String(color, r.Subroutines)
+	sb.WriteString(str)
+
+	if i == y {
+		style := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFF00"))
+		sb.WriteString(style.Render(" <-"))
+		if r.showBytecode {
+			strs := []string{fmt.Sprintf("%02X", ins.GetOpcode())}
+			for _, b := range ins.GetArgs() {
+				strs = append(strs, fmt.Sprintf("%02X", b))
 			}
-			context += next
-		}
-		if context != "" {
-			sb.WriteString(" " + context)
+			sb.WriteString(style.Render(" [" + strings.Join(strs, " ") + "]"))
 		}
+	}
 
-		sb.WriteString("\n")
+	context := ""
+	arrow := lipgloss.NewStyle().Foreground(lipgloss.Color("#44FF44")).Render("-> ")
+	switch ins := ins.(type) {
+	case *opcode.Branch:
+		context += arrow + r.targetString(int(ins.GetOperands()[0].(uint32)))
+	case *opcode.Switch:
+		cases := make([]string, len(ins.Cases))
+		for j, c := range ins.Cases {
+			cases[j] = fmt.Sprintf("%d: %s", c.Value, r.targetString(int(c.Target)))
+		}
+		context += arrow + strings.Join(cases, " | ")
+	}
+	if context != "" {
+		sb.WriteString(" " + context)
 	}
 
-	return sb.String()
+	sb.WriteString("\n")
+}
+
+// targetString renders the instruction at a jump target, or "?" if no
+// instruction starts there.
+func (r RageScript) targetString(offset int) string {
+	for _, v := range r.Opcodes {
+		if v.GetOffset() == offset {
+			return v.String("", r.Subroutines)
+		}
+	}
+	return "?"
 }
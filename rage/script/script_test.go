@@ -0,0 +1,206 @@
+package script
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mrchip53/gta-tools/rage"
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+	"github.com/mrchip53/gta-tools/rage/util"
+)
+
+func newTestScript(t *testing.T, code []byte, locals, globals []uint) RageScript {
+	t.Helper()
+	return NewRageScript(rage.NewFileEntry("test.sco", PlainBytes(code, locals, globals)))
+}
+
+func TestRebuildRelocatesSwitchCases(t *testing.T) {
+	code := []byte{opcode.OP_FN_BEGIN, 0, 0, 0, 0x61}
+	code = append(code, opcode.OP_SWITCH, 2)
+	code = binary.LittleEndian.AppendUint32(code, 1)
+	code = binary.LittleEndian.AppendUint32(code, 0x17)
+	code = binary.LittleEndian.AppendUint32(code, 7)
+	code = binary.LittleEndian.AppendUint32(code, 0x18)
+	// 0x17
+	code = append(code, opcode.OP_ADD, opcode.OP_FN_END, 0, 0)
+
+	rs := newTestScript(t, code, []uint{0}, nil)
+	sw, ok := rs.Opcodes[2].(*opcode.Switch)
+	if !ok {
+		t.Fatalf("Expected a Switch, got %T", rs.Opcodes[2])
+	}
+	if len(sw.Cases) != 2 || sw.Cases[1].Value != 7 || sw.Cases[1].TargetInstruction != rs.Opcodes[4] {
+		t.Fatalf("Unexpected switch cases %+v", sw.Cases)
+	}
+
+	rs.InsertInstruction(1, opcode.NewInstruction(0, opcode.OP_PUSH, []byte{1, 0, 0, 0}))
+	if sw.Cases[0].Target != 0x1C || sw.Cases[1].Target != 0x1D {
+		t.Errorf("Switch targets not relocated: 0x%X 0x%X", sw.Cases[0].Target, sw.Cases[1].Target)
+	}
+
+	reloaded := NewRageScript(rs.Entry)
+	if reloaded.Opcodes[3].(*opcode.Switch).Cases[1].Target != 0x1D {
+		t.Errorf("Relocated switch was not written back")
+	}
+}
+
+func TestStaticsAreDecodedAndWrittenBack(t *testing.T) {
+	code := []byte{opcode.OP_FN_BEGIN, 0, 0, 0, opcode.OP_FN_END, 0, 0}
+	rs := newTestScript(t, code, []uint{1, 0x3FC00000}, []uint{42})
+	if len(rs.Locals) != 2 || rs.Locals[0] != 1 || len(rs.Globals) != 1 || rs.Globals[0] != 42 {
+		t.Fatalf("Unexpected statics %v %v", rs.Locals, rs.Globals)
+	}
+	if s := FormatVar(rs.Locals[1], true); s != "1.5f" {
+		t.Errorf("Expected 1.5f, got %s", s)
+	}
+
+	v, err := ParseVar("-2.25")
+	if err != nil {
+		t.Fatalf("Failed to parse float: %v", err)
+	}
+	rs.SetLocal(0, v)
+	rs.AddLocal(7)
+	rs.RemoveGlobal(0)
+
+	reloaded := NewRageScript(rs.Entry)
+	if reloaded.Header.LocalVarCount != 3 || reloaded.Header.GlobalVarCount != 0 {
+		t.Fatalf("Counts not updated: %+v", reloaded.Header)
+	}
+	if FormatVar(reloaded.Locals[0], true) != "-2.25f" || reloaded.Locals[2] != 7 {
+		t.Errorf("Unexpected locals after write back %v", reloaded.Locals)
+	}
+
+	var indexErr *IndexError
+	if err := rs.SetGlobal(0, 1); !errors.As(err, &indexErr) || indexErr.Len != 0 {
+		t.Errorf("Expected an IndexError for a missing global, got %v", err)
+	}
+	if err := rs.RemoveLocal(3); !errors.As(err, &indexErr) {
+		t.Errorf("Expected an IndexError for a missing local, got %v", err)
+	}
+}
+
+func TestCompressedScriptsRoundTrip(t *testing.T) {
+	util.SetAesKey(bytes.Repeat([]byte{0x5A}, 32))
+	defer util.SetAesKey(nil)
+
+	code := []byte{opcode.OP_FN_BEGIN, 0, 0, 0, opcode.OP_FN_END, 0, 0}
+	payload, err := compressPayload(code, encodeVars([]uint{1, 2}), encodeVars([]uint{3}))
+	if err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+	h := scriptHeader{
+		Identifier:     HEADER_MAGIC_ENCRYPTED_COMPRESSED,
+		CodeSize:       int32(len(code)),
+		LocalVarCount:  2,
+		GlobalVarCount: 1,
+		CompressedSize: int32(len(payload)),
+	}
+	rs := NewRageScript(rage.NewFileEntry("test.sco", append(h.Bytes(), payload...)))
+	if rs.Err != nil {
+		t.Fatalf("Failed to decompress: %v", rs.Err)
+	}
+	if !bytes.Equal(rs.Code, code) || len(rs.Locals) != 2 || rs.Locals[1] != 2 || len(rs.Globals) != 1 || rs.Globals[0] != 3 {
+		t.Fatalf("Unexpected payload %X %v %v", rs.Code, rs.Locals, rs.Globals)
+	}
+
+	if err := rs.InsertInstruction(1, opcode.NewInstruction(0, opcode.OP_ADD, nil)); err != nil {
+		t.Fatalf("Failed to write back: %v", err)
+	}
+	reloaded := NewRageScript(rs.Entry)
+	if reloaded.Err != nil || len(reloaded.Opcodes) != 3 || reloaded.Opcodes[1].GetOpcode() != opcode.OP_ADD {
+		t.Errorf("Edit did not survive recompression: %v %v", reloaded.Err, reloaded.Opcodes)
+	}
+
+	util.SetAesKey(nil)
+	if rs := NewRageScript(rs.Entry); !rs.Unsupported || !errors.Is(rs.Err, util.ErrAesKeyNotSet) {
+		t.Errorf("Expected ErrAesKeyNotSet without a key, got %v", rs.Err)
+	}
+	if _, err := reloaded.Bytes(); !errors.Is(err, util.ErrAesKeyNotSet) {
+		t.Errorf("Expected writing without a key to fail, got %v", err)
+	}
+}
+
+func TestTruncatedScriptsReturnErrors(t *testing.T) {
+	data, _ := newTestScript(t, []byte{opcode.OP_FN_BEGIN, 0, 0, 0, opcode.OP_PUSH, 1}, nil, nil).Entry.ReadData()
+
+	var headerErr *HeaderError
+	if rs := NewRageScript(rage.NewFileEntry("test.sco", data[:10])); !errors.As(rs.Err, &headerErr) || !rs.Unsupported {
+		t.Errorf("Expected a HeaderError for a short header, got %v", rs.Err)
+	}
+	if rs := NewRageScript(rage.NewFileEntry("test.sco", data[:26])); !errors.As(rs.Err, &headerErr) || headerErr.Field != "CodeSize" {
+		t.Errorf("Expected a HeaderError for missing code, got %v", rs.Err)
+	}
+	var codeErr *CodeError
+	if rs := NewRageScript(rage.NewFileEntry("test.sco", data)); !errors.As(rs.Err, &codeErr) || codeErr.Offset != 4 {
+		t.Errorf("Expected a CodeError at 0x04 for a cut off Push, got %v", rs.Err)
+	}
+}
+
+func TestEditsReturnIndexErrors(t *testing.T) {
+	rs := newTestScript(t, []byte{opcode.OP_FN_BEGIN, 0, 0, 0, opcode.OP_FN_END, 0, 0}, nil, nil)
+
+	var indexErr *IndexError
+	if err := rs.MoveInstruction(1, 2); !errors.As(err, &indexErr) || indexErr.Index != 2 {
+		t.Errorf("Expected an IndexError for moving past the end, got %v", err)
+	}
+	if err := rs.RemoveInstruction(-1); !errors.As(err, &indexErr) {
+		t.Errorf("Expected an IndexError for a negative index, got %v", err)
+	}
+	if err := rs.InsertInstruction(0, nil); !errors.Is(err, ErrNilInstruction) {
+		t.Errorf("Expected ErrNilInstruction, got %v", err)
+	}
+	if len(rs.Opcodes) != 2 {
+		t.Errorf("Expected failed edits to leave 2 instructions, got %d", len(rs.Opcodes))
+	}
+	if err := rs.MoveInstruction(1, 0); err != nil || rs.Opcodes[0].GetOpcode() != opcode.OP_FN_END {
+		t.Errorf("Failed to move instruction: %v", err)
+	}
+}
+
+func TestCheckRejectsMalformedScripts(t *testing.T) {
+	s := newTestScript(t, []byte{opcode.OP_FN_BEGIN, 0, 0, 0, opcode.OP_FN_END, 0, 0}, nil, nil)
+	if err := Check(s.Entry); err != nil {
+		t.Errorf("Expected a valid script to pass, got %v", err)
+	}
+	data, _ := s.Entry.ReadData()
+	if err := Check(rage.NewFileEntry("short.sco", data[:8])); err == nil {
+		t.Errorf("Expected an error for a truncated script")
+	}
+	binary.LittleEndian.PutUint32(data[4:8], 0x1000)
+	var headerErr *HeaderError
+	if err := Check(rage.NewFileEntry("long.sco", data)); !errors.As(err, &headerErr) {
+		t.Errorf("Expected a HeaderError for a code size past the end of the data, got %v", err)
+	}
+
+	data, _ = s.Entry.ReadData()
+	binary.LittleEndian.PutUint32(data[0:4], 0x12345678)
+	if rs := NewRageScript(rage.NewFileEntry("magic.sco", data)); !rs.Unsupported || !errors.As(rs.Err, &headerErr) || headerErr.Field != "Identifier" {
+		t.Errorf("Expected an unsupported script with a bad magic HeaderError, got %v", rs.Err)
+	}
+
+	binary.LittleEndian.PutUint32(data[0:4], HEADER_MAGIC_ENCRYPTED)
+	if err := Check(rage.NewFileEntry("encrypted.sco", data)); !errors.Is(err, util.ErrAesKeyNotSet) {
+		t.Errorf("Expected ErrAesKeyNotSet for an encrypted script without a key, got %v", err)
+	}
+}
+
+func TestDebugStringMarksBreakpointsAndPC(t *testing.T) {
+	code := []byte{opcode.OP_FN_BEGIN, 0, 0, 0, opcode.OP_ADD, opcode.OP_FN_END, 0, 0}
+	rs := newTestScript(t, code, nil, nil)
+	lines := strings.Split(strings.TrimSuffix(rs.DebugString(1, 2, 0, 10, map[int]bool{2: true}), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected 3 lines, got %q", lines)
+	}
+	if !strings.HasPrefix(lines[0], " 0x0000") {
+		t.Errorf("Expected an empty gutter, got %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "<-") || strings.Contains(lines[0], "<-") {
+		t.Errorf("Expected the PC on line 1, got %q", lines)
+	}
+	if !strings.Contains(lines[2], "●") {
+		t.Errorf("Expected a breakpoint on line 2, got %q", lines[2])
+	}
+}
@@ -0,0 +1,287 @@
+package script
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+// Kinds of problems found by VerifyStack.
+const (
+	STACK_UNDERFLOW = "underflow"
+	STACK_MISMATCH  = "mismatch"
+	STACK_RETURN    = "return"
+	STACK_UNKNOWN   = "unknown"
+	STACK_NATIVE    = "native"
+)
+
+// StackIssue is a problem with the operand stack at the instruction at
+// Index.
+type StackIssue struct {
+	Index   int
+	Offset  int
+	Kind    string
+	Message string
+}
+
+func (i StackIssue) String() string {
+	return fmt.Sprintf("0x%04X %s: %s", i.Offset, i.Kind, i.Message)
+}
+
+// stackValue is an abstract stack slot. Constants are tracked so the
+// counts popped by ArrayExplode and ArrayImplode can be known.
+type stackValue struct {
+	known bool
+	value int
+}
+
+// stackSignature is the number of values a subroutine pops and pushes.
+type stackSignature struct {
+	params  int
+	returns int
+}
+
+// stackVerifier walks the blocks of one subroutine at a time, tracking the
+// depth of the operand stack above what the caller left.
+type stackVerifier struct {
+	r      *RageScript
+	sigs   map[int]stackSignature
+	issues []StackIssue
+	seen   map[StackIssue]bool
+}
+
+// VerifyStack walks every path through every subroutine and reports
+// instructions that pop more than is on the stack, blocks reached with
+// different stack depths, FnEnds that do not match the stack or the
+// subroutine's FnBegin and native calls that disagree with the signature
+// in opcode.Natives. Issues are sorted by index.
+func (r *RageScript) VerifyStack() []StackIssue {
+	v := &stackVerifier{r: r, sigs: stackSignatures(r), seen: make(map[StackIssue]bool)}
+	for _, f := range r.Functions() {
+		v.function(f)
+	}
+	sort.SliceStable(v.issues, func(i, j int) bool { return v.issues[i].Index < v.issues[j].Index })
+	return v.issues
+}
+
+// stackSignatures reads the parameter count of every FnBegin and the
+// return count of the first FnEnd after it.
+func stackSignatures(r *RageScript) map[int]stackSignature {
+	sigs := make(map[int]stackSignature)
+	cur, returned := -1, false
+	for _, ins := range r.Opcodes {
+		switch ins.GetOpcode() {
+		case opcode.OP_FN_BEGIN:
+			cur, returned = ins.GetOffset(), false
+			sigs[cur] = stackSignature{params: int(ins.GetOperands()[0].(uint8))}
+		case opcode.OP_FN_END:
+			if cur >= 0 && !returned {
+				s := sigs[cur]
+				s.returns = int(ins.GetOperands()[1].(uint8))
+				sigs[cur] = s
+				returned = true
+			}
+		}
+	}
+	return sigs
+}
+
+func (v *stackVerifier) report(index int, kind, format string, a ...any) {
+	issue := StackIssue{
+		Index:   index,
+		Offset:  v.r.Opcodes[index].GetOffset(),
+		Kind:    kind,
+		Message: fmt.Sprintf(format, a...),
+	}
+	if !v.seen[issue] {
+		v.seen[issue] = true
+		v.issues = append(v.issues, issue)
+	}
+}
+
+func (v *stackVerifier) function(f *Function) {
+	sig := v.sigs[f.Offset]
+	byOffset := make(map[int]*BasicBlock, len(f.Blocks))
+	for _, b := range f.Blocks {
+		byOffset[b.Offset()] = b
+	}
+
+	depthAt := map[*BasicBlock]int{f.Entry(): 0}
+	from := map[*BasicBlock]int{f.Entry(): f.Start}
+	work := []*BasicBlock{f.Entry()}
+	for len(work) > 0 {
+		b := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		succs, depth, ok := v.block(f, b, depthAt[b], sig, byOffset)
+		if !ok {
+			continue
+		}
+		for _, s := range succs {
+			d, visited := depthAt[s]
+			if !visited {
+				depthAt[s] = depth
+				from[s] = b.End - 1
+				work = append(work, s)
+				continue
+			}
+			if d != depth {
+				v.report(s.Start, STACK_MISMATCH, "reached with %d values from 0x%04X and %d from 0x%04X",
+					depth, v.r.Opcodes[b.End-1].GetOffset(), d, v.r.Opcodes[from[s]].GetOffset())
+			}
+		}
+	}
+}
+
+// block simulates b from depth and returns the blocks control may pass
+// to with the depth they start at. ok is false when the path ends in b.
+func (v *stackVerifier) block(f *Function, b *BasicBlock, depth int, sig stackSignature, byOffset map[int]*BasicBlock) ([]*BasicBlock, int, bool) {
+	stack := make([]stackValue, depth)
+	pop := func(index, n int) []stackValue {
+		if n > len(stack) {
+			v.report(index, STACK_UNDERFLOW, "pops %d values with %d on the stack", n, len(stack))
+			n = len(stack)
+		}
+		vals := stack[len(stack)-n:]
+		stack = stack[:len(stack)-n]
+		return vals
+	}
+	push := func(n int) {
+		for range n {
+			stack = append(stack, stackValue{})
+		}
+	}
+
+	for i := b.Start; i < b.End; i++ {
+		ins := v.r.Opcodes[i]
+		op := ins.GetOpcode()
+
+		if c, ok := PushedConstant(ins); ok {
+			stack = append(stack, stackValue{known: true, value: c})
+			continue
+		}
+
+		switch op {
+		case opcode.OP_FN_BEGIN:
+			if i != f.Start {
+				v.report(i, STACK_UNKNOWN, "FnBegin inside a subroutine")
+			}
+		case opcode.OP_FN_END:
+			ops := ins.GetOperands()
+			params, returns := int(ops[0].(uint8)), int(ops[1].(uint8))
+			if params != sig.params {
+				v.report(i, STACK_RETURN, "pops %d parameters but FnBegin takes %d", params, sig.params)
+			}
+			if returns != sig.returns {
+				v.report(i, STACK_RETURN, "returns %d values but the first FnEnd returns %d", returns, sig.returns)
+			}
+			if len(stack) != returns {
+				v.report(i, STACK_RETURN, "returns %d values with %d on the stack", returns, len(stack))
+			}
+			return nil, 0, false
+		case opcode.OP_THROW:
+			pop(i, 1)
+			return nil, 0, false
+		case opcode.OP_ABORT_79:
+			return nil, 0, false
+		case opcode.OP_DUP:
+			vals := pop(i, 1)
+			if len(vals) == 1 {
+				stack = append(stack, vals[0], vals[0])
+			} else {
+				push(2)
+			}
+		case opcode.OP_CALL_NATIVE:
+			args := ins.GetArgs()
+			if n, ok := opcode.Natives.Lookup(binary.LittleEndian.Uint32(args[2:6])); ok {
+				if err := n.CheckCall(int(args[0]), int(args[1])); err != nil {
+					v.report(i, STACK_NATIVE, "%v", err)
+				}
+			}
+			pop(i, int(args[0]))
+			push(int(args[1]))
+		case opcode.OP_CALL:
+			target := int(ins.GetOperands()[0].(uint32))
+			s, ok := v.sigs[target]
+			if !ok {
+				v.report(i, STACK_UNKNOWN, "call to 0x%04X, which is not a subroutine", target)
+				return nil, 0, false
+			}
+			pop(i, s.params)
+			push(s.returns)
+		case opcode.OP_ARRAY_EXPLODE, opcode.OP_ARRAY_IMPLODE:
+			vals := pop(i, 2)
+			if len(vals) < 2 || !vals[0].known || vals[0].value < 0 {
+				v.report(i, STACK_UNKNOWN, "element count is not a constant")
+				return nil, 0, false
+			}
+			if op == opcode.OP_ARRAY_EXPLODE {
+				push(vals[0].value)
+			} else {
+				pop(i, vals[0].value)
+			}
+		default:
+			pops, pushes := stackEffect(op)
+			pop(i, pops)
+			push(pushes)
+		}
+	}
+
+	last := b.Last()
+	var succs []*BasicBlock
+	for _, t := range BranchTargets(last) {
+		s, ok := byOffset[t]
+		if !ok {
+			v.report(b.End-1, STACK_UNKNOWN, "jumps to 0x%04X outside the subroutine", t)
+			continue
+		}
+		succs = append(succs, s)
+	}
+	if !IsTerminator(last) {
+		if b.End == f.End {
+			v.report(b.End-1, STACK_RETURN, "runs past the end of the subroutine without FnEnd")
+		} else {
+			succs = append(succs, f.BlockAt(b.End))
+		}
+	}
+	return succs, len(stack), true
+}
+
+// stackEffect returns how many values the instructions with a fixed
+// effect pop and push.
+func stackEffect(op uint8) (pops, pushes int) {
+	switch op {
+	case opcode.OP_ADD, opcode.OP_SUB, opcode.OP_MUL, opcode.OP_DIV, opcode.OP_MOD,
+		opcode.OP_AND, opcode.OP_OR, opcode.OP_XOR,
+		opcode.OP_CMP_EQ, opcode.OP_CMP_NE, opcode.OP_CMP_GT, opcode.OP_CMP_GE, opcode.OP_CMP_LT, opcode.OP_CMP_LE,
+		opcode.OP_ADDF, opcode.OP_SUBF, opcode.OP_MULF, opcode.OP_DIVF, opcode.OP_MODF,
+		opcode.OP_CMP_EQF, opcode.OP_CMP_NEF, opcode.OP_CMP_GTF, opcode.OP_CMP_GEF, opcode.OP_CMP_LTF, opcode.OP_CMP_LEF:
+		return 2, 1
+	case opcode.OP_ADD_VEC, opcode.OP_SUB_VEC, opcode.OP_MUL_VEC, opcode.OP_DIV_VEC:
+		return 6, 3
+	case opcode.OP_NEG_VEC:
+		return 3, 3
+	case opcode.OP_IS_ZERO, opcode.OP_NEG, opcode.OP_NEGF, opcode.OP_TO_F, opcode.OP_FROM_F,
+		opcode.OP_REF_GET, opcode.OP_VAR, opcode.OP_LOCAL_VAR, opcode.OP_GLOBAL_VAR:
+		return 1, 1
+	case opcode.OP_VEC_FROM_F:
+		return 1, 3
+	case opcode.OP_JUMP_FALSE, opcode.OP_JUMP_TRUE, opcode.OP_SWITCH, opcode.OP_POP:
+		return 1, 0
+	case opcode.OP_PUSHF, opcode.OP_PUSH_STRING, opcode.OP_NULL_OBJ, opcode.OP_CATCH,
+		opcode.OP_VAR0, opcode.OP_VAR1, opcode.OP_VAR2, opcode.OP_VAR3,
+		opcode.OP_VAR4, opcode.OP_VAR5, opcode.OP_VAR6, opcode.OP_VAR7:
+		return 0, 1
+	case opcode.OP_REF_SET, opcode.OP_STR_CPY, opcode.OP_INT_TO_STR, opcode.OP_STR_CAT, opcode.OP_STR_CAT_I:
+		return 2, 0
+	case opcode.OP_REF_PEEK_SET:
+		return 2, 1
+	case opcode.OP_ARRAY_REF:
+		return 3, 1
+	case opcode.OP_STR_VAR_CPY:
+		return 3, 0
+	}
+	return 0, 0
+}
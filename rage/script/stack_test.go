@@ -0,0 +1,74 @@
+package script
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+// balancedCode calls a two parameter subroutine and WAIT on one branch.
+func balancedCode() []byte {
+	var code []byte
+	code = append(code, opcode.OP_FN_BEGIN, 0, 0, 0) // 0x00
+	code = append(code, 0x61, 0x63, opcode.OP_CALL)  // 0x04
+	code = binary.LittleEndian.AppendUint32(code, 0x1C)
+	code = append(code, opcode.OP_JUMP_FALSE) // 0x0B
+	code = binary.LittleEndian.AppendUint32(code, 0x18)
+	code = append(code, 0x62, opcode.OP_CALL_NATIVE, 1, 0) // 0x10
+	code = binary.LittleEndian.AppendUint32(code, 644290220)
+	code = append(code, 0x62, opcode.OP_FN_END, 0, 1) // 0x18
+	// 0x1C: explodes a three element array and sums it with the params
+	code = append(code, opcode.OP_FN_BEGIN, 2, 2, 0)
+	code = append(code, 0x63, opcode.OP_VAR0, opcode.OP_ARRAY_EXPLODE, opcode.OP_ADD, opcode.OP_ADD)
+	code = append(code, opcode.OP_FN_END, 2, 1)
+	return code
+}
+
+func findStackIssue(issues []StackIssue, index int, kind string) bool {
+	for _, i := range issues {
+		if i.Index == index && i.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func TestVerifyStackAcceptsBalancedCode(t *testing.T) {
+	rs := newTestScript(t, balancedCode(), nil, nil)
+	if issues := rs.VerifyStack(); len(issues) != 0 {
+		t.Fatalf("Expected no issues, got %v", issues)
+	}
+}
+
+func TestVerifyStackReportsUnbalancedEdits(t *testing.T) {
+	rs := newTestScript(t, balancedCode(), nil, nil)
+	rs.RemoveInstruction(1)
+	if issues := rs.VerifyStack(); !findStackIssue(issues, 2, STACK_UNDERFLOW) {
+		t.Errorf("Expected an underflow at the call, got %v", issues)
+	}
+
+	rs = newTestScript(t, balancedCode(), nil, nil)
+	rs.RemoveInstruction(7)
+	if issues := rs.VerifyStack(); !findStackIssue(issues, 7, STACK_RETURN) {
+		t.Errorf("Expected a bad return, got %v", issues)
+	}
+
+	rs = newTestScript(t, balancedCode(), nil, nil)
+	rs.RemoveInstruction(6)
+	if issues := rs.VerifyStack(); !findStackIssue(issues, 6, STACK_MISMATCH) {
+		t.Errorf("Expected a depth mismatch where the branches join, got %v", issues)
+	}
+
+	rs = newTestScript(t, balancedCode(), nil, nil)
+	rs.EditInstruction(15, opcode.NewInstruction(0, opcode.OP_FN_END, []byte{1, 1}))
+	if issues := rs.VerifyStack(); len(issues) != 1 || !findStackIssue(issues, 15, STACK_RETURN) {
+		t.Errorf("Expected a parameter count mismatch, got %v", issues)
+	}
+
+	rs = newTestScript(t, balancedCode(), nil, nil)
+	rs.EditInstruction(6, opcode.NewInstruction(0, opcode.OP_CALL_NATIVE, []byte{1, 1, 0xAC, 0x16, 0x67, 0x26}))
+	if issues := rs.VerifyStack(); !findStackIssue(issues, 6, STACK_NATIVE) {
+		t.Errorf("Expected WAIT with out=1 to be flagged, got %v", issues)
+	}
+}
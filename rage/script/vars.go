@@ -0,0 +1,111 @@
+package script
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func decodeVars(b []byte) []uint {
+	vars := make([]uint, len(b)/4)
+	for i := range vars {
+		vars[i] = uint(binary.LittleEndian.Uint32(b[i*4 : i*4+4]))
+	}
+	return vars
+}
+
+func encodeVars(vars []uint) []byte {
+	b := make([]byte, 0, len(vars)*4)
+	for _, v := range vars {
+		b = binary.LittleEndian.AppendUint32(b, uint32(v))
+	}
+	return b
+}
+
+// FormatVar renders a static slot as an integer, or as a float when asFloat
+// is set.
+func FormatVar(v uint, asFloat bool) string {
+	if asFloat {
+		return strconv.FormatFloat(float64(math.Float32frombits(uint32(v))), 'g', -1, 32) + "f"
+	}
+	return strconv.FormatInt(int64(int32(v)), 10)
+}
+
+// FormatStatic renders a static slot of a diff as an integer, or "-" for a
+// slot missing from one side.
+func FormatStatic(v *uint) string {
+	if v == nil {
+		return "-"
+	}
+	return FormatVar(*v, false)
+}
+
+// ParseVar reads a slot value written as a decimal or 0x prefixed integer,
+// or as a float when it contains a '.' or ends in 'f'.
+func ParseVar(s string) (uint, error) {
+	s = strings.TrimSpace(s)
+	isHex := strings.HasPrefix(strings.ToLower(s), "0x")
+	if !isHex && (strings.ContainsAny(s, ".eE") || strings.HasSuffix(s, "f")) {
+		f, err := strconv.ParseFloat(strings.TrimSuffix(s, "f"), 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid float %q", s)
+		}
+		return uint(math.Float32bits(float32(f))), nil
+	}
+	if v, err := strconv.ParseInt(s, 0, 32); err == nil {
+		return uint(uint32(v)), nil
+	}
+	v, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer %q", s)
+	}
+	return uint(v), nil
+}
+
+func (r *RageScript) SetLocal(index int, value uint) error {
+	if index < 0 || index >= len(r.Locals) {
+		return &IndexError{Op: "set local", Index: index, Len: len(r.Locals)}
+	}
+	r.Locals[index] = value
+	return r.Rebuild()
+}
+
+func (r *RageScript) SetGlobal(index int, value uint) error {
+	if index < 0 || index >= len(r.Globals) {
+		return &IndexError{Op: "set global", Index: index, Len: len(r.Globals)}
+	}
+	r.Globals[index] = value
+	return r.Rebuild()
+}
+
+func (r *RageScript) AddLocal(value uint) error {
+	r.Locals = append(r.Locals, value)
+	return r.Rebuild()
+}
+
+func (r *RageScript) AddGlobal(value uint) error {
+	r.Globals = append(r.Globals, value)
+	return r.Rebuild()
+}
+
+// RemoveLocal deletes a static slot. Code referring to later slots by index
+// is not renumbered.
+func (r *RageScript) RemoveLocal(index int) error {
+	if index < 0 || index >= len(r.Locals) {
+		return &IndexError{Op: "remove local", Index: index, Len: len(r.Locals)}
+	}
+	r.Locals = append(r.Locals[:index], r.Locals[index+1:]...)
+	return r.Rebuild()
+}
+
+// RemoveGlobal deletes a global slot. Code referring to later slots by
+// index is not renumbered.
+func (r *RageScript) RemoveGlobal(index int) error {
+	if index < 0 || index >= len(r.Globals) {
+		return &IndexError{Op: "remove global", Index: index, Len: len(r.Globals)}
+	}
+	r.Globals = append(r.Globals[:index], r.Globals[index+1:]...)
+	return r.Rebuild()
+}
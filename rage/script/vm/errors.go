@@ -0,0 +1,42 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+var (
+	// ErrHalted is returned by Step once the entry subroutine has returned.
+	ErrHalted = errors.New("vm: machine has halted")
+
+	// ErrStepLimit is returned by Run when MaxSteps instructions ran without
+	// the entry subroutine returning.
+	ErrStepLimit = errors.New("vm: step limit reached")
+)
+
+// ExecError reports an instruction that could not be executed.
+type ExecError struct {
+	Offset int
+	Opcode uint8
+	Reason string
+}
+
+func (e *ExecError) Error() string {
+	name := opcode.Names[e.Opcode]
+	if e.Opcode > opcode.OP_ABORT_79 {
+		name = opcode.Names[opcode.OP_PUSHD]
+	}
+	return fmt.Sprintf("vm: 0x%04X %s: %s", e.Offset, name, e.Reason)
+}
+
+// ThrowError reports a Throw that no Catch handled.
+type ThrowError struct {
+	Offset int
+	Value  uint32
+}
+
+func (e *ThrowError) Error() string {
+	return fmt.Sprintf("vm: 0x%04X uncaught throw of %d", e.Offset, int32(e.Value))
+}
@@ -0,0 +1,164 @@
+package vm
+
+import (
+	"fmt"
+)
+
+// Pointers are 32 bit values with the memory region in the top four bits
+// and a byte offset into the region below. Zero is the null pointer.
+const (
+	RegionFrame  = 1
+	RegionStatic = 2
+	RegionGlobal = 3
+	RegionString = 4
+
+	regionShift = 28
+	offsetMask  = 1<<regionShift - 1
+)
+
+// Pointer returns the address of byte offset in a region.
+func Pointer(region, offset int) uint32 {
+	return uint32(region)<<regionShift | uint32(offset)&offsetMask
+}
+
+// SplitPointer returns the region and byte offset of a pointer.
+func SplitPointer(p uint32) (region, offset int) {
+	return int(p >> regionShift), int(p & offsetMask)
+}
+
+// words returns the word storage of a region, or nil for regions that are
+// not word addressed.
+func (m *Machine) words(region int) []uint32 {
+	switch region {
+	case RegionFrame:
+		return m.frameMem
+	case RegionStatic:
+		return m.Statics
+	case RegionGlobal:
+		return m.Globals
+	}
+	return nil
+}
+
+// Load reads the word at p.
+func (m *Machine) Load(p uint32) (uint32, error) {
+	if region, _ := SplitPointer(p); region == RegionString {
+		var v uint32
+		for i := range 4 {
+			b, err := m.LoadByte(p + uint32(i))
+			if err != nil {
+				return 0, err
+			}
+			v |= uint32(b) << (8 * i)
+		}
+		return v, nil
+	}
+	w, err := m.wordAt(p)
+	if err != nil {
+		return 0, err
+	}
+	return *w, nil
+}
+
+// Store writes the word v at p.
+func (m *Machine) Store(p, v uint32) error {
+	w, err := m.wordAt(p)
+	if err != nil {
+		return err
+	}
+	*w = v
+	return nil
+}
+
+func (m *Machine) wordAt(p uint32) (*uint32, error) {
+	region, off := SplitPointer(p)
+	mem := m.words(region)
+	if mem == nil {
+		return nil, fmt.Errorf("invalid pointer 0x%08X", p)
+	}
+	if off%4 != 0 {
+		return nil, fmt.Errorf("unaligned pointer 0x%08X", p)
+	}
+	if off/4 >= len(mem) {
+		return nil, fmt.Errorf("pointer 0x%08X out of range", p)
+	}
+	return &mem[off/4], nil
+}
+
+// LoadByte reads the byte at p. Words are little endian.
+func (m *Machine) LoadByte(p uint32) (byte, error) {
+	region, off := SplitPointer(p)
+	if region == RegionString {
+		if off >= len(m.strings) {
+			return 0, fmt.Errorf("pointer 0x%08X out of range", p)
+		}
+		return m.strings[off], nil
+	}
+	w, err := m.wordAt(p &^ 3)
+	if err != nil {
+		return 0, err
+	}
+	return byte(*w >> (8 * (off % 4))), nil
+}
+
+// StoreByte writes the byte b at p. String literals are read only.
+func (m *Machine) StoreByte(p uint32, b byte) error {
+	region, off := SplitPointer(p)
+	if region == RegionString {
+		return fmt.Errorf("write to string literal 0x%08X", p)
+	}
+	w, err := m.wordAt(p &^ 3)
+	if err != nil {
+		return err
+	}
+	shift := 8 * (off % 4)
+	*w = *w&^(0xFF<<shift) | uint32(b)<<shift
+	return nil
+}
+
+// String reads the NUL terminated string at p.
+func (m *Machine) String(p uint32) (string, error) {
+	var s []byte
+	for {
+		b, err := m.LoadByte(p + uint32(len(s)))
+		if err != nil {
+			return "", err
+		}
+		if b == 0 {
+			return string(s), nil
+		}
+		s = append(s, b)
+	}
+}
+
+// storeString writes s to the size byte buffer at p, truncating it to
+// leave room for the terminator.
+func (m *Machine) storeString(p uint32, s string, size int) error {
+	if size <= 0 {
+		return nil
+	}
+	if len(s) > size-1 {
+		s = s[:size-1]
+	}
+	for i := range len(s) {
+		if err := m.StoreByte(p+uint32(i), s[i]); err != nil {
+			return err
+		}
+	}
+	return m.StoreByte(p+uint32(len(s)), 0)
+}
+
+// literal returns a pointer to the string pushed by the PushString at
+// offset, adding it to the string region the first time.
+func (m *Machine) literal(offset int, s string) uint32 {
+	if p, ok := m.literals[offset]; ok {
+		return p
+	}
+	p := Pointer(RegionString, len(m.strings))
+	m.strings = append(m.strings, s...)
+	if len(s) == 0 || s[len(s)-1] != 0 {
+		m.strings = append(m.strings, 0)
+	}
+	m.literals[offset] = p
+	return p
+}
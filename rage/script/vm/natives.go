@@ -0,0 +1,110 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+// NativeFunc implements a native. It gets the arguments in the order they
+// were pushed and returns the out values to push, which Run pads with
+// zeros or truncates to the count the CallNative asks for.
+type NativeFunc func(m *Machine, args []uint32) ([]uint32, error)
+
+// NativeCall records one CallNative as it was executed.
+type NativeCall struct {
+	Offset  int
+	Hash    uint32
+	Name    string
+	Args    []uint32
+	Results []uint32
+}
+
+// String renders the call with its arguments named and formatted after
+// the native's signature when it is known.
+func (c NativeCall) String() string {
+	n, _ := opcode.Natives.Lookup(c.Hash)
+	typed := n.Typed && len(n.Params) == len(c.Args)
+	args := make([]string, len(c.Args))
+	for i, v := range c.Args {
+		if typed {
+			args[i] = n.Params[i].Name + ": " + formatValue(v, n.Params[i].Type)
+		} else {
+			args[i] = formatValue(v, "")
+		}
+	}
+	results := make([]string, len(c.Results))
+	for i, v := range c.Results {
+		ret := ""
+		if n.Typed {
+			ret = n.Return
+		}
+		results[i] = formatValue(v, ret)
+	}
+	return fmt.Sprintf("%s(%s) -> [%s]", c.Name, strings.Join(args, ", "), strings.Join(results, ", "))
+}
+
+// formatValue renders a stack value as the native type typ: floats as
+// floats, pointers and strings as addresses and anything else as an int.
+func formatValue(v uint32, typ string) string {
+	switch {
+	case typ == "float":
+		return strconv.FormatFloat(float64(math.Float32frombits(v)), 'g', -1, 32)
+	case typ == "string" || strings.HasSuffix(typ, "*"):
+		return fmt.Sprintf("0x%08X", v)
+	}
+	return strconv.Itoa(int(int32(v)))
+}
+
+// Stub is the default native: it ignores its arguments and returns zeros.
+func Stub(m *Machine, args []uint32) ([]uint32, error) { return nil, nil }
+
+// RegisterNative sets the handler for a native named in opcode.Natives.
+func (m *Machine) RegisterNative(name string, f NativeFunc) error {
+	hash, ok := opcode.NativeHash(name)
+	if !ok {
+		return fmt.Errorf("vm: unknown native %s", name)
+	}
+	m.Natives[hash] = f
+	return nil
+}
+
+// callNative runs the native with the given hash on in popped arguments
+// and pushes out results.
+func (m *Machine) callNative(offset int, hash uint32, in, out int) error {
+	args, err := m.popN(in)
+	if err != nil {
+		return err
+	}
+	name, ok := opcode.NativeName(hash)
+	if !ok {
+		name = fmt.Sprintf("NATIVE_0x%08X", hash)
+	}
+
+	f := m.Natives[hash]
+	if f == nil {
+		f = m.DefaultNative
+	}
+	if f == nil {
+		f = Stub
+	}
+	res, err := f(m, args)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	results := make([]uint32, out)
+	copy(results, res)
+
+	if m.OnNative != nil {
+		m.OnNative(NativeCall{Offset: offset, Hash: hash, Name: name, Args: args, Results: results})
+	}
+	for _, v := range results {
+		if err := m.push(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,712 @@
+// Package vm executes RAGE script bytecode. It models the operand stack,
+// subroutine frames, statics, globals and strings closely enough to run
+// subroutines offline; natives go through a handler table keyed by their
+// hash and do nothing by default.
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/mrchip53/gta-tools/rage/script"
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+const (
+	// DefaultMaxSteps bounds Run so a script that never returns, for
+	// example a main loop around WAIT, cannot hang the caller.
+	DefaultMaxSteps = 1_000_000
+
+	// DefaultMaxStack is the most values the operand stack may hold.
+	DefaultMaxStack = 1 << 16
+)
+
+// Frame is an active subroutine call. Its variables are Size words of
+// frame memory from Base, the parameters first.
+type Frame struct {
+	Function int // code offset of the subroutine's FnBegin
+	ReturnPC int // instruction index to resume at, -1 for the entry call
+	Base     int
+	Size     int
+	Params   int
+	entered  bool
+}
+
+// handler is a Catch waiting for a Throw.
+type handler struct {
+	frames int
+	stack  int
+	pc     int
+}
+
+// Machine runs the code of one script. PC is the index in Script.Opcodes
+// of the next instruction.
+type Machine struct {
+	Script  *script.RageScript
+	PC      int
+	Stack   []uint32
+	Frames  []Frame
+	Statics []uint32
+	Globals []uint32
+	Halted  bool
+	Steps   int
+
+	// Natives maps native hashes to their handlers. Natives without one go
+	// to DefaultNative, or Stub if that is nil.
+	Natives       map[uint32]NativeFunc
+	DefaultNative NativeFunc
+	// OnNative, if set, is told about every native call.
+	OnNative func(NativeCall)
+
+	MaxSteps int
+	MaxStack int
+
+	frameMem []uint32
+	strings  []byte
+	literals map[int]uint32
+	handlers []handler
+	indexOf  map[int]int
+}
+
+// New returns a machine for s with its statics and globals set to the
+// values stored in the script.
+func New(s *script.RageScript) *Machine {
+	m := &Machine{
+		Script:   s,
+		Natives:  make(map[uint32]NativeFunc),
+		MaxSteps: DefaultMaxSteps,
+		MaxStack: DefaultMaxStack,
+		literals: make(map[int]uint32),
+		indexOf:  make(map[int]int, len(s.Opcodes)),
+		Halted:   true,
+	}
+	for i, ins := range s.Opcodes {
+		m.indexOf[ins.GetOffset()] = i
+	}
+	m.Statics = make([]uint32, len(s.Locals))
+	for i, v := range s.Locals {
+		m.Statics[i] = uint32(v)
+	}
+	m.Globals = make([]uint32, len(s.Globals))
+	for i, v := range s.Globals {
+		m.Globals[i] = uint32(v)
+	}
+	return m
+}
+
+// Start prepares a call of the subroutine at code offset with args and
+// leaves the machine at its first instruction, ready to Step.
+func (m *Machine) Start(offset int, args ...uint32) error {
+	idx, ok := m.indexOf[offset]
+	if !ok || m.Script.Opcodes[idx].GetOpcode() != opcode.OP_FN_BEGIN {
+		return fmt.Errorf("vm: no subroutine at 0x%04X", offset)
+	}
+	m.Stack = append(m.Stack[:0], args...)
+	m.Frames = []Frame{{Function: offset, ReturnPC: -1}}
+	m.frameMem = m.frameMem[:0]
+	m.handlers = nil
+	m.PC = idx
+	m.Steps = 0
+	m.Halted = false
+	return nil
+}
+
+// Call runs the subroutine at code offset with args to completion and
+// returns the values it returned.
+func (m *Machine) Call(offset int, args ...uint32) ([]uint32, error) {
+	if err := m.Start(offset, args...); err != nil {
+		return nil, err
+	}
+	if err := m.Run(); err != nil {
+		return nil, err
+	}
+	return append([]uint32(nil), m.Stack...), nil
+}
+
+// Run steps until the entry subroutine returns, an instruction fails or
+// MaxSteps instructions have run.
+func (m *Machine) Run() error {
+	for !m.Halted {
+		if m.MaxSteps > 0 && m.Steps >= m.MaxSteps {
+			return ErrStepLimit
+		}
+		if err := m.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Frame returns the innermost frame, or nil if no call is active.
+func (m *Machine) Frame() *Frame {
+	if len(m.Frames) == 0 {
+		return nil
+	}
+	return &m.Frames[len(m.Frames)-1]
+}
+
+// FrameVars returns the variables of f, parameters first.
+func (m *Machine) FrameVars(f *Frame) []uint32 {
+	if f == nil || !f.entered {
+		return nil
+	}
+	return m.frameMem[f.Base : f.Base+f.Size]
+}
+
+func (m *Machine) push(v uint32) error {
+	if m.MaxStack > 0 && len(m.Stack) >= m.MaxStack {
+		return fmt.Errorf("stack overflow")
+	}
+	m.Stack = append(m.Stack, v)
+	return nil
+}
+
+func (m *Machine) pushFloat(f float32) error { return m.push(math.Float32bits(f)) }
+
+func (m *Machine) pushBool(b bool) error {
+	if b {
+		return m.push(1)
+	}
+	return m.push(0)
+}
+
+func (m *Machine) pop() (uint32, error) {
+	if len(m.Stack) == 0 {
+		return 0, fmt.Errorf("stack underflow")
+	}
+	v := m.Stack[len(m.Stack)-1]
+	m.Stack = m.Stack[:len(m.Stack)-1]
+	return v, nil
+}
+
+// popN pops n values and returns them in the order they were pushed.
+func (m *Machine) popN(n int) ([]uint32, error) {
+	if n > len(m.Stack) {
+		return nil, fmt.Errorf("stack underflow")
+	}
+	vals := append([]uint32(nil), m.Stack[len(m.Stack)-n:]...)
+	m.Stack = m.Stack[:len(m.Stack)-n]
+	return vals, nil
+}
+
+// pop2 pops the two operands of a binary instruction, a pushed first.
+func (m *Machine) pop2() (a, b uint32, err error) {
+	vals, err := m.popN(2)
+	if err != nil {
+		return 0, 0, err
+	}
+	return vals[0], vals[1], nil
+}
+
+func (m *Machine) jump(target uint32) error {
+	idx, ok := m.indexOf[int(target)]
+	if !ok {
+		return fmt.Errorf("no instruction at 0x%04X", target)
+	}
+	m.PC = idx
+	return nil
+}
+
+// Step executes one instruction. An instruction that fails halts the
+// machine with PC at that instruction.
+func (m *Machine) Step() error {
+	if m.Halted {
+		return ErrHalted
+	}
+	if m.PC < 0 || m.PC >= len(m.Script.Opcodes) {
+		m.Halted = true
+		return &ExecError{Offset: -1, Reason: fmt.Sprintf("program counter %d outside the code", m.PC)}
+	}
+	ins := m.Script.Opcodes[m.PC]
+	pc := m.PC
+	m.PC++
+	if err := m.exec(ins); err != nil {
+		m.PC = pc
+		m.Halted = true
+		if _, ok := err.(*ThrowError); ok {
+			return err
+		}
+		return &ExecError{Offset: ins.GetOffset(), Opcode: ins.GetOpcode(), Reason: err.Error()}
+	}
+	m.Steps++
+	return nil
+}
+
+func (m *Machine) exec(ins opcode.Instruction) error {
+	op := ins.GetOpcode()
+	ops := ins.GetOperands()
+
+	if op > opcode.OP_ABORT_79 {
+		return m.push(uint32(int32(op) - 96))
+	}
+
+	switch op {
+	case opcode.OP_ADD, opcode.OP_SUB, opcode.OP_MUL, opcode.OP_DIV, opcode.OP_MOD,
+		opcode.OP_AND, opcode.OP_OR, opcode.OP_XOR:
+		a, b, err := m.pop2()
+		if err != nil {
+			return err
+		}
+		v, err := intOp(op, int32(a), int32(b))
+		if err != nil {
+			return err
+		}
+		return m.push(uint32(v))
+	case opcode.OP_CMP_EQ, opcode.OP_CMP_NE, opcode.OP_CMP_GT, opcode.OP_CMP_GE, opcode.OP_CMP_LT, opcode.OP_CMP_LE:
+		a, b, err := m.pop2()
+		if err != nil {
+			return err
+		}
+		return m.pushBool(compare(op-opcode.OP_CMP_EQ, float64(int32(a)), float64(int32(b))))
+	case opcode.OP_ADDF, opcode.OP_SUBF, opcode.OP_MULF, opcode.OP_DIVF, opcode.OP_MODF:
+		a, b, err := m.pop2()
+		if err != nil {
+			return err
+		}
+		return m.pushFloat(floatOp(op-opcode.OP_ADDF, toFloat(a), toFloat(b)))
+	case opcode.OP_CMP_EQF, opcode.OP_CMP_NEF, opcode.OP_CMP_GTF, opcode.OP_CMP_GEF, opcode.OP_CMP_LTF, opcode.OP_CMP_LEF:
+		a, b, err := m.pop2()
+		if err != nil {
+			return err
+		}
+		return m.pushBool(compare(op-opcode.OP_CMP_EQF, float64(toFloat(a)), float64(toFloat(b))))
+	case opcode.OP_ADD_VEC, opcode.OP_SUB_VEC, opcode.OP_MUL_VEC, opcode.OP_DIV_VEC:
+		vals, err := m.popN(6)
+		if err != nil {
+			return err
+		}
+		for i := range 3 {
+			f := floatOp(op-opcode.OP_ADD_VEC, toFloat(vals[i]), toFloat(vals[i+3]))
+			if err := m.pushFloat(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	case opcode.OP_NEG_VEC:
+		vals, err := m.popN(3)
+		if err != nil {
+			return err
+		}
+		for _, v := range vals {
+			if err := m.pushFloat(-toFloat(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case opcode.OP_IS_ZERO:
+		v, err := m.pop()
+		if err != nil {
+			return err
+		}
+		return m.pushBool(v == 0)
+	case opcode.OP_NEG:
+		v, err := m.pop()
+		if err != nil {
+			return err
+		}
+		return m.push(uint32(-int32(v)))
+	case opcode.OP_NEGF:
+		v, err := m.pop()
+		if err != nil {
+			return err
+		}
+		return m.pushFloat(-toFloat(v))
+	case opcode.OP_TO_F:
+		v, err := m.pop()
+		if err != nil {
+			return err
+		}
+		return m.pushFloat(float32(int32(v)))
+	case opcode.OP_FROM_F:
+		v, err := m.pop()
+		if err != nil {
+			return err
+		}
+		return m.push(uint32(int32(toFloat(v))))
+	case opcode.OP_VEC_FROM_F:
+		v, err := m.pop()
+		if err != nil {
+			return err
+		}
+		for range 3 {
+			if err := m.push(v); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case opcode.OP_JUMP:
+		return m.jump(ops[0].(uint32))
+	case opcode.OP_JUMP_FALSE, opcode.OP_JUMP_TRUE:
+		v, err := m.pop()
+		if err != nil {
+			return err
+		}
+		if (v != 0) == (op == opcode.OP_JUMP_TRUE) {
+			return m.jump(ops[0].(uint32))
+		}
+		return nil
+	case opcode.OP_SWITCH:
+		v, err := m.pop()
+		if err != nil {
+			return err
+		}
+		for _, c := range ins.(*opcode.Switch).Cases {
+			if uint32(c.Value) == v {
+				return m.jump(c.Target)
+			}
+		}
+		return nil
+
+	case opcode.OP_PUSH:
+		return m.push(ops[0].(uint32))
+	case opcode.OP_PUSHS:
+		return m.push(uint32(int32(int16(ops[0].(uint16)))))
+	case opcode.OP_PUSHF:
+		return m.pushFloat(ops[0].(float32))
+	case opcode.OP_PUSH_STRING:
+		return m.push(m.literal(ins.GetOffset(), ops[0].(string)))
+	case opcode.OP_NULL_OBJ:
+		return m.push(0)
+	case opcode.OP_DUP:
+		v, err := m.pop()
+		if err != nil {
+			return err
+		}
+		m.push(v)
+		return m.push(v)
+	case opcode.OP_POP:
+		_, err := m.pop()
+		return err
+
+	case opcode.OP_CALL_NATIVE:
+		args := ins.GetArgs()
+		return m.callNative(ins.GetOffset(), binary.LittleEndian.Uint32(args[2:6]), int(args[0]), int(args[1]))
+	case opcode.OP_CALL:
+		target := ops[0].(uint32)
+		f := Frame{Function: int(target), ReturnPC: m.PC}
+		if err := m.jump(target); err != nil {
+			return err
+		}
+		m.Frames = append(m.Frames, f)
+		return nil
+	case opcode.OP_FN_BEGIN:
+		return m.enter(int(ops[0].(uint8)), int(ops[1].(uint16)))
+	case opcode.OP_FN_END:
+		return m.leave(int(ops[1].(uint8)))
+
+	case opcode.OP_REF_GET:
+		p, err := m.pop()
+		if err != nil {
+			return err
+		}
+		v, err := m.Load(p)
+		if err != nil {
+			return err
+		}
+		return m.push(v)
+	case opcode.OP_REF_SET, opcode.OP_REF_PEEK_SET:
+		p, err := m.pop()
+		if err != nil {
+			return err
+		}
+		v, err := m.pop()
+		if err != nil {
+			return err
+		}
+		if err := m.Store(p, v); err != nil {
+			return err
+		}
+		if op == opcode.OP_REF_PEEK_SET {
+			return m.push(v)
+		}
+		return nil
+	case opcode.OP_ARRAY_EXPLODE:
+		p, n, err := m.popPointerCount()
+		if err != nil {
+			return err
+		}
+		for i := range n {
+			v, err := m.Load(p + uint32(4*i))
+			if err != nil {
+				return err
+			}
+			if err := m.push(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case opcode.OP_ARRAY_IMPLODE:
+		p, n, err := m.popPointerCount()
+		if err != nil {
+			return err
+		}
+		vals, err := m.popN(n)
+		if err != nil {
+			return err
+		}
+		for i, v := range vals {
+			if err := m.Store(p+uint32(4*i), v); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case opcode.OP_VAR0, opcode.OP_VAR1, opcode.OP_VAR2, opcode.OP_VAR3,
+		opcode.OP_VAR4, opcode.OP_VAR5, opcode.OP_VAR6, opcode.OP_VAR7:
+		return m.pushFrameVar(int(op - opcode.OP_VAR0))
+	case opcode.OP_VAR:
+		v, err := m.pop()
+		if err != nil {
+			return err
+		}
+		return m.pushFrameVar(int(int32(v)))
+	case opcode.OP_LOCAL_VAR, opcode.OP_GLOBAL_VAR:
+		v, err := m.pop()
+		if err != nil {
+			return err
+		}
+		region, mem := RegionStatic, m.Statics
+		if op == opcode.OP_GLOBAL_VAR {
+			region, mem = RegionGlobal, m.Globals
+		}
+		if int32(v) < 0 || int(v) >= len(mem) {
+			return fmt.Errorf("index %d out of range for %d slots", int32(v), len(mem))
+		}
+		return m.push(Pointer(region, 4*int(v)))
+	case opcode.OP_ARRAY_REF:
+		vals, err := m.popN(3)
+		if err != nil {
+			return err
+		}
+		p, idx, size := vals[0], int32(vals[1]), int32(vals[2])
+		count, err := m.Load(p)
+		if err != nil {
+			return err
+		}
+		if idx < 0 || uint32(idx) >= count {
+			return fmt.Errorf("array index %d out of range for %d elements", idx, count)
+		}
+		return m.push(p + 4 + uint32(idx*size*4))
+
+	case opcode.OP_STR_CPY, opcode.OP_INT_TO_STR, opcode.OP_STR_CAT, opcode.OP_STR_CAT_I:
+		return m.stringOp(op, int(ops[0].(uint8)))
+	case opcode.OP_STR_VAR_CPY:
+		// Copies a block of words: destination, word count and source are
+		// popped in that order.
+		dst, err := m.pop()
+		if err != nil {
+			return err
+		}
+		n, err := m.pop()
+		if err != nil {
+			return err
+		}
+		src, err := m.pop()
+		if err != nil {
+			return err
+		}
+		for i := range int(n) {
+			v, err := m.Load(src + uint32(4*i))
+			if err != nil {
+				return err
+			}
+			if err := m.Store(dst+uint32(4*i), v); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case opcode.OP_CATCH:
+		m.handlers = append(m.handlers, handler{frames: len(m.Frames), stack: len(m.Stack), pc: m.PC})
+		return m.push(0)
+	case opcode.OP_THROW:
+		v, err := m.pop()
+		if err != nil {
+			return err
+		}
+		return m.throw(ins.GetOffset(), v)
+	case opcode.OP_GET_PROTECT, opcode.OP_SET_PROTECT, opcode.OP_REF_PROTECT:
+		// Variable protection is an anti-tamper feature of the game and has
+		// no effect on the values scripts compute.
+		return nil
+	case opcode.OP_ABORT_79:
+		return fmt.Errorf("script aborted")
+	}
+	return fmt.Errorf("unknown opcode %d", op)
+}
+
+func (m *Machine) popPointerCount() (uint32, int, error) {
+	p, err := m.pop()
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := m.pop()
+	if err != nil {
+		return 0, 0, err
+	}
+	if int32(n) < 0 {
+		return 0, 0, fmt.Errorf("negative count %d", int32(n))
+	}
+	return p, int(n), nil
+}
+
+func (m *Machine) pushFrameVar(slot int) error {
+	f := m.Frame()
+	if f == nil || !f.entered {
+		return fmt.Errorf("no active frame")
+	}
+	if slot < 0 || slot >= f.Size {
+		return fmt.Errorf("frame variable %d out of range for %d slots", slot, f.Size)
+	}
+	return m.push(Pointer(RegionFrame, 4*(f.Base+slot)))
+}
+
+// enter sets up the frame of the subroutine being called, moving its
+// parameters from the stack into the first variables.
+func (m *Machine) enter(params, size int) error {
+	f := m.Frame()
+	if f == nil || f.entered {
+		return fmt.Errorf("subroutine entered without a call")
+	}
+	args, err := m.popN(params)
+	if err != nil {
+		return err
+	}
+	size = max(size, params)
+	f.Base, f.Size, f.Params, f.entered = len(m.frameMem), size, params, true
+	m.frameMem = append(m.frameMem, make([]uint32, size)...)
+	copy(m.frameMem[f.Base:], args)
+	return nil
+}
+
+// leave returns from the current subroutine with its top returns values.
+func (m *Machine) leave(returns int) error {
+	f := m.Frame()
+	if f == nil || !f.entered {
+		return fmt.Errorf("return without an active frame")
+	}
+	if returns > len(m.Stack) {
+		return fmt.Errorf("stack underflow returning %d values", returns)
+	}
+	m.frameMem = m.frameMem[:f.Base]
+	ret := f.ReturnPC
+	m.Frames = m.Frames[:len(m.Frames)-1]
+	for len(m.handlers) > 0 && m.handlers[len(m.handlers)-1].frames > len(m.Frames) {
+		m.handlers = m.handlers[:len(m.handlers)-1]
+	}
+	if ret == -1 {
+		m.Halted = true
+		return nil
+	}
+	m.PC = ret
+	return nil
+}
+
+// throw unwinds to the innermost Catch, which resumes with v on the stack.
+func (m *Machine) throw(offset int, v uint32) error {
+	if len(m.handlers) == 0 {
+		return &ThrowError{Offset: offset, Value: v}
+	}
+	h := m.handlers[len(m.handlers)-1]
+	m.handlers = m.handlers[:len(m.handlers)-1]
+	if h.frames < len(m.Frames) {
+		m.frameMem = m.frameMem[:m.Frames[h.frames].Base]
+		m.Frames = m.Frames[:h.frames]
+	}
+	m.Stack = m.Stack[:h.stack]
+	m.PC = h.pc
+	return m.push(v)
+}
+
+// stringOp runs a string instruction on the size byte buffer whose pointer
+// is on top of the stack, below which is the source string or integer.
+func (m *Machine) stringOp(op uint8, size int) error {
+	dst, err := m.pop()
+	if err != nil {
+		return err
+	}
+	src, err := m.pop()
+	if err != nil {
+		return err
+	}
+
+	var s string
+	switch op {
+	case opcode.OP_STR_CPY, opcode.OP_STR_CAT:
+		if s, err = m.String(src); err != nil {
+			return err
+		}
+	case opcode.OP_INT_TO_STR, opcode.OP_STR_CAT_I:
+		s = strconv.Itoa(int(int32(src)))
+	}
+	if op == opcode.OP_STR_CAT || op == opcode.OP_STR_CAT_I {
+		cur, err := m.String(dst)
+		if err != nil {
+			return err
+		}
+		s = cur + s
+	}
+	return m.storeString(dst, s, size)
+}
+
+func toFloat(v uint32) float32 { return math.Float32frombits(v) }
+
+// intOp applies an integer instruction with a pushed before b.
+func intOp(op uint8, a, b int32) (int32, error) {
+	switch op {
+	case opcode.OP_ADD:
+		return a + b, nil
+	case opcode.OP_SUB:
+		return a - b, nil
+	case opcode.OP_MUL:
+		return a * b, nil
+	case opcode.OP_DIV, opcode.OP_MOD:
+		if b == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if op == opcode.OP_DIV {
+			return a / b, nil
+		}
+		return a % b, nil
+	case opcode.OP_AND:
+		return a & b, nil
+	case opcode.OP_OR:
+		return a | b, nil
+	}
+	return a ^ b, nil
+}
+
+// floatOp applies the n-th of add, sub, mul, div and mod.
+func floatOp(n uint8, a, b float32) float32 {
+	switch n {
+	case 0:
+		return a + b
+	case 1:
+		return a - b
+	case 2:
+		return a * b
+	case 3:
+		return a / b
+	}
+	return float32(math.Mod(float64(a), float64(b)))
+}
+
+// compare applies the n-th of eq, ne, gt, ge, lt and le.
+func compare(n uint8, a, b float64) bool {
+	switch n {
+	case 0:
+		return a == b
+	case 1:
+		return a != b
+	case 2:
+		return a > b
+	case 3:
+		return a >= b
+	case 4:
+		return a < b
+	}
+	return a <= b
+}
@@ -0,0 +1,251 @@
+package vm
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/mrchip53/gta-tools/rage"
+	"github.com/mrchip53/gta-tools/rage/script"
+	"github.com/mrchip53/gta-tools/rage/script/asm"
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+// assemble builds a plain script from source with statics zeroed slots.
+func assemble(t *testing.T, src string, statics int) *script.RageScript {
+	t.Helper()
+	code, err := asm.AssembleCode(src)
+	if err != nil {
+		t.Fatalf("Failed to assemble: %v", err)
+	}
+	s := script.NewRageScript(rage.NewFileEntry("test.sco", script.PlainBytes(code, make([]uint, statics), nil)))
+	return &s
+}
+
+func TestCallRunsSubroutinesWithFrames(t *testing.T) {
+	s := assemble(t, `
+main:
+	FnBegin 0 1
+	Push 6
+	Push 7
+	Call mul
+	Var0
+	RefSet
+	Var0
+	RefGet
+	PushD 1
+	Add
+	FnEnd 0 1
+mul:
+	FnBegin 2 2
+	Var0
+	RefGet
+	Var1
+	RefGet
+	Mul
+	FnEnd 2 1
+`, 0)
+	m := New(s)
+	res, err := m.Call(0)
+	if err != nil {
+		t.Fatalf("Failed to run: %v", err)
+	}
+	if len(res) != 1 || res[0] != 43 {
+		t.Errorf("Expected [43], got %v", res)
+	}
+	if !m.Halted || len(m.Frames) != 0 {
+		t.Errorf("Expected the machine to halt with no frames, got %+v", m.Frames)
+	}
+}
+
+func TestLoopsAndSwitches(t *testing.T) {
+	s := assemble(t, `
+	FnBegin 1 3
+	; var1 = sum of 1..var0
+loop:
+	Var0
+	RefGet
+	JumpFalse done
+	Var1
+	RefGet
+	Var0
+	RefGet
+	Add
+	Var1
+	RefSet
+	Var0
+	RefGet
+	PushD 1
+	Sub
+	Var0
+	RefSet
+	Jump loop
+done:
+	Var1
+	RefGet
+	Switch 15:fifteen 3:three
+	PushD -1
+	FnEnd 1 1
+fifteen:
+	PushD 1
+	FnEnd 1 1
+three:
+	PushD 2
+	FnEnd 1 1
+`, 0)
+	m := New(s)
+	for _, tc := range []struct{ n, want uint32 }{{5, 1}, {2, 2}, {3, math.MaxUint32}} {
+		res, err := m.Call(0, tc.n)
+		if err != nil {
+			t.Fatalf("Failed to run with %d: %v", tc.n, err)
+		}
+		if len(res) != 1 || res[0] != tc.want {
+			t.Errorf("With %d expected %d, got %v", tc.n, tc.want, res)
+		}
+	}
+}
+
+func TestNativesGoThroughHandlers(t *testing.T) {
+	s := assemble(t, `
+	FnBegin 0 0
+	CallNative TIMERA in=0 out=1
+	PushD 2
+	CallNative WAIT in=1 out=0
+	FnEnd 0 1
+`, 0)
+	m := New(s)
+	if err := m.RegisterNative("TIMERA", func(m *Machine, args []uint32) ([]uint32, error) {
+		return []uint32{1234}, nil
+	}); err != nil {
+		t.Fatalf("Failed to register native: %v", err)
+	}
+	if err := m.RegisterNative("NOT_A_NATIVE", Stub); err == nil {
+		t.Errorf("Expected an error for an unknown native")
+	}
+	var calls []NativeCall
+	m.OnNative = func(c NativeCall) { calls = append(calls, c) }
+
+	res, err := m.Call(0)
+	if err != nil {
+		t.Fatalf("Failed to run: %v", err)
+	}
+	if len(res) != 1 || res[0] != 1234 {
+		t.Errorf("Expected [1234], got %v", res)
+	}
+	if len(calls) != 2 || calls[1].Name != "WAIT" || len(calls[1].Args) != 1 || calls[1].Args[0] != 2 {
+		t.Fatalf("Unexpected native log %v", calls)
+	}
+	if s := calls[1].String(); s != "WAIT(ms: 2) -> []" {
+		t.Errorf("Expected named arguments, got %s", s)
+	}
+}
+
+func TestStringsAndVectors(t *testing.T) {
+	s := assemble(t, `
+	FnBegin 0 0
+	PushString "ab"
+	PushD 0
+	LocalVar
+	StrCpy 8
+	PushD 5
+	PushD 0
+	LocalVar
+	StrCatI 8
+	PushF 1
+	PushF 2
+	PushF 3
+	PushF 0.5
+	VecFromF
+	AddVec
+	FnEnd 0 3
+`, 2)
+	m := New(s)
+	res, err := m.Call(0)
+	if err != nil {
+		t.Fatalf("Failed to run: %v", err)
+	}
+	if str, err := m.String(Pointer(RegionStatic, 0)); err != nil || str != "ab5" {
+		t.Errorf("Expected ab5, got %q (%v)", str, err)
+	}
+	want := []float32{1.5, 2.5, 3.5}
+	for i, v := range res {
+		if math.Float32frombits(v) != want[i] {
+			t.Errorf("Expected %v, got %v", want[i], math.Float32frombits(v))
+		}
+	}
+}
+
+func TestErrorsStopTheMachine(t *testing.T) {
+	s := assemble(t, `
+	FnBegin 0 0
+	PushD 1
+	PushD 0
+	Div
+	FnEnd 0 1
+`, 0)
+	m := New(s)
+	var execErr *ExecError
+	if _, err := m.Call(0); !errors.As(err, &execErr) || execErr.Offset != 6 {
+		t.Fatalf("Expected an ExecError at 0x06, got %v", err)
+	}
+	if !m.Halted || m.PC != 3 {
+		t.Errorf("Expected the machine to halt at the Div, got PC %d", m.PC)
+	}
+	if err := m.Step(); !errors.Is(err, ErrHalted) {
+		t.Errorf("Expected ErrHalted, got %v", err)
+	}
+
+	s = assemble(t, `
+	FnBegin 0 0
+loop:
+	Jump loop
+`, 0)
+	m = New(s)
+	m.MaxSteps = 100
+	if _, err := m.Call(0); !errors.Is(err, ErrStepLimit) {
+		t.Errorf("Expected ErrStepLimit, got %v", err)
+	}
+}
+
+func TestThrowUnwindsToCatch(t *testing.T) {
+	s := assemble(t, `
+main:
+	FnBegin 0 0
+	Catch
+	Dup
+	JumpTrue caught
+	Call thrower
+	PushD 0
+	FnEnd 0 1
+caught:
+	PushD 7
+	Add
+	FnEnd 0 1
+thrower:
+	FnBegin 0 0
+	PushD 9
+	Throw
+	FnEnd 0 0
+`, 0)
+	m := New(s)
+	res, err := m.Call(0)
+	if err != nil {
+		t.Fatalf("Failed to run: %v", err)
+	}
+	// The throw resumes after the Catch with the thrown 9 in place of 0.
+	if len(res) != 1 || res[0] != 16 {
+		t.Errorf("Expected [16], got %v", res)
+	}
+
+	thrower := -1
+	for _, ins := range s.Opcodes[1:] {
+		if ins.GetOpcode() == opcode.OP_FN_BEGIN {
+			thrower = ins.GetOffset()
+			break
+		}
+	}
+	var throwErr *ThrowError
+	if _, err := m.Call(thrower); !errors.As(err, &throwErr) || throwErr.Value != 9 {
+		t.Errorf("Expected an uncaught throw of 9, got %v", err)
+	}
+}
@@ -0,0 +1,159 @@
+package script
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+type RefKind int
+
+const (
+	RefCall RefKind = iota
+	RefNative
+	RefLocal
+	RefGlobal
+	RefString
+)
+
+// Ref is a single instruction referring to a subroutine, native, static or
+// string.
+type Ref struct {
+	Kind   RefKind
+	Index  int // instruction index
+	Offset int // code offset
+	Sub    string
+}
+
+func (r Ref) String() string {
+	return fmt.Sprintf("0x%04X %s", r.Offset, r.Sub)
+}
+
+// XrefIndex maps everything a script refers to onto the instructions doing
+// the referring. Locals and globals are only indexed where the variable
+// index is pushed as a constant right before LocalVar/GlobalVar.
+type XrefIndex struct {
+	Calls   map[int][]Ref // keyed by subroutine offset
+	Natives map[uint32][]Ref
+	Locals  map[int][]Ref
+	Globals map[int][]Ref
+	Strings map[string][]Ref
+}
+
+// Xrefs scans the disassembly and builds a cross-reference index.
+func (r *RageScript) Xrefs() *XrefIndex {
+	x := &XrefIndex{
+		Calls:   make(map[int][]Ref),
+		Natives: make(map[uint32][]Ref),
+		Locals:  make(map[int][]Ref),
+		Globals: make(map[int][]Ref),
+		Strings: make(map[string][]Ref),
+	}
+
+	sub := ""
+	for i, ins := range r.Opcodes {
+		op := ins.GetOpcode()
+		if op == opcode.OP_FN_BEGIN {
+			sub = r.Subroutines[ins.GetOffset()]
+		}
+		ref := Ref{Index: i, Offset: ins.GetOffset(), Sub: sub}
+
+		switch op {
+		case opcode.OP_CALL:
+			ref.Kind = RefCall
+			target := int(ins.GetOperands()[0].(uint32))
+			x.Calls[target] = append(x.Calls[target], ref)
+		case opcode.OP_CALL_NATIVE:
+			ref.Kind = RefNative
+			hash := nativeHash(ins)
+			x.Natives[hash] = append(x.Natives[hash], ref)
+		case opcode.OP_PUSH_STRING:
+			ref.Kind = RefString
+			s := ins.GetOperands()[0].(string)
+			x.Strings[s] = append(x.Strings[s], ref)
+		case opcode.OP_LOCAL_VAR, opcode.OP_GLOBAL_VAR:
+			if i == 0 {
+				break
+			}
+			n, ok := PushedConstant(r.Opcodes[i-1])
+			if !ok {
+				break
+			}
+			if op == opcode.OP_LOCAL_VAR {
+				ref.Kind = RefLocal
+				x.Locals[n] = append(x.Locals[n], ref)
+			} else {
+				ref.Kind = RefGlobal
+				x.Globals[n] = append(x.Globals[n], ref)
+			}
+		}
+	}
+	return x
+}
+
+// RefsAt returns the references relevant to the instruction at index
+// together with a short description of what they refer to. A FnBegin or
+// Call yields the callers of the subroutine, a native call every use of
+// that native, a string push every push of that string and a static access
+// (or the constant pushed for it) every access to the same variable.
+func (x *XrefIndex) RefsAt(r *RageScript, index int) (string, []Ref) {
+	if index < 0 || index >= len(r.Opcodes) {
+		return "", nil
+	}
+	ins := r.Opcodes[index]
+	switch ins.GetOpcode() {
+	case opcode.OP_FN_BEGIN:
+		return "Callers of " + r.Subroutines[ins.GetOffset()], x.Calls[ins.GetOffset()]
+	case opcode.OP_CALL:
+		target := int(ins.GetOperands()[0].(uint32))
+		return "Callers of " + r.Subroutines[target], x.Calls[target]
+	case opcode.OP_CALL_NATIVE:
+		return "Uses of " + ins.GetOperands()[0].(string), x.Natives[nativeHash(ins)]
+	case opcode.OP_PUSH_STRING:
+		s := ins.GetOperands()[0].(string)
+		return "Pushes of " + opcode.QuoteString(s), x.Strings[s]
+	case opcode.OP_LOCAL_VAR, opcode.OP_GLOBAL_VAR:
+		if index > 0 {
+			return x.varRefs(ins.GetOpcode(), r.Opcodes[index-1])
+		}
+	default:
+		if index+1 < len(r.Opcodes) {
+			next := r.Opcodes[index+1].GetOpcode()
+			if next == opcode.OP_LOCAL_VAR || next == opcode.OP_GLOBAL_VAR {
+				return x.varRefs(next, ins)
+			}
+		}
+	}
+	return "", nil
+}
+
+func (x *XrefIndex) varRefs(op uint8, push opcode.Instruction) (string, []Ref) {
+	n, ok := PushedConstant(push)
+	if !ok {
+		return "", nil
+	}
+	if op == opcode.OP_LOCAL_VAR {
+		return fmt.Sprintf("Accesses of local %d", n), x.Locals[n]
+	}
+	return fmt.Sprintf("Accesses of global %d", n), x.Globals[n]
+}
+
+// PushedConstant returns the integer an instruction pushes if it is one of
+// the integer push forms.
+func PushedConstant(ins opcode.Instruction) (int, bool) {
+	op := ins.GetOpcode()
+	switch {
+	case op == opcode.OP_PUSHS:
+		return int(int16(ins.GetOperands()[0].(uint16))), true
+	case op == opcode.OP_PUSH:
+		return int(int32(ins.GetOperands()[0].(uint32))), true
+	case op > opcode.OP_ABORT_79:
+		return int(op) - 96, true
+	}
+	return 0, false
+}
+
+func nativeHash(ins opcode.Instruction) uint32 {
+	return binary.LittleEndian.Uint32(ins.GetArgs()[2:6])
+}
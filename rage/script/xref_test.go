@@ -0,0 +1,65 @@
+package script
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/mrchip53/gta-tools/rage/script/opcode"
+)
+
+func TestXrefsIndexesReferences(t *testing.T) {
+	var code []byte
+	code = append(code, opcode.OP_FN_BEGIN, 0, 0, 0) // 0x00
+	code = append(code, opcode.OP_CALL)              // 0x04
+	code = binary.LittleEndian.AppendUint32(code, 0x1B)
+	code = append(code, opcode.OP_PUSH_STRING, 3, 'h', 'i', 0) // 0x09
+	code = append(code, opcode.OP_CALL_NATIVE, 1, 0)           // 0x0E
+	code = binary.LittleEndian.AppendUint32(code, 0x12345678)
+	code = append(code, 0x62, opcode.OP_LOCAL_VAR, opcode.OP_REF_GET) // 0x15
+	code = append(code, opcode.OP_FN_END, 0, 0)                       // 0x18
+	code = append(code, opcode.OP_FN_BEGIN, 0, 0, 0)                  // 0x1B
+	code = append(code, 0x62, opcode.OP_LOCAL_VAR, opcode.OP_POP)     // 0x1F
+	code = append(code, opcode.OP_PUSHS, 0xFE, 0xFF, opcode.OP_POP)   // 0x22
+	code = append(code, 0x5F, opcode.OP_POP)                          // 0x26
+	code = append(code, opcode.OP_FN_END, 0, 0)                       // 0x28
+
+	rs := newTestScript(t, code, []uint{0, 0, 0}, nil)
+	x := rs.Xrefs()
+
+	if refs := x.Calls[0x1B]; len(refs) != 1 || refs[0].Offset != 0x04 {
+		t.Errorf("Expected one call from 0x0004, got %v", refs)
+	}
+	if refs := x.Natives[0x12345678]; len(refs) != 1 || refs[0].Offset != 0x0E {
+		t.Errorf("Expected one native use at 0x000E, got %v", refs)
+	}
+	if refs := x.Strings["hi\x00"]; len(refs) != 1 {
+		t.Errorf("Expected one string push, got %v", refs)
+	}
+	if refs := x.Locals[2]; len(refs) != 2 || refs[1].Sub != rs.Subroutines[0x1B] {
+		t.Errorf("Expected two accesses of local 2, got %v", refs)
+	}
+
+	for i, want := range map[int]int{12: -2, 14: -1} {
+		if c, ok := PushedConstant(rs.Opcodes[i]); !ok || c != want {
+			t.Errorf("Expected %s to push %d, got %d", rs.Opcodes[i].String("", nil), want, c)
+		}
+	}
+	if s := rs.Opcodes[14].String("", nil); !strings.HasSuffix(s, " -1") {
+		t.Errorf("Expected PushD -1, got %q", s)
+	}
+
+	for i, ins := range rs.Opcodes {
+		if ins.GetOffset() != 0x1B {
+			continue
+		}
+		title, refs := x.RefsAt(&rs, i)
+		if len(refs) != 1 || title == "" {
+			t.Errorf("Expected callers at FnBegin, got %q %v", title, refs)
+		}
+		title, refs = x.RefsAt(&rs, i+1)
+		if len(refs) != 2 || title != "Accesses of local 2" {
+			t.Errorf("Expected local accesses for pushed index, got %q %v", title, refs)
+		}
+	}
+}
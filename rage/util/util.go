@@ -4,9 +4,13 @@ import (
 	"crypto/aes"
 	"crypto/sha1"
 	"encoding/hex"
-	"fmt"
+	"errors"
 )
 
+// ErrAesKeyNotSet is returned by Decrypt and Encrypt before FindAesKey has
+// located a valid key.
+var ErrAesKeyNotSet = errors.New("AES key not set")
+
 var (
 	offsets = []int{0xA94204, 0xB607C4, 0xB56BC4, 0xB75C9C, 0xB7AEF4, 0xBE6540, 0xBE7540, 0xC95FD8, 0xC5B33C, 0xC5B73C}
 	aesKey  []byte
@@ -22,6 +26,13 @@ func FindAesKey(exeBytes []byte) {
 	}
 }
 
+// SetAesKey uses key for Decrypt and Encrypt without checking it against
+// the game's key, as when it came from somewhere other than the exe. A nil
+// key unsets it.
+func SetAesKey(key []byte) {
+	aesKey = key
+}
+
 func ValidateAesKey(aesKey []byte) bool {
 	targetHash := "DEA375EF1E6EF2223A1221C2C575C47BF17EFA5E"
 	expectedHash, err := hex.DecodeString(targetHash)
@@ -46,7 +57,7 @@ func ValidateAesKey(aesKey []byte) bool {
 
 func Decrypt(data []byte) error {
 	if aesKey == nil {
-		return fmt.Errorf("AES key not set")
+		return ErrAesKeyNotSet
 	}
 	cipher, err := aes.NewCipher(aesKey)
 	if err != nil {
@@ -68,7 +79,7 @@ func Decrypt(data []byte) error {
 
 func Encrypt(data []byte) error {
 	if aesKey == nil {
-		return fmt.Errorf("AES key not set")
+		return ErrAesKeyNotSet
 	}
 	cipher, err := aes.NewCipher(aesKey)
 	if err != nil {
@@ -26,30 +26,25 @@ func TestSearchScriptsInImg(t *testing.T) {
 		t.Fatalf("Failed to read scriptf file: %v", err)
 	}
 
-	imgFile := img.LoadImgFile(scriptBytes)
+	imgFile, err := img.LoadImgFile(scriptBytes)
+	if err != nil {
+		t.Fatalf("Failed to load img file: %v", err)
+	}
 
 	for _, entry := range imgFile.Entries() {
 		if strings.HasSuffix(entry.Name(), ".sco") {
-			func() {
-				defer func() {
-					if r := recover(); r != nil {
-						t.Logf("Recovered from panic while processing script %s: %v", entry.Name(), r)
-					}
-				}()
-
-				rageScript := script.NewRageScript(entry)
-				if rageScript.Unsupported {
-					t.Logf("Skipping unsupported script: %s", entry.Name())
-					return
-				}
-
-				for _, instruction := range rageScript.Opcodes {
-					instructionString := instruction.String("", nil)
-					if strings.Contains(instructionString, searchTerm) {
-						t.Logf("Found '%s' in %s at offset 0x%04X", searchTerm, entry.Name(), instruction.GetOffset())
-					}
+			rageScript := script.NewRageScript(entry)
+			if rageScript.Unsupported {
+				t.Logf("Skipping unsupported script %s: %v", entry.Name(), rageScript.Err)
+				continue
+			}
+
+			for _, instruction := range rageScript.Opcodes {
+				instructionString := instruction.String("", nil)
+				if strings.Contains(instructionString, searchTerm) {
+					t.Logf("Found '%s' in %s at offset 0x%04X", searchTerm, entry.Name(), instruction.GetOffset())
 				}
-			}()
+			}
 		}
 	}
 }